@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
@@ -10,12 +12,12 @@ import (
 	"io"
 	"net/http"
 
-	"github.com/jackc/pgx/v5/pgxpool"
     "go.uber.org/zap"
 
 	"gopher-equalizer/config"
 	"gopher-equalizer/internal/logger"
 	"gopher-equalizer/internal/database"
+	"gopher-equalizer/internal/interfaces"
 	"gopher-equalizer/internal/repository"
 	"gopher-equalizer/internal/service"
 	"gopher-equalizer/internal/balancer"
@@ -24,79 +26,164 @@ import (
     health "gopher-equalizer/internal/transport/http"
 )
 
+const usage = `usage: %s [-config path] [command]
+
+commands:
+  serve    run the proxy (default)
+  migrate  up|down|redo|status [--to N], see migrate -h
+`
+
 func main() {
 	ctx := context.Background()
 	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
 	defer cancel()
 
-	srv, dbPool, err := run(ctx, os.Stdout, os.Args);
-	if err != nil {
+	if err := run(ctx, os.Stdout, os.Args); err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
+		cancel()
 		os.Exit(1)
 	}
-	defer dbPool.Close()
+}
+
+// run разбирает флаги и запускает подкоманду: serve (по умолчанию) или migrate
+func run(ctx context.Context, w io.Writer, args []string) error {
+    flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+    configPath := flags.String("config", "config/config.yml", "path to the config file")
+    flags.Usage = func() { fmt.Fprintf(flags.Output(), usage, args[0]) }
+    if err := flags.Parse(args[1:]); err != nil {
+        if errors.Is(err, flag.ErrHelp) {
+            return nil
+        }
+        return err
+    }
+
+    cfg, err := config.LoadConfig(*configPath)
+    if err != nil {
+        return err
+    }
+
+    command, rest := "serve", flags.Args()
+    if len(rest) > 0 {
+        command, rest = rest[0], rest[1:]
+    }
+    switch command {
+    case "serve":
+        if len(rest) > 0 {
+            return fmt.Errorf("serve takes no arguments, got %v", rest)
+        }
+        return serve(ctx, cfg)
+    case "migrate":
+        return migrate(ctx, w, cfg, rest)
+    }
+    flags.Usage()
+    return fmt.Errorf("unknown command %q", command)
+}
+
+// serve запускает сервер и останавливает его по сигналу
+func serve(ctx context.Context, cfg *config.Config) error {
+    srv, cleanup, err := newServer(ctx, cfg)
+    if err != nil {
+        return err
+    }
+    defer cleanup()
 
-	<-ctx.Done()
-	log.Println("Shutdown signal received")
+    <-ctx.Done()
+    log.Println("Shutdown signal received")
 
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+    shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
     defer shutdownCancel()
 
     if err := srv.Shutdown(shutdownCtx); err != nil {
-        log.Fatalf("Server shutdown failed: %v", err)
+        return fmt.Errorf("server shutdown failed: %w", err)
     }
     log.Println("Server exited gracefully")
+    return nil
 }
 
-func run(ctx context.Context, w io.Writer, args []string) (*http.Server, *pgxpool.Pool, error) {
-    // 1. Конфиг и логгер
-    cfg, err := config.LoadConfig("config/config.yml")
-    if err != nil {
-        return nil, nil, err
-    }
-    ctx, err = logger.New(ctx, cfg)
+// newServer возвращает cleanup, который освобождает хранилище бакетов
+func newServer(ctx context.Context, cfg *config.Config) (*http.Server, func(), error) {
+    // 1. Логгер
+    ctx, err := logger.New(ctx, cfg)
     if err != nil {
         return nil, nil, err
     }
     log := logger.GetLoggerFromCtx(ctx)
 
-    // 2. Подключение к БД и миграции
-    dbPool, err := database.Connect(ctx, cfg)
+    // 2. Хранилище бакетов и остальных алгоритмов, bucket-сервис
+    var (
+        repo     interfaces.IBucketRepository
+        limiters map[string]interfaces.ILimiter
+    )
+    cleanup := func() {}
+    switch cfg.Storage.DriverName() {
+    case config.StorageMemory:
+        log.Info(ctx, "buckets are stored in memory and are lost on restart")
+        repo = repository.NewMemoryRepository(cfg)
+        limiters = repository.NewMemoryLimiters(cfg)
+    case config.StorageSQLite:
+        sqliteDB, err := database.ConnectSQLite(ctx, cfg)
+        if err != nil {
+            return nil, nil, err
+        }
+        m, err := sqliteMigrator(cfg, sqliteDB)
+        if err != nil {
+            return nil, nil, err
+        }
+        if err := migrateOnStart(ctx, cfg, m); err != nil {
+            return nil, nil, err
+        }
+        repo = repository.NewSQLiteRepository(sqliteDB, cfg)
+        limiters = repository.NewSQLiteLimiters(sqliteDB, cfg)
+        cleanup = func() { sqliteDB.Close() }
+    default:
+        repo, limiters, cleanup, err = newPostgresRepository(ctx, cfg)
+        if err != nil {
+            return nil, nil, err
+        }
+    }
+    bSrv := service.NewBucketService(cfg, repo).WithLimiters(limiters)
+    log.Info(ctx, "rate limiting", zap.String("algorithm", cfg.Bucket.AlgorithmFor("")))
+
+    // 3. Балансировщик и хелф-чекер
+    strat, err := balancer.CreateStrategy(cfg.Balancer)
     if err != nil {
         return nil, nil, err
     }
-    if err := database.RunMigrations(ctx, cfg, dbPool); err != nil {
+    keyFn, err := balancer.NewKeyFunc(cfg.Balancer.Hash)
+    if err != nil {
         return nil, nil, err
     }
+    bal := balancer.NewBalancer(strat, keyFn)
+    registry := balancer.NewRegistry(cfg.Balancer.Backends)
+    healcheck := health.NewHealthChecker(cfg, bal, registry)
 
-    // 3. Репозиторий и bucket-сервис
-    repo := repository.NewBucketRepository(dbPool, cfg)
-    bSrv := service.NewBucketService(cfg, repo)
+    // 4. Запускаем хелф-чекер
+    healcheck.StartHealthChecks(ctx)
 
-    // 4. HTTP-API для управления buckets
-    apiH := api.NewHandler(ctx, cfg, bSrv)
+    // 5. HTTP-API для управления buckets и пулом бэкендов
+    apiH := api.NewHandler(ctx, cfg, bSrv, healcheck, registry)
     apiMux := api.NewRouter(apiH)
 
-    // 5. Балансировщик и прокси
-    strat, err := balancer.CreateStrategy(cfg.Balancer.Strategy, cfg.Balancer.Backends)
+    costFn, err := proxy.NewCostFunc(cfg.Bucket.Cost)
     if err != nil {
         return nil, nil, err
     }
-    bal := balancer.NewBalancer(strat)
-    healcheck := health.NewHealthChecker(cfg, bal)
-
-    // 6. Запускаем хелф-чекер
-    healcheck.StartHealthChecks(ctx)
-
-    proxy := proxy.NewProxy(cfg, bal, bSrv, log)
+    identityFn, err := proxy.NewIdentityFunc(cfg.Bucket.Identity)
+    if err != nil {
+        return nil, nil, err
+    }
+    policyFn := proxy.NewPolicyFunc(cfg.Bucket.Policies)
+    proxy := proxy.NewProxy(cfg, bal, healcheck, registry, bSrv, costFn, identityFn, policyFn, log)
 
-    // 7. Общий mux: сначала API, потом прокси «на всё остальное»
+    // 6. Общий mux: сначала API, потом прокси «на всё остальное»
     mux := http.NewServeMux()
     mux.Handle("/buckets", apiMux)
     mux.Handle("/buckets/", apiMux)
+    mux.Handle("/backends", apiMux)
+    mux.Handle("/backends/", apiMux)
     mux.Handle("/", proxy)
 
-    // 8. HTTP-сервер
+    // 7. HTTP-сервер
     addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
     srv := &http.Server{
         Addr:    addr,
@@ -109,5 +196,55 @@ func run(ctx context.Context, w io.Writer, args []string) (*http.Server, *pgxpoo
         }
     }()
 
-    return srv, dbPool, nil
+    return srv, cleanup, nil
+}
+
+// newPostgresRepository подключается к Postgres и собирает репозиторий с кешем
+// и оповещением реплик, если они включены, и остальные алгоритмы (без кеша).
+// cleanup дожидается последнего сброса кеша и закрывает пул бд
+func newPostgresRepository(ctx context.Context, cfg *config.Config) (interfaces.IBucketRepository, map[string]interfaces.ILimiter, func(), error) {
+    // подключение к БД и миграции
+    dbPool, err := database.Connect(ctx, cfg)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    m, err := postgresMigrator(cfg, dbPool)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    if err := migrateOnStart(ctx, cfg, m); err != nil {
+        return nil, nil, nil, err
+    }
+    limiters := repository.NewPostgresLimiters(dbPool, cfg)
+
+    store := repository.NewBucketRepository(dbPool, cfg)
+    replica := repository.NewReplicaID()
+    if cfg.Bucket.Notify.Enabled {
+        // о записях через API узнают остальные реплики
+        store = store.WithNotifications(cfg.Bucket.Notify.ChannelName(), replica)
+    }
+    var repo interfaces.IBucketRepository = store
+    cleanup := dbPool.Close
+    if cfg.Bucket.Cache.Enabled {
+        cached := repository.NewCachedRepository(store, cfg)
+        // кеш останавливается после сервера, чтобы последний сброс
+        // захватил списания запросов, завершённых при shutdown
+        cacheCtx, stopCache := context.WithCancel(context.WithoutCancel(ctx))
+        flushed := cached.Start(cacheCtx)
+        // изменения бакетов на других репликах выкидывают их из нашего кеша
+        var listened <-chan struct{}
+        if cfg.Bucket.Notify.Enabled {
+            listened = repository.NewBucketListener(dbPool, cfg, replica, cached).Start(cacheCtx)
+        }
+        repo = cached
+        cleanup = func() {
+            stopCache()
+            if listened != nil {
+                <-listened
+            }
+            <-flushed
+            dbPool.Close()
+        }
+    }
+    return repo, limiters, cleanup, nil
 }
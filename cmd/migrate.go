@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/database"
+)
+
+const migrateUsage = `usage: migrate <action> [--to N]
+
+actions:
+  up      apply pending migrations up to version N (default: all)
+  down    roll back migrations above version N (default: the last applied one)
+  redo    roll back migrations above version N and apply them again (default: the last applied one)
+  status  list known and applied migrations
+`
+
+// migrate - подкоманда migrate: схема меняется отдельным шагом деплоя,
+// без запуска сервера
+func migrate(ctx context.Context, w io.Writer, cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	action := args[0]
+	switch action {
+	case "up", "down", "redo", "status":
+	default:
+		return fmt.Errorf("unknown migrate action %q\n%s", action, migrateUsage)
+	}
+
+	flags := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), migrateUsage) }
+	to := flags.Int("to", -1, "target version")
+	if err := flags.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	m, closeDB, err := openMigrator(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	switch action {
+	case "up":
+		return m.Up(ctx, max(*to, 0))
+	case "down":
+		return m.Down(ctx, *to)
+	case "redo":
+		return m.Redo(ctx, *to)
+	}
+	return printStatus(ctx, w, m)
+}
+
+// openMigrator подключается к хранилищу из storage.driver
+func openMigrator(ctx context.Context, cfg *config.Config) (*database.Migrator, func(), error) {
+	switch cfg.Storage.DriverName() {
+	case config.StorageMemory:
+		return nil, nil, errors.New("storage driver memory has no schema to migrate")
+	case config.StorageSQLite:
+		db, err := database.ConnectSQLite(ctx, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		m, err := sqliteMigrator(cfg, db)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return m, func() { db.Close() }, nil
+	}
+
+	pool, err := database.Connect(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := postgresMigrator(cfg, pool)
+	if err != nil {
+		pool.Close()
+		return nil, nil, err
+	}
+	return m, pool.Close, nil
+}
+
+func postgresMigrator(cfg *config.Config, pool *pgxpool.Pool) (*database.Migrator, error) {
+	return database.NewPostgresMigrator(pool, cfg.DB.Schema, database.PostgresMigrations(cfg.DB.MigrationsDir))
+}
+
+func sqliteMigrator(cfg *config.Config, db *sql.DB) (*database.Migrator, error) {
+	return database.NewSQLiteMigrator(db, database.SQLiteMigrations(cfg.Storage.SQLite.MigrationsDir))
+}
+
+// migrateOnStart применяет миграции при serve. С storage.autoMigrate: false
+// схема только проверяется, и со старой схемой сервер не стартует
+func migrateOnStart(ctx context.Context, cfg *config.Config, m *database.Migrator) error {
+	if cfg.Storage.AutoMigrateEnabled() {
+		return m.Up(ctx, 0)
+	}
+	if err := m.Check(ctx); err != nil {
+		return fmt.Errorf("%w (storage.autoMigrate is off, run `migrate up` first)", err)
+	}
+	return nil
+}
+
+func printStatus(ctx context.Context, w io.Writer, m *database.Migrator) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, st := range statuses {
+		state, appliedAt := "pending", ""
+		if st.Applied {
+			state, appliedAt = "applied", st.AppliedAt.Local().Format(time.DateTime)
+		}
+		switch {
+		case st.Modified:
+			state = "modified"
+		case st.Missing:
+			state = "missing"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", st.Version, st.Name, state, appliedAt)
+	}
+	return tw.Flush()
+}
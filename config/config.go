@@ -1,8 +1,20 @@
 package config
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -24,10 +36,245 @@ type RefillConfig struct {
 }
 
 type BucketConfig struct {
+	Capacity int            `yaml:"capacity"`
+	Refill   RefillConfig   `yaml:"refill"`
+	Cache    CacheConfig    `yaml:"cache"`
+	Notify   NotifyConfig   `yaml:"notify"`
+	Cost     CostConfig     `yaml:"cost"`
+	Identity IdentityConfig `yaml:"identity"`
+	// Algorithm - алгоритм ограничения для всех клиентов, по умолчанию token_bucket
+	Algorithm string `yaml:"algorithm"`
+	// ClientAlgorithms - алгоритм для отдельных клиентов вместо Algorithm
+	ClientAlgorithms map[string]string `yaml:"clientAlgorithms"`
+	// Window - окно fixed_window, sliding_log и sliding_window: за него
+	// пропускается Capacity токенов. 0 - время пополнения пустого бакета
+	Window Duration `yaml:"window"`
+	// Policies - отдельные лимиты для групп запросов, у клиента на каждую
+	// политику свой бакет
+	Policies []PolicyConfig `yaml:"policies"`
+}
+
+// Алгоритмы ограничения частоты запросов
+const (
+	AlgorithmTokenBucket   = "token_bucket"
+	AlgorithmFixedWindow   = "fixed_window"
+	AlgorithmSlidingLog    = "sliding_log"
+	AlgorithmSlidingWindow = "sliding_window"
+	AlgorithmGCRA          = "gcra"
+	AlgorithmLeakyBucket   = "leaky_bucket"
+)
+
+// Algorithms - все известные алгоритмы
+var Algorithms = []string{
+	AlgorithmTokenBucket,
+	AlgorithmFixedWindow,
+	AlgorithmSlidingLog,
+	AlgorithmSlidingWindow,
+	AlgorithmGCRA,
+	AlgorithmLeakyBucket,
+}
+
+// AlgorithmFor - алгоритм клиента с учётом переопределений и значения по умолчанию
+func (bc BucketConfig) AlgorithmFor(clientID string) string {
+	if algorithm, ok := bc.ClientAlgorithms[clientID]; ok {
+		return algorithm
+	}
+	if bc.Algorithm == "" {
+		return AlgorithmTokenBucket
+	}
+	return bc.Algorithm
+}
+
+// WindowSize - Window с учётом значения по умолчанию: за capacity * interval / amount
+// пустой бакет наполняется, поэтому оконные алгоритмы пропускают в среднем
+// столько же, сколько token_bucket. 0 - окно не задано и пополнения нет
+func (bc BucketConfig) WindowSize() time.Duration {
+	if bc.Window > 0 {
+		return time.Duration(bc.Window)
+	}
+	if bc.Refill.Amount <= 0 || bc.Refill.Interval <= 0 {
+		return 0
+	}
+	return time.Duration(bc.Refill.Interval) * time.Duration(bc.Capacity) / time.Duration(bc.Refill.Amount)
+}
+
+func (bc BucketConfig) validate() error {
+	if bc.Window < 0 {
+		return fmt.Errorf("bucket.window must be not negative")
+	}
+	algorithms := []string{bc.AlgorithmFor("")}
+	for _, algorithm := range bc.ClientAlgorithms {
+		algorithms = append(algorithms, algorithm)
+	}
+	for _, algorithm := range algorithms {
+		switch algorithm {
+		case AlgorithmTokenBucket:
+		case AlgorithmFixedWindow, AlgorithmSlidingLog, AlgorithmSlidingWindow:
+			if bc.WindowSize() <= 0 {
+				return fmt.Errorf("%s needs bucket.window or a positive bucket.refill", algorithm)
+			}
+		case AlgorithmGCRA, AlgorithmLeakyBucket:
+			if bc.Refill.Amount <= 0 || bc.Refill.Interval <= 0 {
+				return fmt.Errorf("%s needs a positive bucket.refill", algorithm)
+			}
+		default:
+			return fmt.Errorf("unknown algorithm %q, available: %s", algorithm, strings.Join(Algorithms, ", "))
+		}
+	}
+
+	names := make(map[string]bool, len(bc.Policies))
+	for i, pc := range bc.Policies {
+		switch {
+		case !policyName.MatchString(pc.Name):
+			return fmt.Errorf("bucket.policies[%d]: name must match %s, got %q", i, policyName, pc.Name)
+		case names[pc.Name]:
+			return fmt.Errorf("bucket.policies[%d]: duplicate name %q", i, pc.Name)
+		case pc.Capacity <= 0:
+			return fmt.Errorf("policy %s: capacity must be positive", pc.Name)
+		case pc.Refill.Amount < 0 || pc.Refill.Interval < 0:
+			return fmt.Errorf("policy %s: refill must be not negative", pc.Name)
+		}
+		names[pc.Name] = true
+	}
+	return nil
+}
+
+// policyName - имя политики входит в ключ бакета, поэтому без двоеточий
+var policyName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// PolicyConfig - лимит для запросов, подходящих под Match. Бакет клиента по
+// политике - token bucket со своими Capacity и Refill, незаданные поля Refill
+// берутся из bucket.refill
+type PolicyConfig struct {
+	Name     string       `yaml:"name"`
+	Match    PolicyMatch  `yaml:"match"`
 	Capacity int          `yaml:"capacity"`
 	Refill   RefillConfig `yaml:"refill"`
 }
 
+// PolicyMatch срабатывает, когда выполнены все заданные условия, пустой
+// подходит любому запросу
+type PolicyMatch struct {
+	// Hosts - имена хоста без порта, *.example.com - любой поддомен
+	Hosts      []string `yaml:"hosts"`
+	PathPrefix string   `yaml:"pathPrefix"`
+	Methods    []string `yaml:"methods"`
+	// Headers - заголовок должен быть равен значению, пустое значение -
+	// заголовок просто есть
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Policy ищет политику по имени
+func (bc BucketConfig) Policy(name string) (PolicyConfig, bool) {
+	for _, pc := range bc.Policies {
+		if pc.Name == name {
+			return pc, true
+		}
+	}
+	return PolicyConfig{}, false
+}
+
+// PolicyRefill - пополнение бакетов политики с подстановкой bucket.refill
+func (bc BucketConfig) PolicyRefill(pc PolicyConfig) (int, time.Duration) {
+	amount, interval := pc.Refill.Amount, time.Duration(pc.Refill.Interval)
+	if amount == 0 {
+		amount = bc.Refill.Amount
+	}
+	if interval == 0 {
+		interval = time.Duration(bc.Refill.Interval)
+	}
+	return amount, interval
+}
+
+// CostConfig - сколько токенов стоит запрос. Правила проверяются по порядку,
+// срабатывает первое подошедшее, без совпадений запрос стоит Default
+type CostConfig struct {
+	Default int        `yaml:"default"` // 0 - один токен
+	Rules   []CostRule `yaml:"rules"`
+}
+
+// CostRule срабатывает, когда выполнены все заданные в нём условия
+type CostRule struct {
+	Methods    []string `yaml:"methods"`
+	PathPrefix string   `yaml:"pathPrefix"`
+	PathRegex  string   `yaml:"pathRegex"`
+	// MinBodyBytes - размер тела по Content-Length не меньше этого.
+	// Запрос без Content-Length (chunked) подходит под любой размер
+	MinBodyBytes int64 `yaml:"minBodyBytes"`
+	Cost         int   `yaml:"cost"`
+}
+
+// Источники идентичности клиента
+const (
+	IdentityHeader    = "header"    // заголовок с API-ключом
+	IdentityBearer    = "bearer"    // claim sub из Authorization: Bearer <jwt>
+	IdentityCookie    = "cookie"
+	IdentityQuery     = "query"     // параметр запроса
+	IdentityForwarded = "forwarded" // Forwarded / X-Forwarded-For от доверенных прокси
+	IdentityIP        = "ip"        // адрес соединения
+)
+
+// Что делать с запросом, у которого не нашлось идентичности
+const (
+	AnonymousShared = "shared" // общий бакет AnonymousKey
+	AnonymousReject = "reject" // 401
+)
+
+// IdentityConfig - по чему узнать клиента. Источники проверяются по порядку,
+// ключом бакета становится первый найденный. Без источников - ip
+type IdentityConfig struct {
+	Sources []IdentitySource `yaml:"sources"`
+	// TrustedProxies - CIDR или адреса прокси, которым forwarded верит
+	TrustedProxies []string `yaml:"trustedProxies"`
+	Anonymous      string   `yaml:"anonymous"`    // shared (по умолчанию), reject
+	AnonymousKey   string   `yaml:"anonymousKey"` // по умолчанию anonymous
+}
+
+type IdentitySource struct {
+	Type string `yaml:"type"`
+	// Name - имя заголовка, cookie или параметра запроса
+	Name string `yaml:"name"`
+	// Secret - ключ HS256 для проверки подписи bearer-токена. Без него
+	// токен только декодируется
+	Secret string `yaml:"secret"`
+	// IPv4Prefix и IPv6Prefix - длина префикса, до которой маскируется
+	// адрес (ip, forwarded): все адреса одной /64 - один клиент. 0 - весь адрес
+	IPv4Prefix int `yaml:"ipv4Prefix"`
+	IPv6Prefix int `yaml:"ipv6Prefix"`
+}
+
+// CacheConfig - кеш бакетов в памяти процесса. Решения о списании принимаются
+// локально, а состояние токенов пишется в бд пачками раз в FlushInterval.
+// Чем больше интервал, тем меньше нагрузка на бд и тем больше списаний
+// теряется при падении процесса. FlushInterval == 0 - запись после каждого списания
+type CacheConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	Size          int      `yaml:"size"` // сколько бакетов держать, лишние вытесняются по LRU
+	Shards        int      `yaml:"shards"`
+	FlushInterval Duration `yaml:"flushInterval"`
+	FlushBatch    int      `yaml:"flushBatch"` // бакетов в одном запросе при сбросе
+}
+
+// NotifyConfig - оповещение реплик об изменениях бакетов через LISTEN/NOTIFY
+// в Postgres. Реплика публикует изменения, сделанные через API, и выкидывает
+// из своего кеша бакеты, изменённые другими
+type NotifyConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Channel    string   `yaml:"channel"`
+	MinBackoff Duration `yaml:"minBackoff"` // пауза перед первым переподключением
+	MaxBackoff Duration `yaml:"maxBackoff"`
+}
+
+const DefaultNotifyChannel = "gopher_equalizer_buckets"
+
+// ChannelName - канал уведомлений, общий для публикации и подписки
+func (nc NotifyConfig) ChannelName() string {
+	if nc.Channel == "" {
+		return DefaultNotifyChannel
+	}
+	return nc.Channel
+}
+
 type ServerConfig struct {
 	Host string `yaml:"host"`
 	Port int    `yaml:"port"`
@@ -52,6 +299,8 @@ type DBConfig struct {
 	ConnectRetries    int        `yaml:"connectRetries"`
 	ConnectRetryDelay Duration   `yaml:"connectRetryDelay"`
 	Pool              PoolConfig `yaml:"pool"`
+	// MigrationsDir - каталог с миграциями вместо вшитых в бинарник
+	MigrationsDir string `yaml:"migrationsDir"`
 }
 
 func (db DBConfig) ConnString() string {
@@ -65,13 +314,169 @@ type APIConfig struct {
 	DefaultLimit int `yaml:"defaultLimit"`
 }
 
+// StatusRange - диапазон ожидаемых кодов ответа health-check.
+// В конфиге задаётся числом (200) или строкой-диапазоном ("200-299")
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+func (sr *StatusRange) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var code int
+	if err := unmarshal(&code); err == nil {
+		*sr = StatusRange{Min: code, Max: code}
+		return sr.validate()
+	}
+
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	return sr.parse(str)
+}
+
+// UnmarshalJSON принимает то же, что и в конфиге: 200 или "200-299"
+func (sr *StatusRange) UnmarshalJSON(data []byte) error {
+	var code int
+	if err := json.Unmarshal(data, &code); err == nil {
+		*sr = StatusRange{Min: code, Max: code}
+		return sr.validate()
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("status range must be a number or a string like \"200-299\"")
+	}
+	return sr.parse(str)
+}
+
+func (sr StatusRange) MarshalJSON() ([]byte, error) {
+	if sr.Min == sr.Max {
+		return json.Marshal(sr.Min)
+	}
+	return json.Marshal(fmt.Sprintf("%d-%d", sr.Min, sr.Max))
+}
+
+func (sr *StatusRange) parse(str string) error {
+	if _, err := fmt.Sscanf(str, "%d-%d", &sr.Min, &sr.Max); err != nil {
+		if _, err := fmt.Sscanf(str, "%d", &sr.Min); err != nil {
+			return fmt.Errorf("invalid status range %q", str)
+		}
+		sr.Max = sr.Min
+	}
+	return sr.validate()
+}
+
+func (sr StatusRange) validate() error {
+	if sr.Min < 100 || sr.Max > 599 || sr.Min > sr.Max {
+		return fmt.Errorf("invalid status range %d-%d", sr.Min, sr.Max)
+	}
+	return nil
+}
+
+func (sr StatusRange) Contains(code int) bool {
+	return code >= sr.Min && code <= sr.Max
+}
+
+// HealthCheckConfig - как проверять бэкенд. Задаётся для всего пула
+// (proxy.healthChecker) и может быть переопределён у отдельного бэкенда.
+// Если ничего не задано - GET на url бэкенда, живым считается всё, что не 5xx.
+// С path без expectedStatus живым считается только 2xx
+type HealthCheckConfig struct {
+	Path           string            `yaml:"path" json:"path,omitempty"`
+	Method         string            `yaml:"method" json:"method,omitempty"`
+	ExpectedStatus []StatusRange     `yaml:"expectedStatus" json:"expectedStatus,omitempty"`
+	BodyContains   string            `yaml:"bodyContains" json:"bodyContains,omitempty"`
+	Headers        map[string]string `yaml:"headers" json:"headers,omitempty"`
+	Timeout        Duration          `yaml:"timeout" json:"timeout,omitempty"`
+}
+
+// Merge дополняет незаданные поля значениями из def (настройки пула)
+func (hc HealthCheckConfig) Merge(def HealthCheckConfig) HealthCheckConfig {
+	if hc.Path == "" {
+		hc.Path = def.Path
+	}
+	if hc.Method == "" {
+		hc.Method = def.Method
+	}
+	if len(hc.ExpectedStatus) == 0 {
+		hc.ExpectedStatus = def.ExpectedStatus
+	}
+	if hc.BodyContains == "" {
+		hc.BodyContains = def.BodyContains
+	}
+	if len(hc.Headers) == 0 {
+		hc.Headers = def.Headers
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = def.Timeout
+	}
+	return hc
+}
+
 type HealthCheckerConfig struct {
 	Interval Duration `yaml:"interval"`
 	HealthCheckTimeout Duration `yaml:"healthCheckTimeout"`
+	// сколько успешных/неудачных проверок подряд нужно для смены состояния
+	Rise int `yaml:"rise"`
+	Fall int `yaml:"fall"`
+	// сколько бэкендов проверяется одновременно, 0 - все сразу
+	Concurrency int `yaml:"concurrency"`
+	// проверка по умолчанию для всех бэкендов
+	HealthCheckConfig `yaml:",inline"`
+}
+
+// Defaults возвращает проверку пула, timeout по умолчанию - healthCheckTimeout
+func (hc HealthCheckerConfig) Defaults() HealthCheckConfig {
+	def := hc.HealthCheckConfig
+	if def.Timeout == 0 {
+		def.Timeout = hc.HealthCheckTimeout
+	}
+	return def
+}
+
+// Check возвращает итоговые настройки проверки для бэкенда
+func (hc HealthCheckerConfig) Check(b BackendConfig) HealthCheckConfig {
+	if b.HealthCheck == nil {
+		return hc.Defaults()
+	}
+	return b.HealthCheck.Merge(hc.Defaults())
+}
+
+// OutlierDetectionConfig - пассивная проверка бэкендов по живому трафику
+type OutlierDetectionConfig struct {
+	Enabled              bool     `yaml:"enabled"`
+	ConsecutiveErrors    int      `yaml:"consecutiveErrors"`  // 5xx или ошибок соединения подряд
+	ErrorRateThreshold   int      `yaml:"errorRateThreshold"` // процент ошибок в окне
+	ErrorRateWindow      Duration `yaml:"errorRateWindow"`
+	ErrorRateMinRequests int      `yaml:"errorRateMinRequests"` // минимум запросов в окне для оценки доли
+	BaseEjectionTime     Duration `yaml:"baseEjectionTime"`
+	MaxEjectionTime      Duration `yaml:"maxEjectionTime"`
+	MaxEjectionPercent   int      `yaml:"maxEjectionPercent"` // сколько процентов пула можно выкинуть одновременно
+}
+
+// validate проверяет пороги включённой пассивной проверки: доли в процентах,
+// а доля ошибок без окна считалась бы за всё время жизни бэкенда
+func (oc OutlierDetectionConfig) validate() error {
+	if !oc.Enabled {
+		return nil
+	}
+	switch {
+	case oc.ConsecutiveErrors < 0:
+		return fmt.Errorf("consecutiveErrors must be not negative")
+	case oc.ErrorRateThreshold < 0 || oc.ErrorRateThreshold > 100:
+		return fmt.Errorf("errorRateThreshold must be in 0..100, got %d", oc.ErrorRateThreshold)
+	case oc.ErrorRateThreshold > 0 && oc.ErrorRateWindow <= 0:
+		return fmt.Errorf("errorRateWindow must be positive when errorRateThreshold is set")
+	case oc.MaxEjectionPercent < 1 || oc.MaxEjectionPercent > 100:
+		return fmt.Errorf("maxEjectionPercent must be in 1..100, got %d", oc.MaxEjectionPercent)
+	}
+	return nil
 }
 
 type ProxyConfig struct {
 	HealthChecker HealthCheckerConfig `yaml:"healthChecker"`
+	OutlierDetection OutlierDetectionConfig `yaml:"outlierDetection"`
 	Timeout Duration   `yaml:"timeout"`
   	KeepAlive Duration   `yaml:"keepAlive"`
   	IdleConnTimeout Duration   `yaml:"idleConnTimeout"`
@@ -80,9 +485,225 @@ type ProxyConfig struct {
   	TLSHandshakeTimeout Duration   `yaml:"TLSHandshakeTimeout"`
 }
 
+// TLSConfig - настройки TLS для https-бэкенда
+type TLSConfig struct {
+	CAFile             string `yaml:"caFile" json:"caFile,omitempty"` // свой CA вместо системных
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify" json:"insecureSkipVerify,omitempty"`
+	ServerName         string `yaml:"serverName" json:"serverName,omitempty"`
+}
+
+func (tc *TLSConfig) Build() (*tls.Config, error) {
+	if tc == nil {
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{
+		InsecureSkipVerify: tc.InsecureSkipVerify,
+		ServerName:         tc.ServerName,
+	}
+	if tc.CAFile != "" {
+		pem, err := os.ReadFile(tc.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", tc.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	return tlsCfg, nil
+}
+
+// BackendConfig описывает один бэкенд пула.
+// В конфиге его можно задать как строкой (url), так и объектом {url, weight}.
+// В POST /backends приходит тот же объект в JSON
+type BackendConfig struct {
+	// ID - имя бэкенда в admin API, по умолчанию host:port из url
+	ID          string             `yaml:"id" json:"id"`
+	URL         string             `yaml:"url" json:"url"`
+	Weight      int                `yaml:"weight" json:"weight"`
+	HealthCheck *HealthCheckConfig `yaml:"healthCheck" json:"healthCheck,omitempty"`
+	TLS         *TLSConfig         `yaml:"tls" json:"tls,omitempty"`
+}
+
+func (b *BackendConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err == nil {
+		*b = BackendConfig{URL: str, Weight: 1}
+		if err := b.Validate(); err != nil {
+			return err
+		}
+		b.ID = b.DefaultID()
+		return nil
+	}
+
+	// отдельный тип, чтобы не уйти в рекурсию UnmarshalYAML. Вес по
+	// умолчанию ставится до разбора: явный weight: 0 не должен превратиться в 1
+	type plain BackendConfig
+	p := plain{Weight: 1}
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*b = BackendConfig(p)
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	if b.ID == "" {
+		b.ID = b.DefaultID()
+	}
+	return nil
+}
+
+// UnmarshalJSON - то же для POST /backends: без weight вес 1, а явный 0
+// не пройдёт Validate
+func (b *BackendConfig) UnmarshalJSON(data []byte) error {
+	type plain BackendConfig
+	p := plain{Weight: 1}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*b = BackendConfig(p)
+	return nil
+}
+
+// DefaultID строит ID из url: host:port и путь, если он есть
+func (b BackendConfig) DefaultID() string {
+	u, err := url.Parse(b.URL)
+	if err != nil {
+		return b.URL
+	}
+	id := u.Host
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		id += "_" + strings.ReplaceAll(path, "/", "_")
+	}
+	return id
+}
+
+func (b BackendConfig) Validate() error {
+	if b.URL == "" {
+		return fmt.Errorf("backend url is required")
+	}
+	u, err := url.Parse(b.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("backend %q: url must be absolute http(s) url", b.URL)
+	}
+	// вес 0 не выводит бэкенд из ротации, для этого есть drain
+	if b.Weight < 1 {
+		return fmt.Errorf("backend %s: weight must be at least 1, got %d", b.URL, b.Weight)
+	}
+	if strings.Contains(b.ID, "/") {
+		return fmt.Errorf("backend %s: id must not contain '/'", b.URL)
+	}
+	// CA читается сразу, чтобы битый файл не всплыл на первом запросе
+	if _, err := b.TLS.Build(); err != nil {
+		return fmt.Errorf("backend %s: tls: %v", b.URL, err)
+	}
+	return nil
+}
+
+// HashConfig - откуда брать ключ для consistent_hash
+type HashConfig struct {
+	Key          string `yaml:"key"`  // ip, header, cookie, path
+	Name         string `yaml:"name"` // имя заголовка или cookie
+	VirtualNodes int    `yaml:"virtualNodes"`
+}
+
+// P2CConfig - параметры p2c_ewma
+type P2CConfig struct {
+	Decay   Duration `yaml:"decay"`   // постоянная времени затухания EWMA
+	Penalty Duration `yaml:"penalty"` // задержка, засчитываемая при ошибке
+}
+
 type BalancerConfig struct {
-	Backends []string `yaml:"backends"`
+	Backends []BackendConfig `yaml:"backends"`
 	Strategy string `yaml:"strategy"`
+	Hash     HashConfig `yaml:"hash"`
+	P2C      P2CConfig  `yaml:"p2c"`
+}
+
+// validate проверяет, что ID и url бэкендов не повторяются
+func (bc BalancerConfig) validate() error {
+	ids := make(map[string]bool, len(bc.Backends))
+	urls := make(map[string]bool, len(bc.Backends))
+	for _, b := range bc.Backends {
+		if ids[b.ID] {
+			return fmt.Errorf("duplicate backend id %q", b.ID)
+		}
+		if urls[b.URL] {
+			return fmt.Errorf("duplicate backend url %q", b.URL)
+		}
+		ids[b.ID], urls[b.URL] = true, true
+	}
+	return nil
+}
+
+// URLs возвращает адреса всех бэкендов в порядке из конфига
+func (bc BalancerConfig) URLs() []string {
+	urls := make([]string, 0, len(bc.Backends))
+	for _, b := range bc.Backends {
+		urls = append(urls, b.URL)
+	}
+	return urls
+}
+
+// Weights возвращает веса бэкендов по их адресу
+func (bc BalancerConfig) Weights() map[string]int {
+	weights := make(map[string]int, len(bc.Backends))
+	for _, b := range bc.Backends {
+		weights[b.URL] = b.Weight
+	}
+	return weights
+}
+
+// Хранилища бакетов
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+	StorageSQLite   = "sqlite"
+)
+
+// StorageConfig - где хранятся бакеты. memory не переживает перезапуск и не
+// делится между репликами, зато не требует бд (локальная разработка, CI).
+// sqlite - файл на диске для одного узла без сервера Postgres
+type StorageConfig struct {
+	Driver string       `yaml:"driver"` // postgres (по умолчанию), memory, sqlite
+	SQLite SQLiteConfig `yaml:"sqlite"`
+	// AutoMigrate - применять миграции при serve. Без ключа включено, чтобы
+	// старые конфиги работали как раньше
+	AutoMigrate *bool `yaml:"autoMigrate"`
+}
+
+type SQLiteConfig struct {
+	Path        string   `yaml:"path"`
+	BusyTimeout Duration `yaml:"busyTimeout"` // сколько ждать блокировку записи другим соединением
+	// MigrationsDir - каталог с миграциями вместо вшитых в бинарник
+	MigrationsDir string `yaml:"migrationsDir"`
+}
+
+// DriverName - драйвер с учётом значения по умолчанию
+func (sc StorageConfig) DriverName() string {
+	if sc.Driver == "" {
+		return StoragePostgres
+	}
+	return sc.Driver
+}
+
+// AutoMigrateEnabled - AutoMigrate с учётом значения по умолчанию
+func (sc StorageConfig) AutoMigrateEnabled() bool {
+	return sc.AutoMigrate == nil || *sc.AutoMigrate
+}
+
+func (sc StorageConfig) validate() error {
+	switch sc.DriverName() {
+	case StoragePostgres, StorageMemory:
+		return nil
+	case StorageSQLite:
+		if sc.SQLite.Path == "" {
+			return fmt.Errorf("storage.sqlite.path is required")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown storage driver %q", sc.Driver)
 }
 
 type Config struct {
@@ -93,21 +714,53 @@ type Config struct {
 	DB     DBConfig     `yaml:"db"`
 	Logger LoggerConfig `yaml:"logger"`
 	API	   APIConfig	`yaml:"api"`
+	Storage StorageConfig `yaml:"storage"`
 }
 
+// defaultConfig - config.yml на момент сборки. Им бинарник запускается,
+// если файла конфигурации нет рядом
+//
+//go:embed config.yml
+var defaultConfig []byte
+
+// LoadConfig читает конфиг из filename, а если файла нет - вшитый по умолчанию
 func LoadConfig(filename string) (*Config, error) {
 	file, err := os.Open(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Config file %s not found, using built-in defaults", filename)
+		return Default()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("could not open config file: %v", err)
 	}
 	defer file.Close()
+	return parseConfig(file)
+}
+
+// Default - вшитый в бинарник конфиг
+func Default() (*Config, error) {
+	return parseConfig(bytes.NewReader(defaultConfig))
+}
 
+func parseConfig(r io.Reader) (*Config, error) {
 	var config Config
-	decoder := yaml.NewDecoder(file)
-	err = decoder.Decode(&config)
+	decoder := yaml.NewDecoder(r)
+	err := decoder.Decode(&config)
 	if err != nil {
 		return nil, fmt.Errorf("could not decode config file: %v", err)
 	}
+	if err := config.Balancer.validate(); err != nil {
+		return nil, fmt.Errorf("invalid balancer config: %v", err)
+	}
+	if err := config.Proxy.OutlierDetection.validate(); err != nil {
+		return nil, fmt.Errorf("invalid proxy.outlierDetection config: %v", err)
+	}
+	if err := config.Storage.validate(); err != nil {
+		return nil, fmt.Errorf("invalid storage config: %v", err)
+	}
+	if err := config.Bucket.validate(); err != nil {
+		return nil, fmt.Errorf("invalid bucket config: %v", err)
+	}
 	return &config, nil
 }
 
@@ -126,3 +779,21 @@ func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*d = Duration(parsed)
 	return nil
 }
+
+// В JSON (admin API) Duration пишется так же, как в конфиге: "1m30s"
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("duration must be a string like \"1m30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestBalancerBackends(t *testing.T) {
+	t.Run("StringsAndObjects", func(t *testing.T) {
+		raw := `
+strategy: weighted_round_robin
+backends:
+  - http://localhost:8081
+  - url: http://localhost:8082
+    weight: 4
+  - url: http://localhost:8083/app/
+    id: app
+  - https://api.internal
+`
+		var bc BalancerConfig
+		require.NoError(t, yaml.Unmarshal([]byte(raw), &bc))
+
+		require.Equal(t, []BackendConfig{
+			{ID: "localhost:8081", URL: "http://localhost:8081", Weight: 1},
+			{ID: "localhost:8082", URL: "http://localhost:8082", Weight: 4},
+			{ID: "app", URL: "http://localhost:8083/app/", Weight: 1},
+			{ID: "api.internal", URL: "https://api.internal", Weight: 1},
+		}, bc.Backends)
+		require.Equal(t, []string{
+			"http://localhost:8081",
+			"http://localhost:8082",
+			"http://localhost:8083/app/",
+			"https://api.internal",
+		}, bc.URLs())
+		require.NoError(t, bc.validate())
+		require.Equal(t, "localhost:8083_app_v1", BackendConfig{URL: "http://localhost:8083/app/v1"}.DefaultID())
+	})
+
+	t.Run("InvalidBackend", func(t *testing.T) {
+		var bc BalancerConfig
+		err := yaml.Unmarshal([]byte("backends:\n  - weight: 2\n"), &bc)
+		require.Error(t, err)
+
+		err = yaml.Unmarshal([]byte("backends:\n  - url: http://a\n    weight: -1\n"), &bc)
+		require.Error(t, err)
+
+		// явный 0 - ошибка, а не вес по умолчанию
+		err = yaml.Unmarshal([]byte("backends:\n  - url: http://a\n    weight: 0\n"), &bc)
+		require.Error(t, err)
+
+		err = yaml.Unmarshal([]byte("backends:\n  - localhost:8081\n"), &bc)
+		require.Error(t, err)
+
+		err = yaml.Unmarshal([]byte("backends:\n  - http://a\n  - url: http://b\n    id: a\n"), &bc)
+		require.NoError(t, err)
+		require.Error(t, bc.validate(), "duplicate id")
+	})
+}
+
+func TestBackendConfigJSON(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "missing.pem")
+	raw := `{
+  "id": "api-3",
+  "url": "https://api.internal",
+  "weight": 2,
+  "healthCheck": {"path": "/healthz", "expectedStatus": [200, "204-299"], "timeout": "2s"},
+  "tls": {"insecureSkipVerify": true, "serverName": "api"}
+}`
+	var b BackendConfig
+	require.NoError(t, json.Unmarshal([]byte(raw), &b))
+	require.Equal(t, BackendConfig{
+		ID:     "api-3",
+		URL:    "https://api.internal",
+		Weight: 2,
+		HealthCheck: &HealthCheckConfig{
+			Path:           "/healthz",
+			ExpectedStatus: []StatusRange{{Min: 200, Max: 200}, {Min: 204, Max: 299}},
+			Timeout:        Duration(2 * time.Second),
+		},
+		TLS: &TLSConfig{InsecureSkipVerify: true, ServerName: "api"},
+	}, b)
+	require.NoError(t, b.Validate())
+
+	// обратно пишется в том же виде
+	data, err := json.Marshal(b.HealthCheck)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"path": "/healthz", "expectedStatus": [200, "204-299"], "timeout": "2s"}`, string(data))
+
+	for _, bad := range []string{
+		`{"url": "http://a", "healthCheck": {"expectedStatus": ["300-200"]}}`,
+		`{"url": "http://a", "healthCheck": {"timeout": 5}}`,
+	} {
+		require.Error(t, json.Unmarshal([]byte(bad), &BackendConfig{}), bad)
+	}
+
+	b.TLS.CAFile = caFile
+	require.Error(t, b.Validate(), "CA читается при проверке")
+
+	// без weight вес 1, явный 0 не проходит проверку
+	var def BackendConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"url": "http://a"}`), &def))
+	require.Equal(t, 1, def.Weight)
+	require.NoError(t, json.Unmarshal([]byte(`{"url": "http://a", "weight": 0}`), &def))
+	require.Error(t, def.Validate())
+}
+
+func TestHealthCheckConfig(t *testing.T) {
+	raw := `
+interval: 15s
+healthCheckTimeout: 5s
+path: /healthz
+expectedStatus: [200, "204-299"]
+headers:
+  Host: api.internal
+`
+	var hc HealthCheckerConfig
+	require.NoError(t, yaml.Unmarshal([]byte(raw), &hc))
+	require.Equal(t, "/healthz", hc.Path)
+	require.Equal(t, []StatusRange{{Min: 200, Max: 200}, {Min: 204, Max: 299}}, hc.ExpectedStatus)
+	require.Equal(t, map[string]string{"Host": "api.internal"}, hc.Headers)
+	require.Equal(t, Duration(5*time.Second), hc.Defaults().Timeout)
+
+	for _, bad := range []string{`["abc"]`, `["300-200"]`, `[42]`} {
+		err := yaml.Unmarshal([]byte("expectedStatus: "+bad), &hc)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestOutlierDetectionConfig(t *testing.T) {
+	valid := OutlierDetectionConfig{
+		Enabled:            true,
+		ConsecutiveErrors:  5,
+		ErrorRateThreshold: 50,
+		ErrorRateWindow:    Duration(30 * time.Second),
+		MaxEjectionPercent: 50,
+	}
+	require.NoError(t, valid.validate())
+	require.NoError(t, OutlierDetectionConfig{MaxEjectionPercent: 500}.validate(), "disabled")
+
+	for _, broken := range []func(*OutlierDetectionConfig){
+		func(oc *OutlierDetectionConfig) { oc.ErrorRateThreshold = 101 },
+		func(oc *OutlierDetectionConfig) { oc.ErrorRateThreshold = -1 },
+		func(oc *OutlierDetectionConfig) { oc.ErrorRateWindow = 0 },
+		func(oc *OutlierDetectionConfig) { oc.MaxEjectionPercent = 0 },
+		func(oc *OutlierDetectionConfig) { oc.MaxEjectionPercent = 101 },
+		func(oc *OutlierDetectionConfig) { oc.ConsecutiveErrors = -1 },
+	} {
+		oc := valid
+		broken(&oc)
+		require.Error(t, oc.validate(), "%+v", oc)
+	}
+
+	// без порога доли ошибок окно не нужно
+	valid.ErrorRateThreshold, valid.ErrorRateWindow = 0, 0
+	require.NoError(t, valid.validate())
+}
+
+func TestStorageConfig(t *testing.T) {
+	require.Equal(t, StoragePostgres, StorageConfig{}.DriverName())
+	require.NoError(t, StorageConfig{}.validate())
+	require.NoError(t, StorageConfig{Driver: StorageMemory}.validate())
+	require.Error(t, StorageConfig{Driver: "mysql"}.validate())
+	require.Error(t, StorageConfig{Driver: StorageSQLite}.validate(), "sqlite without path")
+	require.NoError(t, StorageConfig{Driver: StorageSQLite, SQLite: SQLiteConfig{Path: "buckets.db"}}.validate())
+
+	var sc StorageConfig
+	require.True(t, sc.AutoMigrateEnabled())
+	require.NoError(t, yaml.Unmarshal([]byte("autoMigrate: false"), &sc))
+	require.False(t, sc.AutoMigrateEnabled())
+}
+
+func TestBucketAlgorithms(t *testing.T) {
+	bc := BucketConfig{
+		Capacity:         10,
+		Refill:           RefillConfig{Amount: 2, Interval: Duration(time.Minute)},
+		ClientAlgorithms: map[string]string{"partner": AlgorithmGCRA},
+	}
+	require.Equal(t, AlgorithmTokenBucket, bc.AlgorithmFor("anyone"))
+	require.Equal(t, AlgorithmGCRA, bc.AlgorithmFor("partner"))
+	require.Equal(t, 5*time.Minute, bc.WindowSize(), "capacity refills in 5m")
+	require.NoError(t, bc.validate())
+
+	bc.Algorithm = AlgorithmSlidingLog
+	require.Equal(t, AlgorithmSlidingLog, bc.AlgorithmFor("anyone"))
+	require.NoError(t, bc.validate())
+
+	bc.ClientAlgorithms["typo"] = "sliding_logs"
+	require.ErrorContains(t, bc.validate(), "unknown algorithm")
+	delete(bc.ClientAlgorithms, "typo")
+
+	// без пополнения оконным алгоритмам нужен явный window, а gcra не работает
+	bc.Refill = RefillConfig{}
+	require.Error(t, bc.validate())
+	bc.Window = Duration(time.Hour)
+	require.ErrorContains(t, bc.validate(), AlgorithmGCRA)
+	delete(bc.ClientAlgorithms, "partner")
+	require.NoError(t, bc.validate())
+	require.Equal(t, time.Hour, bc.WindowSize())
+}
+
+func TestBucketPolicies(t *testing.T) {
+	bc := BucketConfig{
+		Capacity: 10,
+		Refill:   RefillConfig{Amount: 2, Interval: Duration(time.Minute)},
+		Policies: []PolicyConfig{
+			{Name: "search", Capacity: 5, Refill: RefillConfig{Amount: 1}},
+			{Name: "upload", Capacity: 2, Refill: RefillConfig{Amount: 1, Interval: Duration(time.Hour)}},
+		},
+	}
+	require.NoError(t, bc.validate())
+
+	search, ok := bc.Policy("search")
+	require.True(t, ok)
+	amount, interval := bc.PolicyRefill(search)
+	require.Equal(t, 1, amount)
+	require.Equal(t, time.Minute, interval, "interval comes from bucket.refill")
+	_, ok = bc.Policy("missing")
+	require.False(t, ok)
+
+	for _, broken := range []PolicyConfig{
+		{Name: "search", Capacity: 1},
+		{Name: "a:b", Capacity: 1},
+		{Name: "", Capacity: 1},
+		{Name: "zero"},
+		{Name: "negative", Capacity: 1, Refill: RefillConfig{Amount: -1}},
+	} {
+		bc := bc
+		bc.Policies = append(bc.Policies[:2:2], broken)
+		require.Error(t, bc.validate(), "%+v", broken)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	fromFile, err := LoadConfig("config.yml")
+	require.NoError(t, err)
+
+	// без файла берётся вшитый config.yml
+	fallback, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yml"))
+	require.NoError(t, err)
+	require.Equal(t, fromFile, fallback)
+
+	broken := filepath.Join(t.TempDir(), "broken.yml")
+	require.NoError(t, os.WriteFile(broken, []byte("storage: [\n"), 0o644))
+	_, err = LoadConfig(broken)
+	require.Error(t, err, "a broken file must not fall back to defaults")
+}
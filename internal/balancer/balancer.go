@@ -1,23 +1,95 @@
 package balancer
 
 import (
+    "net/http"
+    "sync"
+    "time"
+
+    "gopher-equalizer/internal/errdefs"
     "gopher-equalizer/internal/interfaces"
 )
 
 type Balancer struct {
     strat interfaces.IStrategy
+    keyFn KeyFunc
+    // незавершённые запросы по бэкендам, ведутся для любой стратегии
+    mu       sync.Mutex
+    inFlight map[string]int
 }
 
-func NewBalancer(strategy interfaces.IStrategy) *Balancer {
+// keyFn нужен только стратегиям с привязкой по ключу,
+// если он nil - ключом служит ip клиента
+func NewBalancer(strategy interfaces.IStrategy, keyFn KeyFunc) *Balancer {
+    if keyFn == nil {
+        keyFn = clientIP
+    }
     return &Balancer{
         strat: strategy,
+        keyFn: keyFn,
+        inFlight: make(map[string]int),
     }
 }
 
-func (b *Balancer) NextBackend() (string, error) {
-    return b.strat.Next()
+func (b *Balancer) NextBackend(r *http.Request) (string, error) {
+    var (
+        backend string
+        err     error
+    )
+    if ks, ok := b.strat.(interfaces.IKeyedStrategy); ok {
+        backend, err = ks.NextFor(b.keyFn(r))
+    } else {
+        backend, err = b.strat.Next()
+    }
+    if err != nil {
+        return "", errdefs.Wrap(errdefs.ErrNoBackends, err.Error())
+    }
+    return backend, nil
 }
 
 func (b *Balancer) ResetBackends(backs []string) {
     b.strat.ResetBackends(backs)
-}
\ No newline at end of file
+}
+
+// Стратегиям без обратной связи (round_robin и т.п.) события просто не передаются
+func (b *Balancer) OnRequestStart(backend string) {
+    b.mu.Lock()
+    b.inFlight[backend]++
+    b.mu.Unlock()
+
+    if fs, ok := b.strat.(interfaces.IFeedbackStrategy); ok {
+        fs.OnRequestStart(backend)
+    }
+}
+
+func (b *Balancer) OnRequestDone(backend string, err error) {
+    b.mu.Lock()
+    if b.inFlight[backend] > 1 {
+        b.inFlight[backend]--
+    } else {
+        delete(b.inFlight, backend)
+    }
+    b.mu.Unlock()
+
+    if fs, ok := b.strat.(interfaces.IFeedbackStrategy); ok {
+        fs.OnRequestDone(backend, err)
+    }
+}
+
+func (b *Balancer) ObserveLatency(backend string, latency time.Duration) {
+    if ls, ok := b.strat.(interfaces.ILatencyStrategy); ok {
+        ls.ObserveLatency(backend, latency)
+    }
+}
+
+func (b *Balancer) InFlight(backend string) int {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.inFlight[backend]
+}
+
+// SetWeight передаёт вес стратегиям с весами, остальные его не используют
+func (b *Balancer) SetWeight(backend string, weight int) {
+    if ws, ok := b.strat.(interfaces.IWeightedStrategy); ok {
+        ws.SetWeight(backend, weight)
+    }
+}
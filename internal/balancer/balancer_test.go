@@ -0,0 +1,97 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/pkg/strategies"
+)
+
+func TestKeyFunc(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/users/42", nil)
+	r.RemoteAddr = "10.0.0.7:51234"
+	r.Header.Set("X-User-ID", "user-1")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "s-1"})
+
+	cases := []struct {
+		cfg  config.HashConfig
+		want string
+	}{
+		{config.HashConfig{}, "10.0.0.7"},
+		{config.HashConfig{Key: "ip"}, "10.0.0.7"},
+		{config.HashConfig{Key: "header", Name: "X-User-ID"}, "user-1"},
+		{config.HashConfig{Key: "header", Name: "X-Missing"}, "10.0.0.7"},
+		{config.HashConfig{Key: "cookie", Name: "session"}, "s-1"},
+		{config.HashConfig{Key: "path"}, "/api/users/42"},
+	}
+	for _, c := range cases {
+		keyFn, err := NewKeyFunc(c.cfg)
+		require.NoError(t, err)
+		require.Equal(t, c.want, keyFn(r), "key %q", c.cfg.Key)
+	}
+
+	_, err := NewKeyFunc(config.HashConfig{Key: "header"})
+	require.Error(t, err)
+	_, err = NewKeyFunc(config.HashConfig{Key: "body"})
+	require.Error(t, err)
+}
+
+func TestBalancerKeyedStrategy(t *testing.T) {
+	keyFn, err := NewKeyFunc(config.HashConfig{Key: "header", Name: "X-User-ID"})
+	require.NoError(t, err)
+	bal := NewBalancer(strategies.NewConsistentHash([]string{"a", "b", "c"}, 0), keyFn)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User-ID", "user-1")
+	first, err := bal.NextBackend(r)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		got, _ := bal.NextBackend(r)
+		require.Equal(t, first, got)
+	}
+
+	bal.ResetBackends(nil)
+	_, err = bal.NextBackend(r)
+	require.ErrorIs(t, err, errdefs.ErrNoBackends)
+}
+
+func TestCreateStrategy(t *testing.T) {
+	for _, name := range StrategyNames() {
+		strat, err := CreateStrategy(config.BalancerConfig{
+			Strategy: name,
+			Backends: []config.BackendConfig{{URL: "http://a", Weight: 1}},
+		})
+		require.NoError(t, err, name)
+
+		backend, err := strat.Next()
+		require.NoError(t, err, name)
+		require.Equal(t, "http://a", backend, name)
+	}
+
+	_, err := CreateStrategy(config.BalancerConfig{Strategy: "fastest"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "fastest")
+	require.Contains(t, err.Error(), "random, round_robin, weighted_random, weighted_round_robin")
+}
+
+func TestBalancerInFlight(t *testing.T) {
+	bal := NewBalancer(strategies.NewRoundRobin([]string{"a", "b"}), nil)
+
+	bal.OnRequestStart("a")
+	bal.OnRequestStart("a")
+	bal.OnRequestStart("b")
+	require.Equal(t, 2, bal.InFlight("a"))
+	require.Equal(t, 1, bal.InFlight("b"))
+
+	bal.OnRequestDone("a", nil)
+	bal.OnRequestDone("b", nil)
+	// лишний Done не уводит счётчик в минус
+	bal.OnRequestDone("b", nil)
+	require.Equal(t, 1, bal.InFlight("a"))
+	require.Equal(t, 0, bal.InFlight("b"))
+}
@@ -0,0 +1,54 @@
+package balancer
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+
+    "gopher-equalizer/config"
+)
+
+// KeyFunc достаёт из запроса ключ, по которому стратегия
+// закрепляет клиента за бэкендом
+type KeyFunc func(r *http.Request) string
+
+func NewKeyFunc(cfg config.HashConfig) (KeyFunc, error) {
+    switch cfg.Key {
+    case "", "ip":
+        return clientIP, nil
+    case "header":
+        if cfg.Name == "" {
+            return nil, fmt.Errorf("hash key header: name is required")
+        }
+        return func(r *http.Request) string {
+            if v := r.Header.Get(cfg.Name); v != "" {
+                return v
+            }
+            return clientIP(r)
+        }, nil
+    case "cookie":
+        if cfg.Name == "" {
+            return nil, fmt.Errorf("hash key cookie: name is required")
+        }
+        return func(r *http.Request) string {
+            if c, err := r.Cookie(cfg.Name); err == nil && c.Value != "" {
+                return c.Value
+            }
+            return clientIP(r)
+        }, nil
+    case "path":
+        return func(r *http.Request) string {
+            return r.URL.Path
+        }, nil
+    default:
+        return nil, fmt.Errorf("unknown hash key: %s", cfg.Key)
+    }
+}
+
+func clientIP(r *http.Request) string {
+    ip, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return ip
+}
@@ -0,0 +1,160 @@
+package balancer
+
+import (
+    "sync"
+
+    "gopher-equalizer/config"
+    "gopher-equalizer/internal/errdefs"
+)
+
+// Registry - текущий состав пула бэкендов. При старте заполняется из конфига,
+// дальше меняется через admin API. Health-checker, прокси и балансировщик
+// берут пул отсюда, а не из cfg.Balancer.Backends.
+// Изменения живут только в памяти и после перезапуска теряются
+type Registry struct {
+    mu       sync.RWMutex
+    backends []config.BackendConfig
+    // draining - бэкенды (по url), которые не получают новых запросов
+    draining map[string]bool
+    subs     []func()
+}
+
+func NewRegistry(backends []config.BackendConfig) *Registry {
+    return &Registry{
+        backends: append([]config.BackendConfig(nil), backends...),
+        draining: make(map[string]bool),
+    }
+}
+
+// Backends возвращает копию пула в порядке добавления
+func (r *Registry) Backends() []config.BackendConfig {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    return append([]config.BackendConfig(nil), r.backends...)
+}
+
+func (r *Registry) Len() int {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    return len(r.backends)
+}
+
+func (r *Registry) Get(id string) (config.BackendConfig, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    if i := r.index(id); i >= 0 {
+        return r.backends[i], nil
+    }
+    return config.BackendConfig{}, errdefs.Wrapf(errdefs.ErrNotFound, "backend %s", id)
+}
+
+// Lookup ищет бэкенд по url, которым оперируют стратегии и прокси
+func (r *Registry) Lookup(url string) (config.BackendConfig, bool) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    for _, b := range r.backends {
+        if b.URL == url {
+            return b, true
+        }
+    }
+    return config.BackendConfig{}, false
+}
+
+// Add добавляет бэкенд в конец пула. Пустой id строится из url,
+// вес должен быть не меньше 1
+func (r *Registry) Add(b config.BackendConfig) error {
+    if b.ID == "" {
+        b.ID = b.DefaultID()
+    }
+    if err := b.Validate(); err != nil {
+        return errdefs.Wrap(errdefs.ErrInvalidInput, err.Error())
+    }
+
+    r.mu.Lock()
+    for _, existing := range r.backends {
+        if existing.ID == b.ID || existing.URL == b.URL {
+            r.mu.Unlock()
+            return errdefs.Wrapf(errdefs.ErrConflict, "backend %s (%s) already exists", b.ID, b.URL)
+        }
+    }
+    r.backends = append(r.backends, b)
+    r.mu.Unlock()
+
+    r.notify()
+    return nil
+}
+
+// Remove убирает бэкенд из пула. Запросы, уже отправленные на него, доходят до конца
+func (r *Registry) Remove(id string) (config.BackendConfig, error) {
+    r.mu.Lock()
+    i := r.index(id)
+    if i < 0 {
+        r.mu.Unlock()
+        return config.BackendConfig{}, errdefs.Wrapf(errdefs.ErrNotFound, "backend %s", id)
+    }
+    b := r.backends[i]
+    r.backends = append(r.backends[:i:i], r.backends[i+1:]...)
+    delete(r.draining, b.URL)
+    r.mu.Unlock()
+
+    r.notify()
+    return b, nil
+}
+
+// SetDraining выводит бэкенд из ротации (draining == true) или возвращает его.
+// Бэкенд остаётся в пуле и проверяется health-checker'ом,
+// но новых запросов не получает
+func (r *Registry) SetDraining(id string, draining bool) (config.BackendConfig, error) {
+    r.mu.Lock()
+    i := r.index(id)
+    if i < 0 {
+        r.mu.Unlock()
+        return config.BackendConfig{}, errdefs.Wrapf(errdefs.ErrNotFound, "backend %s", id)
+    }
+    b := r.backends[i]
+    changed := r.draining[b.URL] != draining
+    if draining {
+        r.draining[b.URL] = true
+    } else {
+        delete(r.draining, b.URL)
+    }
+    r.mu.Unlock()
+
+    if changed {
+        r.notify()
+    }
+    return b, nil
+}
+
+func (r *Registry) IsDraining(url string) bool {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    return r.draining[url]
+}
+
+// Subscribe регистрирует обработчик, который вызывается после каждого изменения пула
+func (r *Registry) Subscribe(fn func()) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.subs = append(r.subs, fn)
+}
+
+// notify зовёт подписчиков без мьютекса, чтобы они могли читать реестр
+func (r *Registry) notify() {
+    r.mu.RLock()
+    subs := append([]func(){}, r.subs...)
+    r.mu.RUnlock()
+    for _, fn := range subs {
+        fn()
+    }
+}
+
+// index ищет бэкенд по id, r.mu уже захвачен
+func (r *Registry) index(id string) int {
+    for i, b := range r.backends {
+        if b.ID == id {
+            return i
+        }
+    }
+    return -1
+}
@@ -0,0 +1,54 @@
+package balancer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/errdefs"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry([]config.BackendConfig{
+		{ID: "a", URL: "http://a", Weight: 1},
+	})
+	changes := 0
+	reg.Subscribe(func() { changes++ })
+
+	require.NoError(t, reg.Add(config.BackendConfig{URL: "http://b:8080/api", Weight: 1}))
+	b, err := reg.Get("b:8080_api")
+	require.NoError(t, err)
+	require.Equal(t, 1, b.Weight)
+	require.Equal(t, 2, reg.Len())
+	require.Equal(t, 1, changes)
+
+	// занятый id или url, кривой url, нулевой вес
+	require.ErrorIs(t, reg.Add(config.BackendConfig{ID: "a", URL: "http://c", Weight: 1}), errdefs.ErrConflict)
+	require.ErrorIs(t, reg.Add(config.BackendConfig{ID: "c", URL: "http://a", Weight: 1}), errdefs.ErrConflict)
+	require.ErrorIs(t, reg.Add(config.BackendConfig{URL: "c:8080", Weight: 1}), errdefs.ErrInvalidInput)
+	require.ErrorIs(t, reg.Add(config.BackendConfig{URL: "http://c"}), errdefs.ErrInvalidInput, "вес 0")
+	require.Equal(t, 1, changes)
+
+	_, err = reg.SetDraining("a", true)
+	require.NoError(t, err)
+	require.True(t, reg.IsDraining("http://a"))
+	// повторный drain ничего не меняет
+	_, err = reg.SetDraining("a", true)
+	require.NoError(t, err)
+	require.Equal(t, 2, changes)
+
+	removed, err := reg.Remove("a")
+	require.NoError(t, err)
+	require.Equal(t, "http://a", removed.URL)
+	require.False(t, reg.IsDraining("http://a"))
+	require.Equal(t, []config.BackendConfig{
+		{ID: "b:8080_api", URL: "http://b:8080/api", Weight: 1},
+	}, reg.Backends())
+	require.Equal(t, 3, changes)
+
+	_, err = reg.Remove("a")
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	_, err = reg.SetDraining("a", false)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
@@ -2,22 +2,58 @@ package balancer
 
 import (
     "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "gopher-equalizer/config"
     "gopher-equalizer/pkg/strategies"
     "gopher-equalizer/internal/interfaces"
 )
 
-var strategyFactories = map[string]func([]string) interfaces.IStrategy{
-    "round_robin": func(backends []string) interfaces.IStrategy {
-        return strategies.NewRoundRobin(backends)
+var strategyFactories = map[string]func(config.BalancerConfig) interfaces.IStrategy{
+    "round_robin": func(cfg config.BalancerConfig) interfaces.IStrategy {
+        return strategies.NewRoundRobin(cfg.URLs())
+    },
+    "weighted_round_robin": func(cfg config.BalancerConfig) interfaces.IStrategy {
+        return strategies.NewWeightedRoundRobin(cfg.URLs(), cfg.Weights())
+    },
+    "least_connections": func(cfg config.BalancerConfig) interfaces.IStrategy {
+        return strategies.NewLeastConnections(cfg.URLs())
+    },
+    "consistent_hash": func(cfg config.BalancerConfig) interfaces.IStrategy {
+        return strategies.NewConsistentHash(cfg.URLs(), cfg.Hash.VirtualNodes)
+    },
+    "p2c_ewma": func(cfg config.BalancerConfig) interfaces.IStrategy {
+        return strategies.NewP2CEWMA(
+            cfg.URLs(),
+            time.Duration(cfg.P2C.Decay),
+            time.Duration(cfg.P2C.Penalty),
+            nil,
+        )
+    },
+    "random": func(cfg config.BalancerConfig) interfaces.IStrategy {
+        return strategies.NewRandom(cfg.URLs(), nil)
+    },
+    "weighted_random": func(cfg config.BalancerConfig) interfaces.IStrategy {
+        return strategies.NewWeightedRandom(cfg.URLs(), cfg.Weights(), nil)
     },
-    // "random": func(backends []string) interfaces.IStrategy {
-    //     return strategies.NewRandom(backends)
-    // },
 }
 
-func CreateStrategy(name string, backends []string) (interfaces.IStrategy, error) {
-    if factory, ok := strategyFactories[name]; ok {
-        return factory(backends), nil
+func CreateStrategy(cfg config.BalancerConfig) (interfaces.IStrategy, error) {
+    if factory, ok := strategyFactories[cfg.Strategy]; ok {
+        return factory(cfg), nil
     }
-    return nil, fmt.Errorf("unknown strategy: %s", name)
-}
\ No newline at end of file
+    return nil, fmt.Errorf("unknown strategy: %q, valid strategies: %s",
+        cfg.Strategy, strings.Join(StrategyNames(), ", "))
+}
+
+// StrategyNames возвращает имена всех доступных стратегий по алфавиту
+func StrategyNames() []string {
+    names := make([]string, 0, len(strategyFactories))
+    for name := range strategyFactories {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// Миграции вшиты в бинарник, поэтому он не зависит от рабочего каталога
+//
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var embeddedMigrations embed.FS
+
+// PostgresMigrations - миграции Postgres из dir, если он задан, иначе вшитые
+func PostgresMigrations(dir string) fs.FS {
+	return migrationsFS(dir, "migrations")
+}
+
+// SQLiteMigrations - миграции SQLite из dir, если он задан, иначе вшитые
+func SQLiteMigrations(dir string) fs.FS {
+	return migrationsFS(dir, "migrations/sqlite")
+}
+
+func migrationsFS(dir, embedded string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	sub, err := fs.Sub(embeddedMigrations, embedded)
+	if err != nil {
+		// путь задан константой выше, ошибка возможна только при опечатке в нём
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	t.Run("Embedded", func(t *testing.T) {
+		pg, err := LoadMigrations(PostgresMigrations(""))
+		require.NoError(t, err)
+		require.NotEmpty(t, pg)
+		require.Equal(t, 1, pg[0].Version)
+
+		lite, err := LoadMigrations(SQLiteMigrations(""))
+		require.NoError(t, err)
+		require.NotEmpty(t, lite)
+
+		// встроенные миграции SQLite применяются и откатываются начисто
+		db := newTestSQLite(t)
+		m, err := NewSQLiteMigrator(db, SQLiteMigrations(""))
+		require.NoError(t, err)
+		require.NoError(t, m.Up(context.Background(), 0))
+		require.True(t, tableExists(t, db, "token_buckets"))
+		require.NoError(t, m.Down(context.Background(), 0))
+		require.False(t, tableExists(t, db, "token_buckets"))
+	})
+
+	t.Run("OverrideDir", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "0001_users.up.sql"), []byte("CREATE TABLE users (id INTEGER);"), 0o644))
+
+		migrations, err := LoadMigrations(SQLiteMigrations(dir))
+		require.NoError(t, err)
+		require.Len(t, migrations, 1)
+		require.Equal(t, "users", migrations[0].Name)
+	})
+}
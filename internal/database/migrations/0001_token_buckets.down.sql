@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS token_buckets;
+DROP FUNCTION IF EXISTS update_last_refill();
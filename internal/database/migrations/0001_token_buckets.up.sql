@@ -1,6 +1,4 @@
-CREATE SCHEMA IF NOT EXISTS %[1]s;
-
-CREATE TABLE IF NOT EXISTS %[1]s.token_buckets (
+CREATE TABLE IF NOT EXISTS token_buckets (
     client_id    TEXT PRIMARY KEY,
     capacity     INTEGER NOT NULL,
     tokens       INTEGER NOT NULL,
@@ -13,11 +11,12 @@ CREATE TABLE IF NOT EXISTS %[1]s.token_buckets (
 
 -- Для алгоритмов, которые удаляют старых клинетов
 CREATE INDEX IF NOT EXISTS idx_token_buckets_last_refill
-  ON %[1]s.token_buckets (last_refill);
+  ON token_buckets (last_refill);
 
--- триггер, который обновляет last_refill, если меняются токены
--- Вместо того, чтобы в каждом запросе обнволять его самостоятельно
-CREATE OR REPLACE FUNCTION %[1]s.update_last_refill()
+-- триггер, который обновляет last_refill, если токены выдали вручную
+-- (PATCH /buckets/{id}). Списание не сбрасывает прогресс пополнения,
+-- а consume_tokens сам двигает last_refill, поэтому их триггер не трогает
+CREATE OR REPLACE FUNCTION update_last_refill()
   RETURNS trigger AS
 $$
 BEGIN
@@ -27,7 +26,7 @@ END;
 $$ LANGUAGE plpgsql;
 
 CREATE OR REPLACE TRIGGER update_last_refill_trigger
-  BEFORE UPDATE ON %[1]s.token_buckets
+  BEFORE UPDATE ON token_buckets
   FOR EACH ROW
-  WHEN (OLD.tokens IS DISTINCT FROM NEW.tokens)
-  EXECUTE FUNCTION %[1]s.update_last_refill();
\ No newline at end of file
+  WHEN (NEW.tokens > OLD.tokens AND OLD.last_refill IS NOT DISTINCT FROM NEW.last_refill)
+  EXECUTE FUNCTION update_last_refill();
\ No newline at end of file
@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS consume_tokens(TEXT, INTEGER, INTEGER, INTEGER, BIGINT);
@@ -0,0 +1,68 @@
+-- возвращаем consume_tokens из 0002, она не знает о новых колонках
+CREATE OR REPLACE FUNCTION consume_tokens(
+  p_client_id   TEXT,
+  p_cost        INTEGER,
+  p_capacity    INTEGER,
+  p_amount      INTEGER,
+  p_interval_ms BIGINT
+)
+  RETURNS TABLE (
+    allowed         BOOLEAN,
+    remaining       INTEGER,
+    bucket_capacity INTEGER,
+    next_token_ms   BIGINT
+  ) AS
+$$
+DECLARE
+  v_tokens      INTEGER;
+  v_capacity    INTEGER;
+  v_last_refill TIMESTAMP WITH TIME ZONE;
+  v_now         TIMESTAMP WITH TIME ZONE := now();
+  v_steps       BIGINT := 0;
+BEGIN
+  INSERT INTO token_buckets (client_id, capacity, tokens, last_refill)
+  VALUES (p_client_id, p_capacity, p_capacity, v_now)
+  ON CONFLICT (client_id) DO NOTHING;
+
+  SELECT tb.tokens, tb.capacity, tb.last_refill
+    INTO v_tokens, v_capacity, v_last_refill
+    FROM token_buckets tb
+   WHERE tb.client_id = p_client_id
+     FOR UPDATE;
+
+  IF p_amount > 0 AND p_interval_ms > 0 THEN
+    v_steps := floor(EXTRACT(EPOCH FROM (v_now - v_last_refill)) * 1000 / p_interval_ms);
+  END IF;
+  IF v_steps > 0 THEN
+    v_tokens := LEAST(v_capacity::BIGINT, v_tokens + v_steps * p_amount);
+    v_last_refill := v_last_refill + v_steps * p_interval_ms * INTERVAL '1 millisecond';
+  END IF;
+  -- полный бакет не копит прогресс пополнения
+  IF v_tokens >= v_capacity THEN
+    v_last_refill := v_now;
+  END IF;
+
+  allowed := v_tokens >= p_cost;
+  IF allowed THEN
+    v_tokens := v_tokens - p_cost;
+  END IF;
+
+  UPDATE token_buckets tb
+     SET tokens = v_tokens,
+         last_refill = v_last_refill
+   WHERE tb.client_id = p_client_id;
+
+  remaining := v_tokens;
+  bucket_capacity := v_capacity;
+  next_token_ms := 0;
+  IF v_tokens < v_capacity AND p_amount > 0 AND p_interval_ms > 0 THEN
+    next_token_ms := GREATEST(0, ceil(EXTRACT(EPOCH FROM (v_last_refill - v_now)) * 1000 + p_interval_ms))::BIGINT;
+  END IF;
+  RETURN NEXT;
+END;
+$$ LANGUAGE plpgsql
+SET search_path FROM CURRENT;
+
+ALTER TABLE token_buckets
+  DROP COLUMN IF EXISTS refill_rate,
+  DROP COLUMN IF EXISTS refill_interval;
@@ -0,0 +1,47 @@
+-- Состояние алгоритмов кроме token_bucket, по таблице на алгоритм. Его
+-- считает Go-код из internal/limiter, общий для всех хранилищ, поэтому время
+-- хранится так же, как в SQLite: unix-время в микросекундах
+
+-- fixed_window: счётчик в текущем выровненном окне
+CREATE TABLE fixed_windows (
+    client_id     TEXT PRIMARY KEY,
+    window_start  BIGINT NOT NULL,
+    used          INTEGER NOT NULL,
+
+    CONSTRAINT ck_fixed_windows_used CHECK (used >= 0)
+);
+
+-- sliding_window: счётчики текущего и предыдущего окна
+CREATE TABLE sliding_windows (
+    client_id     TEXT PRIMARY KEY,
+    window_start  BIGINT NOT NULL,
+    used          INTEGER NOT NULL,
+    prev_used     INTEGER NOT NULL,
+
+    CONSTRAINT ck_sliding_windows_used CHECK (used >= 0 AND prev_used >= 0)
+);
+
+-- sliding_log: запись на каждый пропущенный запрос, старше окна удаляются
+-- при следующем запросе клиента
+CREATE TABLE sliding_log (
+    client_id  TEXT NOT NULL,
+    at         BIGINT NOT NULL,
+    cost       INTEGER NOT NULL,
+
+    CONSTRAINT ck_sliding_log_cost CHECK (cost > 0)
+);
+
+CREATE INDEX idx_sliding_log_client_at
+  ON sliding_log (client_id, at);
+
+-- gcra: TAT, время, к которому клиент «остынет»
+CREATE TABLE gcra_states (
+    client_id  TEXT PRIMARY KEY,
+    tat        BIGINT NOT NULL
+);
+
+-- leaky_bucket: когда опустеет очередь клиента
+CREATE TABLE leaky_buckets (
+    client_id  TEXT PRIMARY KEY,
+    drain_at   BIGINT NOT NULL
+);
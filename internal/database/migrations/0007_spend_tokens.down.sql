@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS spend_tokens(TEXT, INTEGER, INTEGER, INTEGER, BIGINT);
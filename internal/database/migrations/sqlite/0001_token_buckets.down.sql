@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS update_last_refill_trigger;
+DROP TABLE IF EXISTS token_buckets;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS token_buckets (
+    client_id    TEXT PRIMARY KEY,
+    capacity     INTEGER NOT NULL,
+    tokens       INTEGER NOT NULL,
+    last_refill  INTEGER NOT NULL, -- unix-время в миллисекундах
+
+    CONSTRAINT ck_capacity_positive     CHECK (capacity > 0),
+    CONSTRAINT ck_tokens_nonnegative    CHECK (tokens >= 0),
+    CONSTRAINT ck_tokens_le_capacity    CHECK (tokens <= capacity)
+);
+
+CREATE INDEX IF NOT EXISTS idx_token_buckets_last_refill
+  ON token_buckets (last_refill);
+
+-- то же, что триггер в Postgres: ручная выдача токенов (PATCH /buckets/{id})
+-- сбрасывает отсчёт пополнения. Списание двигает last_refill само, поэтому
+-- триггер его не трогает. BEFORE-триггер в SQLite не может менять NEW,
+-- поэтому время ставится отдельным UPDATE, который триггер не вызывает повторно
+CREATE TRIGGER IF NOT EXISTS update_last_refill_trigger
+  AFTER UPDATE OF tokens ON token_buckets
+  FOR EACH ROW
+  WHEN NEW.tokens > OLD.tokens AND NEW.last_refill IS OLD.last_refill
+BEGIN
+  UPDATE token_buckets
+  SET last_refill = CAST(unixepoch('subsec') * 1000 AS INTEGER)
+  WHERE client_id = NEW.client_id;
+END;
@@ -0,0 +1,2 @@
+ALTER TABLE token_buckets DROP COLUMN refill_interval;
+ALTER TABLE token_buckets DROP COLUMN refill_rate;
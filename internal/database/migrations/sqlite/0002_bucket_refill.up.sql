@@ -0,0 +1,5 @@
+-- Скорость пополнения отдельных клиентов. NULL - bucket.refill из конфига
+ALTER TABLE token_buckets
+  ADD COLUMN refill_rate INTEGER CONSTRAINT ck_refill_rate_positive CHECK (refill_rate > 0);
+ALTER TABLE token_buckets
+  ADD COLUMN refill_interval INTEGER CONSTRAINT ck_refill_interval_positive CHECK (refill_interval > 0); -- в миллисекундах
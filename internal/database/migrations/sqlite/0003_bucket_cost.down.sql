@@ -0,0 +1 @@
+ALTER TABLE token_buckets DROP COLUMN cost;
@@ -0,0 +1,3 @@
+-- Стоимость запроса, заданная клиенту. NULL - стоимость по правилам bucket.cost
+ALTER TABLE token_buckets
+  ADD COLUMN cost INTEGER CONSTRAINT ck_cost_positive CHECK (cost > 0);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS leaky_buckets;
+DROP TABLE IF EXISTS gcra_states;
+DROP TABLE IF EXISTS sliding_log;
+DROP TABLE IF EXISTS sliding_windows;
+DROP TABLE IF EXISTS fixed_windows;
@@ -0,0 +1,46 @@
+-- Состояние алгоритмов кроме token_bucket, по таблице на алгоритм, как в
+-- Postgres. Время - unix-время в микросекундах
+
+-- fixed_window: счётчик в текущем выровненном окне
+CREATE TABLE fixed_windows (
+    client_id     TEXT PRIMARY KEY,
+    window_start  INTEGER NOT NULL,
+    used          INTEGER NOT NULL,
+
+    CONSTRAINT ck_fixed_windows_used CHECK (used >= 0)
+);
+
+-- sliding_window: счётчики текущего и предыдущего окна
+CREATE TABLE sliding_windows (
+    client_id     TEXT PRIMARY KEY,
+    window_start  INTEGER NOT NULL,
+    used          INTEGER NOT NULL,
+    prev_used     INTEGER NOT NULL,
+
+    CONSTRAINT ck_sliding_windows_used CHECK (used >= 0 AND prev_used >= 0)
+);
+
+-- sliding_log: запись на каждый пропущенный запрос, старше окна удаляются
+-- при следующем запросе клиента
+CREATE TABLE sliding_log (
+    client_id  TEXT NOT NULL,
+    at         INTEGER NOT NULL,
+    cost       INTEGER NOT NULL,
+
+    CONSTRAINT ck_sliding_log_cost CHECK (cost > 0)
+);
+
+CREATE INDEX idx_sliding_log_client_at
+  ON sliding_log (client_id, at);
+
+-- gcra: TAT, время, к которому клиент «остынет»
+CREATE TABLE gcra_states (
+    client_id  TEXT PRIMARY KEY,
+    tat        INTEGER NOT NULL
+);
+
+-- leaky_bucket: когда опустеет очередь клиента
+CREATE TABLE leaky_buckets (
+    client_id  TEXT PRIMARY KEY,
+    drain_at   INTEGER NOT NULL
+);
@@ -0,0 +1,305 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopher-equalizer/internal/errdefs"
+)
+
+// Migration - пара файлов <версия>_<имя>.up.sql и <версия>_<имя>.down.sql.
+// Down может отсутствовать, тогда миграцию нельзя откатить
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // sha256 up-файла
+}
+
+// MigrationStatus - состояние миграции в бд
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Modified - up-файл изменён после применения
+	Modified bool
+	// Missing - миграция применена, но файла для неё нет (бд новее бинарника)
+	Missing bool
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// migrationSession - соединение с бд, на котором держится блокировка миграций
+type migrationSession interface {
+	applied(ctx context.Context) (map[int]appliedMigration, error)
+	// apply выполняет sql и записывает (up) или удаляет (down) версию в schema_migrations
+	// в одной транзакции
+	apply(ctx context.Context, m Migration, up bool) error
+	close(ctx context.Context)
+}
+
+// Migrator применяет и откатывает версионные миграции. Применённые версии и
+// контрольные суммы хранятся в schema_migrations, каждая миграция идёт в
+// своей транзакции, а одновременный запуск на нескольких репликах
+// сериализуется блокировкой
+type Migrator struct {
+	open       func(ctx context.Context) (migrationSession, error)
+	migrations []Migration
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_([A-Za-z0-9_]+)\.(up|down)\.sql$`)
+
+// LoadMigrations читает миграции из корня fsys и сортирует по версии.
+// Файлы с другими именами пропускаются
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrMigrationFailed, "could not read migrations dir: %v", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version <= 0 {
+			return nil, errdefs.Wrapf(errdefs.ErrMigrationFailed, "invalid migration version in %s", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, errdefs.Wrapf(errdefs.ErrMigrationFailed, "failed to read %s: %v", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, errdefs.Wrapf(errdefs.ErrMigrationFailed,
+				"duplicate migration version %d: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, errdefs.Wrapf(errdefs.ErrMigrationFailed, "migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrations - известные мигратору миграции по возрастанию версии
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up применяет все неприменённые миграции с версией не больше to, to <= 0 - все.
+// Если уже применённый файл изменён, ничего не применяется
+func (m *Migrator) Up(ctx context.Context, to int) error {
+	return m.run(ctx, func(s migrationSession, applied map[int]appliedMigration) error {
+		return m.up(ctx, s, applied, to)
+	})
+}
+
+// Down откатывает применённые миграции с версией больше to, начиная с последней.
+// to < 0 - только последнюю применённую
+func (m *Migrator) Down(ctx context.Context, to int) error {
+	return m.run(ctx, func(s migrationSession, applied map[int]appliedMigration) error {
+		if to < 0 {
+			to = previousVersion(applied)
+		}
+		return m.down(ctx, s, applied, to)
+	})
+}
+
+// Redo откатывает применённые миграции с версией больше to и применяет их
+// заново под той же блокировкой. to < 0 - только последнюю применённую
+func (m *Migrator) Redo(ctx context.Context, to int) error {
+	return m.run(ctx, func(s migrationSession, applied map[int]appliedMigration) error {
+		if to < 0 {
+			to = previousVersion(applied)
+		}
+		last := 0
+		for version := range applied {
+			last = max(last, version)
+		}
+		if last <= to {
+			return nil
+		}
+		if err := m.down(ctx, s, applied, to); err != nil {
+			return err
+		}
+		return m.up(ctx, s, applied, last)
+	})
+}
+
+func (m *Migrator) up(ctx context.Context, s migrationSession, applied map[int]appliedMigration, to int) error {
+	for _, mig := range m.migrations {
+		if to > 0 && mig.Version > to {
+			break
+		}
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		if err := s.apply(ctx, mig, true); err != nil {
+			return errdefs.Wrapf(errdefs.ErrMigrationFailed, "migration %d_%s: %v", mig.Version, mig.Name, err)
+		}
+		applied[mig.Version] = appliedMigration{name: mig.Name, checksum: mig.Checksum, appliedAt: time.Now()}
+		log.Printf("Successfully applied migration: %d_%s", mig.Version, mig.Name)
+	}
+	return nil
+}
+
+func (m *Migrator) down(ctx context.Context, s migrationSession, applied map[int]appliedMigration, to int) error {
+	known := make(map[int]bool, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+	}
+	for version, a := range applied {
+		if version > to && !known[version] {
+			// бд новее бинарника: откатить её без файла нечем
+			return errdefs.Wrapf(errdefs.ErrMigrationFailed,
+				"migration %d_%s is applied but its file is missing", version, a.name)
+		}
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if mig.Version <= to {
+			break
+		}
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		if mig.Down == "" {
+			return errdefs.Wrapf(errdefs.ErrMigrationFailed, "migration %d_%s has no down file", mig.Version, mig.Name)
+		}
+		if err := s.apply(ctx, mig, false); err != nil {
+			return errdefs.Wrapf(errdefs.ErrMigrationFailed, "rollback %d_%s: %v", mig.Version, mig.Name, err)
+		}
+		delete(applied, mig.Version)
+		log.Printf("Successfully rolled back migration: %d_%s", mig.Version, mig.Name)
+	}
+	return nil
+}
+
+// previousVersion - предпоследняя применённая версия, 0 - если применено меньше двух
+func previousVersion(applied map[int]appliedMigration) int {
+	last, prev := 0, 0
+	for version := range applied {
+		switch {
+		case version > last:
+			last, prev = version, last
+		case version > prev:
+			prev = version
+		}
+	}
+	return prev
+}
+
+// Status возвращает состояние всех известных и всех применённых миграций
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.session(ctx, func(s migrationSession) error {
+		applied, err := s.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			st := MigrationStatus{Version: mig.Version, Name: mig.Name}
+			if a, ok := applied[mig.Version]; ok {
+				st.Applied = true
+				st.AppliedAt = a.appliedAt
+				st.Modified = a.checksum != mig.Checksum
+				delete(applied, mig.Version)
+			}
+			statuses = append(statuses, st)
+		}
+		for version, a := range applied {
+			statuses = append(statuses, MigrationStatus{
+				Version:   version,
+				Name:      a.name,
+				Applied:   true,
+				AppliedAt: a.appliedAt,
+				Missing:   true,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Check проверяет, что все известные миграции применены и не изменены.
+// Нужен, когда схему меняют отдельным шагом, а не при старте сервера
+func (m *Migrator) Check(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, st := range statuses {
+		switch {
+		case st.Modified:
+			return errdefs.Wrapf(errdefs.ErrMigrationFailed,
+				"migration %d_%s was modified after it had been applied", st.Version, st.Name)
+		case !st.Applied:
+			return errdefs.Wrapf(errdefs.ErrMigrationFailed, "migration %d_%s is not applied", st.Version, st.Name)
+		}
+	}
+	return nil
+}
+
+// run берёт блокировку, сверяет контрольные суммы применённых миграций и выполняет fn
+func (m *Migrator) run(ctx context.Context, fn func(s migrationSession, applied map[int]appliedMigration) error) error {
+	return m.session(ctx, func(s migrationSession) error {
+		applied, err := s.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if a, ok := applied[mig.Version]; ok && a.checksum != mig.Checksum {
+				return errdefs.Wrapf(errdefs.ErrMigrationFailed,
+					"migration %d_%s was modified after it had been applied", mig.Version, mig.Name)
+			}
+		}
+		return fn(s, applied)
+	})
+}
+
+func (m *Migrator) session(ctx context.Context, fn func(s migrationSession) error) error {
+	s, err := m.open(ctx)
+	if err != nil {
+		return errdefs.Wrapf(errdefs.ErrMigrationFailed, "%v", err)
+	}
+	defer s.close(ctx)
+	return fn(s)
+}
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/errdefs"
+)
+
+func newTestSQLite(t *testing.T) *sql.DB {
+	cfg := &config.Config{}
+	cfg.Storage.SQLite.Path = filepath.Join(t.TempDir(), "test.db")
+	db, err := ConnectSQLite(context.Background(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_users.up.sql":    {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);")},
+		"0001_users.down.sql":  {Data: []byte("DROP TABLE users;")},
+		"0002_email.up.sql":    {Data: []byte("ALTER TABLE users ADD COLUMN email TEXT;")},
+		"0002_email.down.sql":  {Data: []byte("ALTER TABLE users DROP COLUMN email;")},
+		"0010_orders.up.sql":   {Data: []byte("CREATE TABLE orders (id INTEGER PRIMARY KEY);")},
+		"0010_orders.down.sql": {Data: []byte("DROP TABLE orders;")},
+		"README.md":            {Data: []byte("не миграция")},
+		"sqlite/0001_x.up.sql": {Data: []byte("вложенные каталоги не читаются")},
+	}
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	var n int
+	err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n)
+	require.NoError(t, err)
+	return n > 0
+}
+
+func versions(t *testing.T, m *Migrator) []int {
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+	var applied []int
+	for _, st := range statuses {
+		if st.Applied {
+			applied = append(applied, st.Version)
+		}
+	}
+	return applied
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(testMigrations())
+	require.NoError(t, err)
+	require.Len(t, migrations, 3)
+	require.Equal(t, []int{1, 2, 10}, []int{migrations[0].Version, migrations[1].Version, migrations[2].Version})
+	require.Equal(t, "email", migrations[1].Name)
+	require.NotEmpty(t, migrations[1].Checksum)
+
+	_, err = LoadMigrations(fstest.MapFS{"0001_a.down.sql": {Data: []byte("")}})
+	require.ErrorIs(t, err, errdefs.ErrMigrationFailed, "down without up")
+
+	_, err = LoadMigrations(fstest.MapFS{
+		"0001_a.up.sql": {Data: []byte("")},
+		"1_b.up.sql":    {Data: []byte("")},
+	})
+	require.ErrorIs(t, err, errdefs.ErrMigrationFailed, "duplicate version")
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("UpDownStatus", func(t *testing.T) {
+		db := newTestSQLite(t)
+		m, err := NewSQLiteMigrator(db, testMigrations())
+		require.NoError(t, err)
+
+		require.NoError(t, m.Up(ctx, 2))
+		require.Equal(t, []int{1, 2}, versions(t, m))
+		require.False(t, tableExists(t, db, "orders"))
+		require.ErrorIs(t, m.Check(ctx), errdefs.ErrMigrationFailed, "10 не применена")
+
+		require.NoError(t, m.Up(ctx, 0))
+		require.NoError(t, m.Check(ctx))
+		require.NoError(t, m.Up(ctx, 0), "повторный запуск ничего не делает")
+		require.Equal(t, []int{1, 2, 10}, versions(t, m))
+		require.True(t, tableExists(t, db, "orders"))
+
+		require.NoError(t, m.Down(ctx, 1))
+		require.Equal(t, []int{1}, versions(t, m))
+		require.False(t, tableExists(t, db, "orders"))
+		_, err = db.Exec("INSERT INTO users (id, email) VALUES (1, 'a@b')")
+		require.Error(t, err, "колонка email откатилась")
+
+		require.NoError(t, m.Down(ctx, 0))
+		require.Empty(t, versions(t, m))
+		require.False(t, tableExists(t, db, "users"))
+	})
+
+	t.Run("ModifiedMigration", func(t *testing.T) {
+		db := newTestSQLite(t)
+		fsys := testMigrations()
+		m, err := NewSQLiteMigrator(db, fsys)
+		require.NoError(t, err)
+		require.NoError(t, m.Up(ctx, 1))
+
+		fsys["0001_users.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE users (id TEXT PRIMARY KEY);")}
+		m, err = NewSQLiteMigrator(db, fsys)
+		require.NoError(t, err)
+
+		err = m.Up(ctx, 0)
+		require.ErrorIs(t, err, errdefs.ErrMigrationFailed)
+		require.Contains(t, err.Error(), "modified")
+		require.Equal(t, []int{1}, versions(t, m), "при изменённом файле ничего не применяется")
+
+		statuses, err := m.Status(ctx)
+		require.NoError(t, err)
+		require.True(t, statuses[0].Modified)
+		require.ErrorIs(t, m.Check(ctx), errdefs.ErrMigrationFailed)
+	})
+
+	t.Run("FailedMigrationRollsBack", func(t *testing.T) {
+		db := newTestSQLite(t)
+		fsys := testMigrations()
+		fsys["0002_email.up.sql"] = &fstest.MapFile{Data: []byte(`
+			CREATE TABLE half (id INTEGER);
+			ALTER TABLE missing ADD COLUMN email TEXT;
+		`)}
+		m, err := NewSQLiteMigrator(db, fsys)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, m.Up(ctx, 0), errdefs.ErrMigrationFailed)
+		require.Equal(t, []int{1}, versions(t, m))
+		require.False(t, tableExists(t, db, "half"), "миграция применяется целиком или никак")
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		db := newTestSQLite(t)
+		m, err := NewSQLiteMigrator(db, testMigrations())
+		require.NoError(t, err)
+		require.NoError(t, m.Up(ctx, 0))
+
+		// бинарник старее бд: о миграции 10 он не знает
+		fsys := testMigrations()
+		delete(fsys, "0010_orders.up.sql")
+		delete(fsys, "0010_orders.down.sql")
+		m, err = NewSQLiteMigrator(db, fsys)
+		require.NoError(t, err)
+
+		require.NoError(t, m.Up(ctx, 0))
+		statuses, err := m.Status(ctx)
+		require.NoError(t, err)
+		require.Len(t, statuses, 3)
+		require.True(t, statuses[2].Missing)
+		require.Equal(t, "orders", statuses[2].Name)
+
+		require.ErrorIs(t, m.Down(ctx, -1), errdefs.ErrMigrationFailed, "откатить миграцию без файла нечем")
+		require.Equal(t, []int{1, 2, 10}, versions(t, m))
+	})
+
+	t.Run("DownLastAndRedo", func(t *testing.T) {
+		db := newTestSQLite(t)
+		m, err := NewSQLiteMigrator(db, testMigrations())
+		require.NoError(t, err)
+		require.NoError(t, m.Up(ctx, 0))
+
+		require.NoError(t, m.Down(ctx, -1))
+		require.Equal(t, []int{1, 2}, versions(t, m))
+
+		_, err = db.Exec("INSERT INTO users (id, email) VALUES (1, 'a@b')")
+		require.NoError(t, err)
+		require.NoError(t, m.Redo(ctx, -1))
+		require.Equal(t, []int{1, 2}, versions(t, m))
+		var n int
+		require.NoError(t, db.QueryRow("SELECT count(*) FROM users WHERE email IS NOT NULL").Scan(&n))
+		require.Zero(t, n, "колонка email пересоздана")
+
+		require.NoError(t, m.Redo(ctx, 0))
+		require.Equal(t, []int{1, 2}, versions(t, m), "redo не применяет новых миграций")
+		require.False(t, tableExists(t, db, "orders"))
+
+		require.NoError(t, m.Down(ctx, -1))
+		require.NoError(t, m.Down(ctx, -1))
+		require.Empty(t, versions(t, m))
+		require.NoError(t, m.Down(ctx, -1), "откатывать нечего")
+		require.NoError(t, m.Redo(ctx, -1))
+		require.Empty(t, versions(t, m))
+	})
+}
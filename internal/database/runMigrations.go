@@ -3,46 +3,139 @@ package database
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"os"
-	"path/filepath"
-	"strings"
+	"hash/fnv"
+	"io/fs"
+	"regexp"
 
 	"gopher-equalizer/config"
 	"gopher-equalizer/internal/errdefs"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-var MigrationPath = "internal/database/migrations"
+// schemaName - имя схемы без кавычек. В search_path строки подключения оно
+// тоже без кавычек, поэтому регистр приводится одинаково
+var schemaName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 
+// RunMigrations применяет миграции Postgres: вшитые в бинарник или из
+// db.migrationsDir, если он задан
 func RunMigrations(ctx context.Context, cfg *config.Config, conn *pgxpool.Pool) error {
+	m, err := NewPostgresMigrator(conn, cfg.DB.Schema, PostgresMigrations(cfg.DB.MigrationsDir))
+	if err != nil {
+		return err
+	}
+	return m.Up(ctx, 0)
+}
 
-	files, err := os.ReadDir(MigrationPath)
+// NewPostgresMigrator - мигратор для схемы schema. Миграции выполняются с
+// search_path = schema, поэтому имена в них пишутся без схемы
+func NewPostgresMigrator(pool *pgxpool.Pool, schema string, fsys fs.FS) (*Migrator, error) {
+	if !schemaName.MatchString(schema) {
+		return nil, errdefs.Wrapf(errdefs.ErrMigrationFailed, "invalid schema name %q", schema)
+	}
+	migrations, err := LoadMigrations(fsys)
 	if err != nil {
-		return fmt.Errorf("%w: could not read migrations dir: %v", errdefs.ErrMigrationFailed, err)
+		return nil, err
 	}
+	return &Migrator{
+		migrations: migrations,
+		open: func(ctx context.Context) (migrationSession, error) {
+			return openPostgresSession(ctx, pool, schema)
+		},
+	}, nil
+}
 
-	for _, file := range files {
-		if file.IsDir() || !strings.HasSuffix(file.Name(), ".sql") {
-			continue
-		}
+type postgresSession struct {
+	conn    *pgxpool.Conn
+	schema  string
+	lockKey int64
+}
 
-		path := filepath.Join(MigrationPath, file.Name())
-		sqlContent, err := ioutil.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("failed to read SQL file %s: %w", path, err)
-		}
+// openPostgresSession берёт соединение и advisory lock на нём. Блокировка
+// сессионная, поэтому вся работа мигратора идёт через это соединение
+func openPostgresSession(ctx context.Context, pool *pgxpool.Pool, schema string) (migrationSession, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
 
-		sqlQuery := fmt.Sprintf(string(sqlContent), cfg.DB.Schema, cfg.DB.Schema)
+	h := fnv.New64a()
+	h.Write([]byte("gopher-equalizer:migrations:" + schema))
+	s := &postgresSession{conn: conn, schema: schema, lockKey: int64(h.Sum64())}
 
-		_, err = conn.Exec(ctx, sqlQuery)
-		if err != nil {
-			return fmt.Errorf("failed to execute migration %s: %w", path, err)
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", s.lockKey); err != nil {
+		conn.Release()
+		return nil, fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	ddl := `
+		CREATE SCHEMA IF NOT EXISTS ` + schema + `;
+		CREATE TABLE IF NOT EXISTS ` + schema + `.schema_migrations (
+		    version     BIGINT PRIMARY KEY,
+		    name        TEXT NOT NULL,
+		    checksum    TEXT NOT NULL,
+		    applied_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+		);
+	`
+	if _, err := conn.Exec(ctx, ddl); err != nil {
+		s.close(ctx)
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return s, nil
+}
+
+func (s *postgresSession) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	rows, err := s.conn.Query(ctx, "SELECT version, name, checksum, applied_at FROM "+s.schema+".schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var (
+			version int
+			a       appliedMigration
+		)
+		if err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+func (s *postgresSession) apply(ctx context.Context, m Migration, up bool) error {
+	return pgx.BeginFunc(ctx, s.conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SET LOCAL search_path TO "+s.schema); err != nil {
+			return err
+		}
+		body := m.Down
+		if up {
+			body = m.Up
 		}
+		// без аргументов pgx выполняет запрос простым протоколом,
+		// поэтому в файле может быть несколько команд
+		if _, err := tx.Exec(ctx, body); err != nil {
+			return err
+		}
+		var err error
+		if up {
+			_, err = tx.Exec(ctx,
+				"INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+				m.Version, m.Name, m.Checksum,
+			)
+		} else {
+			_, err = tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+		}
+		return err
+	})
+}
 
-		log.Printf("Successfully executed migration: %s", path)
+func (s *postgresSession) close(ctx context.Context) {
+	// блокировка снимается и при закрытии соединения, но оно вернётся в пул
+	if _, err := s.conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", s.lockKey); err != nil {
+		s.conn.Conn().Close(context.WithoutCancel(ctx))
 	}
-	return nil
+	s.conn.Release()
 }
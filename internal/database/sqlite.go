@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopher-equalizer/config"
+
+	_ "modernc.org/sqlite" // драйвер на чистом Go, собирается с CGO_ENABLED=0
+)
+
+const defaultBusyTimeout = 5 * time.Second
+
+// ConnectSQLite открывает файл бд, создавая его каталог при необходимости.
+// Транзакции берут блокировку записи сразу (BEGIN IMMEDIATE), поэтому
+// чтение и запись бакета внутри транзакции не гоняются с другими соединениями
+func ConnectSQLite(ctx context.Context, cfg *config.Config) (*sql.DB, error) {
+	sc := cfg.Storage.SQLite
+	if dir := filepath.Dir(sc.Path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create sqlite dir %s: %w", dir, err)
+		}
+	}
+
+	busy := time.Duration(sc.BusyTimeout)
+	if busy <= 0 {
+		busy = defaultBusyTimeout
+	}
+	q := url.Values{}
+	q.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busy.Milliseconds()))
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", "file:"+sc.Path+"?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open sqlite %s: %w", sc.Path, err)
+	}
+	return db, nil
+}
+
+// RunSQLiteMigrations - RunMigrations для SQLite, каталог переопределяется
+// в storage.sqlite.migrationsDir
+func RunSQLiteMigrations(ctx context.Context, cfg *config.Config, db *sql.DB) error {
+	m, err := NewSQLiteMigrator(db, SQLiteMigrations(cfg.Storage.SQLite.MigrationsDir))
+	if err != nil {
+		return err
+	}
+	return m.Up(ctx, 0)
+}
+
+// NewSQLiteMigrator - мигратор для SQLite. Advisory lock в SQLite нет: каждая
+// миграция идёт в транзакции с блокировкой записи и сама проверяет, не
+// применил ли её уже другой процесс
+func NewSQLiteMigrator(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		migrations: migrations,
+		open: func(ctx context.Context) (migrationSession, error) {
+			_, err := db.ExecContext(ctx, `
+				CREATE TABLE IF NOT EXISTS schema_migrations (
+				    version     INTEGER PRIMARY KEY,
+				    name        TEXT NOT NULL,
+				    checksum    TEXT NOT NULL,
+				    applied_at  INTEGER NOT NULL -- unix-время в миллисекундах
+				)
+			`)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+			}
+			return sqliteSession{db: db}, nil
+		},
+	}, nil
+}
+
+type sqliteSession struct {
+	db *sql.DB
+}
+
+func (s sqliteSession) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt int64
+			a         appliedMigration
+		)
+		if err := rows.Scan(&version, &a.name, &a.checksum, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		a.appliedAt = time.UnixMilli(appliedAt)
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+func (s sqliteSession) apply(ctx context.Context, m Migration, up bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM schema_migrations WHERE version = ?", m.Version).Scan(&n); err != nil {
+		return err
+	}
+	if (n > 0) == up {
+		// другой процесс успел раньше
+		return nil
+	}
+
+	body := m.Down
+	if up {
+		body = m.Up
+	}
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	if up {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+			m.Version, m.Name, m.Checksum, time.Now().UnixMilli(),
+		)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s sqliteSession) close(ctx context.Context) {}
@@ -1,6 +1,21 @@
 package interfaces
 
+import (
+	"net/http"
+	"time"
+)
+
 type IBalancer interface {
-	NextBackend() (string, error)
+	// NextBackend выбирает бэкенд для запроса,
+	// сам запрос нужен стратегиям с привязкой по ключу
+	NextBackend(r *http.Request) (string, error)
 	ResetBackends(backs []string)
-}
\ No newline at end of file
+	// Обратная связь от прокси о запросах к бэкенду
+	OnRequestStart(backend string)
+	OnRequestDone(backend string, err error)
+	ObserveLatency(backend string, latency time.Duration)
+	// InFlight - сколько запросов к бэкенду сейчас не завершено
+	InFlight(backend string) int
+	// SetWeight задаёт вес бэкенда, если стратегия их учитывает
+	SetWeight(backend string, weight int)
+}
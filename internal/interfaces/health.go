@@ -0,0 +1,21 @@
+package interfaces
+
+import (
+	"context"
+
+	"gopher-equalizer/internal/models"
+)
+
+// IHealthReporter принимает от прокси результаты запросов к бэкендам
+// для пассивной проверки здоровья
+type IHealthReporter interface {
+	// ReportResult - код ответа бэкенда или ошибка транспорта (тогда status == 0)
+	ReportResult(ctx context.Context, backend string, status int, err error)
+}
+
+// IBackendMonitor отдаёт состояние пула для admin API
+type IBackendMonitor interface {
+	ListBackends() []models.BackendStatus
+	// GetBackend возвращает errdefs.ErrNotFound, если бэкенда нет
+	GetBackend(id string) (*models.BackendStatus, error)
+}
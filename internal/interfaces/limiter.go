@@ -0,0 +1,16 @@
+package interfaces
+
+import (
+	"context"
+
+	"gopher-equalizer/internal/models"
+)
+
+// ILimiter - алгоритм ограничения частоты запросов поверх своего хранилища.
+// IBucketRepository - это token_bucket
+type ILimiter interface {
+	// TryConsume атомарно учитывает запрос стоимостью cost. Если лимит
+	// исчерпан, ничего не учитывается, а состояние возвращается вместе
+	// с errdefs.NotEnoughTokens
+	TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error)
+}
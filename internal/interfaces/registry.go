@@ -0,0 +1,21 @@
+package interfaces
+
+import "gopher-equalizer/config"
+
+// IBackendRegistry - изменяемый состав пула бэкендов
+type IBackendRegistry interface {
+	// Backends возвращает копию пула в порядке добавления
+	Backends() []config.BackendConfig
+	Len() int
+	// Get и Lookup ищут бэкенд по id и по url соответственно
+	Get(id string) (config.BackendConfig, error)
+	Lookup(url string) (config.BackendConfig, bool)
+	// Add возвращает errdefs.ErrConflict, если id или url уже заняты
+	Add(b config.BackendConfig) error
+	Remove(id string) (config.BackendConfig, error)
+	// SetDraining выводит бэкенд из ротации, не удаляя его из пула
+	SetDraining(id string, draining bool) (config.BackendConfig, error)
+	IsDraining(url string) bool
+	// Subscribe - обработчик, который вызывается после каждого изменения пула
+	Subscribe(fn func())
+}
@@ -2,6 +2,7 @@ package interfaces
 
 import (
 	"context"
+	"time"
 
 	"gopher-equalizer/internal/models"
 )
@@ -12,9 +13,45 @@ type IBucketRepository interface {
 	RemoveBucket(ctx context.Context, clientID string) error
 	UpdateCapacity(ctx context.Context, clientID string, newCapacity int) error
 	UpdateCountTokens(ctx context.Context, clientID string, newCountT int) error
+	// UpdateRefill задаёт скорость пополнения бакета, 0 - значение из конфига
+	UpdateRefill(ctx context.Context, clientID string, rate int, interval time.Duration) error
+	// UpdateCost задаёт стоимость запроса для бакета, 0 - стоимость по правилам
+	UpdateCost(ctx context.Context, clientID string, cost int) error
 	ListBuckets(ctx context.Context, limit, offset int) (*[]models.Bucket, error)
 	GetBucket(ctx context.Context, clientID string) (*models.Bucket, error)
 	// Логика
-	TryConsume(ctx context.Context, clientID string) error
-	RefillTokens(ctx context.Context, clientID string, amount int) error
-}
\ No newline at end of file
+	// TryConsume атомарно пополняет бакет по времени с last_refill и списывает
+	// cost токенов (или стоимость, заданную бакету), отсутствующий бакет
+	// создаётся полным. Пополнение идёт со скоростью бакета, а где она не
+	// задана - с bucket.refill из конфига. Если токенов не хватило, ничего
+	// не списывается, а состояние бакета возвращается вместе с errdefs.NotEnoughTokens
+	TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error)
+	// TryConsumeAll - TryConsume сразу по нескольким бакетам с разными ключами:
+	// токены списываются со всех или ни с одного. Новые бакеты создаются с
+	// ёмкостью и пополнением из запроса. Возвращается самый строгий результат:
+	// бакет, которому не хватило токенов, или бакет с наименьшим остатком
+	TryConsumeAll(ctx context.Context, reqs []models.ConsumeRequest) (*models.ConsumeResult, error)
+}
+
+// IBucketBatchWriter - пакетная запись списаний,
+// нужна кешу бакетов для отложенной записи в хранилище
+type IBucketBatchWriter interface {
+	// SpendBuckets снимает с бакетов req.Cost токенов, которые кеш уже выдал:
+	// бакет пополняется, как в TryConsume, и теряет их, но не уходит ниже
+	// нуля. Отсутствующий бакет создаётся с ёмкостью из запроса. Возвращается
+	// состояние бакетов после записи (tokens, capacity и last_refill) в порядке reqs
+	SpendBuckets(ctx context.Context, reqs []models.ConsumeRequest) ([]models.Bucket, error)
+}
+
+// IBucketInvalidator - локальная копия бакетов, которую надо сбрасывать,
+// когда бакет поменяли в обход неё (например, на другой реплике)
+type IBucketInvalidator interface {
+	// Invalidate выкидывает бакет, следующее обращение прочитает его из
+	// хранилища. Несброшенные списания бакета сохраняются
+	Invalidate(clientID string)
+	// Reset выкидывает бакет вместе с несброшенными списаниями - после
+	// записи, которая задаёт остаток заново или удаляет бакет
+	Reset(clientID string)
+	// InvalidateAll выкидывает все бакеты, когда изменения могли быть пропущены
+	InvalidateAll(ctx context.Context) error
+}
@@ -2,6 +2,7 @@ package interfaces
 
 import (
     "context"
+    "time"
 
     "gopher-equalizer/internal/models"
 )
@@ -12,8 +13,13 @@ type IBucketService interface {
     RemoveBucket(ctx context.Context, clientID string) error
     UpdateCapacity(ctx context.Context, clientID string, newCap int) error
     UpdateTokens(ctx context.Context, clientID string, newTokens int) error
+    UpdateRefill(ctx context.Context, clientID string, rate int, interval time.Duration) error
+    UpdateCost(ctx context.Context, clientID string, cost int) error
     GetBucket(ctx context.Context, clientID string) (*models.Bucket, error)
     ListBuckets(ctx context.Context, limit, offset int) (*[]models.Bucket, error)
     // Логика
-    TryConsume(ctx context.Context, clientID string) error
+    // TryConsume списывает cost токенов, у бакета может быть своя стоимость.
+    // Состояние лимита возвращается и вместе с ErrRateLimitExceeded.
+    // policies - политики bucket.policies, под которые подошёл запрос
+    TryConsume(ctx context.Context, clientID string, cost int, policies ...string) (*models.ConsumeResult, error)
 }
\ No newline at end of file
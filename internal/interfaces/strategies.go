@@ -1,7 +1,43 @@
 package interfaces
 
+import "time"
+
 type IStrategy interface {
     // Next возвращает URL следующего бэкенд-сервера
     Next() (string, error)
     ResetBackends(backs []string)
-}
\ No newline at end of file
+}
+
+// IFeedbackStrategy - необязательное расширение IStrategy для стратегий,
+// которым важно знать, сколько запросов сейчас висит на каждом бэкенде.
+// Стратегии без этих методов просто не получают обратную связь
+type IFeedbackStrategy interface {
+    IStrategy
+    // OnRequestStart вызывается, когда запрос ушёл на бэкенд
+    OnRequestStart(backend string)
+    // OnRequestDone вызывается после завершения запроса, err != nil при ошибке транспорта
+    OnRequestDone(backend string, err error)
+}
+
+// IKeyedStrategy - необязательное расширение IStrategy для стратегий,
+// выбирающих бэкенд по ключу запроса (ip клиента, заголовок, cookie, путь)
+type IKeyedStrategy interface {
+    IStrategy
+    // NextFor возвращает бэкенд, закреплённый за ключом
+    NextFor(key string) (string, error)
+}
+
+// ILatencyStrategy - необязательное расширение IStrategy для стратегий,
+// учитывающих время ответа бэкендов
+type ILatencyStrategy interface {
+    IStrategy
+    // ObserveLatency вызывается прокси, когда бэкенд вернул заголовки ответа
+    ObserveLatency(backend string, latency time.Duration)
+}
+
+// IWeightedStrategy - необязательное расширение IStrategy для стратегий с весами,
+// нужно, чтобы бэкенд, добавленный на лету, получил свой вес
+type IWeightedStrategy interface {
+    IStrategy
+    SetWeight(backend string, weight int)
+}
@@ -0,0 +1,38 @@
+package limiter
+
+import (
+	"time"
+
+	"gopher-equalizer/internal/models"
+)
+
+// FixedWindow - счётчик в окне, выровненном по времени (Start кратно Window),
+// поэтому окна у всех реплик совпадают. На стыке двух окон возможен всплеск
+// до 2 * Limit
+type FixedWindow struct {
+	Start time.Time
+	Used  int
+}
+
+func (s *FixedWindow) Consume(p Params, cost int, now time.Time) models.ConsumeResult {
+	// окно только сдвигается вперёд, даже если часы реплики отстают
+	if start := now.Truncate(p.Window); start.After(s.Start) {
+		s.Start, s.Used = start, 0
+	}
+
+	res := models.ConsumeResult{Capacity: p.Limit}
+	if s.Used+cost <= p.Limit {
+		s.Used += cost
+		res.Allowed = true
+	}
+	res.Tokens = max(p.Limit-s.Used, 0)
+	if s.Used > 0 {
+		// весь лимит возвращается разом, в конце окна
+		res.NextToken = max(s.Start.Add(p.Window).Sub(now), 0)
+		res.Reset = res.NextToken
+		if !res.Allowed {
+			res.RetryAfter = res.NextToken
+		}
+	}
+	return res
+}
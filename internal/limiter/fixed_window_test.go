@@ -0,0 +1,42 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedWindow(t *testing.T) {
+	p := Params{Limit: 3, Window: time.Minute}
+
+	t.Run("ResetsOnNextWindow", func(t *testing.T) {
+		s := &FixedWindow{}
+		res := s.Consume(p, 3, base.Add(10*time.Second))
+		require.True(t, res.Allowed)
+		require.Equal(t, 50*time.Second, res.NextToken)
+
+		res = s.Consume(p, 1, base.Add(59*time.Second))
+		require.False(t, res.Allowed)
+		require.Equal(t, time.Second, res.RetryAfter)
+		require.Equal(t, time.Second, res.Reset)
+
+		res = s.Consume(p, 1, base.Add(time.Minute))
+		require.True(t, res.Allowed)
+		require.Equal(t, 2, res.Tokens)
+		require.Equal(t, base.Add(time.Minute), s.Start)
+	})
+
+	t.Run("BurstAtBoundary", func(t *testing.T) {
+		// известный недостаток: конец одного окна и начало следующего
+		s := &FixedWindow{}
+		require.True(t, s.Consume(p, 3, base.Add(59*time.Second)).Allowed)
+		require.True(t, s.Consume(p, 3, base.Add(61*time.Second)).Allowed)
+	})
+
+	t.Run("ClockGoesBack", func(t *testing.T) {
+		s := &FixedWindow{}
+		require.True(t, s.Consume(p, 3, base.Add(time.Minute)).Allowed)
+		require.False(t, s.Consume(p, 1, base.Add(59*time.Second)).Allowed, "old window is not reopened")
+	})
+}
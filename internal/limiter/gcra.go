@@ -0,0 +1,29 @@
+package limiter
+
+import (
+	"time"
+
+	"gopher-equalizer/internal/models"
+)
+
+// GCRA - generic cell rate algorithm. Вместо счётчика хранится TAT - время,
+// к которому клиент «остынет», если запросов больше не будет. Запрос
+// пропускается сразу, если TAT вместе с его стоимостью уходит вперёд
+// не больше чем на Limit * Period. Ведёт себя как token_bucket с пополнением
+// по одному токену, но хранит одно значение и не зависит от шага пополнения
+type GCRA struct {
+	TAT time.Time
+}
+
+func (s *GCRA) Consume(p Params, cost int, now time.Time) models.ConsumeResult {
+	tat := later(s.TAT, now)
+	res := models.ConsumeResult{Capacity: p.Limit}
+	if next := tat.Add(time.Duration(cost) * p.Period); next.Sub(now) <= time.Duration(p.Limit)*p.Period {
+		tat = next
+		s.TAT = next
+		res.Allowed = true
+	}
+	res.Tokens, res.NextToken = paced(p, tat.Sub(now))
+	res.Reset, res.RetryAfter = pacedWait(p, tat.Sub(now), cost, res.Allowed)
+	return res
+}
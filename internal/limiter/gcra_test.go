@@ -0,0 +1,39 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCRA(t *testing.T) {
+	p := Params{Limit: 3, Period: 10 * time.Second}
+
+	t.Run("BurstThenRate", func(t *testing.T) {
+		s := &GCRA{}
+		res := s.Consume(p, 3, base)
+		require.True(t, res.Allowed)
+		require.Zero(t, res.Delay, "burst passes at once")
+		require.Equal(t, base.Add(30*time.Second), s.TAT)
+
+		res = s.Consume(p, 1, base.Add(4*time.Second))
+		require.False(t, res.Allowed)
+		require.Equal(t, 6*time.Second, res.NextToken)
+		require.Equal(t, 6*time.Second, res.RetryAfter)
+		require.Equal(t, 26*time.Second, res.Reset)
+
+		res = s.Consume(p, 1, base.Add(10*time.Second))
+		require.True(t, res.Allowed)
+		require.Equal(t, 0, res.Tokens)
+		require.Equal(t, base.Add(40*time.Second), s.TAT)
+	})
+
+	t.Run("IdleClientIsFull", func(t *testing.T) {
+		s := &GCRA{TAT: base}
+		res := s.Consume(p, 1, base.Add(time.Hour))
+		require.True(t, res.Allowed)
+		require.Equal(t, 2, res.Tokens)
+		require.Equal(t, 10*time.Second, res.NextToken)
+	})
+}
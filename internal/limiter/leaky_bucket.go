@@ -0,0 +1,30 @@
+package limiter
+
+import (
+	"time"
+
+	"gopher-equalizer/internal/models"
+)
+
+// LeakyBucket - leaky bucket как очередь: запросы вытекают с постоянной
+// скоростью, токен раз в Period, а в очереди помещается Limit токенов.
+// DrainAt - когда очередь опустеет. Пропущенный запрос ждёт в Delay, пока не
+// вытекут стоящие перед ним, поэтому всплеск, который GCRA пропустил бы
+// сразу, здесь растягивается, а бэкенд видит ровный поток
+type LeakyBucket struct {
+	DrainAt time.Time
+}
+
+func (s *LeakyBucket) Consume(p Params, cost int, now time.Time) models.ConsumeResult {
+	drain := later(s.DrainAt, now)
+	res := models.ConsumeResult{Capacity: p.Limit}
+	if next := drain.Add(time.Duration(cost) * p.Period); next.Sub(now) <= time.Duration(p.Limit)*p.Period {
+		res.Delay = drain.Sub(now)
+		drain = next
+		s.DrainAt = next
+		res.Allowed = true
+	}
+	res.Tokens, res.NextToken = paced(p, drain.Sub(now))
+	res.Reset, res.RetryAfter = pacedWait(p, drain.Sub(now), cost, res.Allowed)
+	return res
+}
@@ -0,0 +1,37 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeakyBucket(t *testing.T) {
+	p := Params{Limit: 3, Period: 10 * time.Second}
+
+	t.Run("QueueSpreadsBurst", func(t *testing.T) {
+		s := &LeakyBucket{}
+		for i := 0; i < 3; i++ {
+			res := s.Consume(p, 1, base)
+			require.True(t, res.Allowed)
+			require.Equal(t, time.Duration(i)*10*time.Second, res.Delay)
+		}
+		res := s.Consume(p, 1, base)
+		require.False(t, res.Allowed, "queue is full")
+		require.Zero(t, res.Delay)
+		require.Equal(t, base.Add(30*time.Second), s.DrainAt)
+
+		res = s.Consume(p, 1, base.Add(15*time.Second))
+		require.True(t, res.Allowed)
+		require.Equal(t, 15*time.Second, res.Delay)
+	})
+
+	t.Run("EmptyQueueNoDelay", func(t *testing.T) {
+		s := &LeakyBucket{DrainAt: base}
+		res := s.Consume(p, 2, base.Add(time.Minute))
+		require.True(t, res.Allowed)
+		require.Zero(t, res.Delay)
+		require.Equal(t, 1, res.Tokens)
+	})
+}
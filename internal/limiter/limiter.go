@@ -0,0 +1,69 @@
+// Package limiter - алгоритмы ограничения частоты запросов без хранилища.
+// Каждый алгоритм - состояние одного клиента с методом Consume, а где это
+// состояние лежит и как оно блокируется, решает репозиторий
+package limiter
+
+import (
+	"time"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/models"
+)
+
+// Params - лимит клиента, общий для всех алгоритмов
+type Params struct {
+	// Limit - сколько токенов пропускается за окно, а для gcra и
+	// leaky_bucket - размер всплеска (очереди)
+	Limit int
+	// Window - окно fixed_window, sliding_log и sliding_window
+	Window time.Duration
+	// Period - через сколько появляется один токен (gcra, leaky_bucket)
+	Period time.Duration
+}
+
+// NewParams собирает лимит из bucket конфига: capacity, window и refill
+func NewParams(bc config.BucketConfig) Params {
+	p := Params{Limit: bc.Capacity, Window: bc.WindowSize()}
+	if bc.Refill.Amount > 0 {
+		p.Period = time.Duration(bc.Refill.Interval) / time.Duration(bc.Refill.Amount)
+	}
+	return p
+}
+
+// State - состояние одного клиента. Consume учитывает запрос стоимостью cost,
+// если он укладывается в лимит, иначе состояние не меняется
+type State interface {
+	Consume(p Params, cost int, now time.Time) models.ConsumeResult
+}
+
+// paced - остаток и время до следующего токена у алгоритмов, где состояние -
+// время, к которому уйдёт накопленное (backlog): токен освобождается раз в Period
+func paced(p Params, backlog time.Duration) (tokens int, next time.Duration) {
+	backlog = max(backlog, 0)
+	tokens = max(int((time.Duration(p.Limit)*p.Period-backlog)/p.Period), 0)
+	if backlog > 0 {
+		next = backlog % p.Period
+		if next == 0 {
+			next = p.Period
+		}
+	}
+	return tokens, next
+}
+
+// pacedWait - Reset и RetryAfter для того же backlog: лимит полон, когда
+// backlog уйдёт целиком, а запрос cost пройдёт, когда backlog вместе с ним
+// уложится в Limit * Period
+func pacedWait(p Params, backlog time.Duration, cost int, allowed bool) (reset, retry time.Duration) {
+	reset = max(backlog, 0)
+	if !allowed {
+		retry = reset + time.Duration(min(cost, p.Limit)-p.Limit)*p.Period
+	}
+	return reset, max(retry, 0)
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,56 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/config"
+)
+
+// base - начало выровненного окна, от него удобно считать время в тестах
+var base = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestNewParams(t *testing.T) {
+	bc := config.BucketConfig{
+		Capacity: 10,
+		Refill:   config.RefillConfig{Amount: 2, Interval: config.Duration(time.Minute)},
+	}
+	require.Equal(t, Params{Limit: 10, Window: 5 * time.Minute, Period: 30 * time.Second}, NewParams(bc))
+
+	bc.Window = config.Duration(time.Minute)
+	require.Equal(t, time.Minute, NewParams(bc).Window)
+}
+
+// TestLimitNotExceeded - общее свойство всех алгоритмов: за окно (или за
+// время, пока накопленное уходит) пропускается не больше Limit токенов,
+// и отказ ничего не списывает
+func TestLimitNotExceeded(t *testing.T) {
+	p := Params{Limit: 5, Window: time.Minute, Period: 12 * time.Second}
+	states := map[string]func() State{
+		config.AlgorithmFixedWindow:   func() State { return &FixedWindow{} },
+		config.AlgorithmSlidingLog:    func() State { return &SlidingLog{} },
+		config.AlgorithmSlidingWindow: func() State { return &SlidingWindow{} },
+		config.AlgorithmGCRA:          func() State { return &GCRA{} },
+		config.AlgorithmLeakyBucket:   func() State { return &LeakyBucket{} },
+	}
+	for name, newState := range states {
+		t.Run(name, func(t *testing.T) {
+			s := newState()
+			now := base.Add(time.Second)
+			for i := 0; i < 5; i++ {
+				res := s.Consume(p, 1, now)
+				require.True(t, res.Allowed, "request %d", i)
+				require.Equal(t, 4-i, res.Tokens)
+				require.Equal(t, 5, res.Capacity)
+			}
+			res := s.Consume(p, 1, now)
+			require.False(t, res.Allowed)
+			require.Zero(t, res.Tokens)
+			require.Positive(t, res.NextToken)
+
+			require.False(t, newState().Consume(p, 6, now).Allowed, "cost above the limit")
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package limiter
+
+import (
+	"time"
+
+	"gopher-equalizer/internal/models"
+)
+
+// SlidingLog - журнал пропущенных запросов за последнее окно. Лимит точный,
+// зато хранится по записи на каждый запрос
+type SlidingLog struct {
+	Entries []LogEntry // по возрастанию At
+}
+
+type LogEntry struct {
+	At   time.Time
+	Cost int
+}
+
+// Consume выкидывает из журнала записи старше окна и дописывает запрос,
+// если он пропущен
+func (s *SlidingLog) Consume(p Params, cost int, now time.Time) models.ConsumeResult {
+	expired := now.Add(-p.Window)
+	used, keep := 0, 0
+	for i, e := range s.Entries {
+		if !e.At.After(expired) {
+			keep = i + 1
+			continue
+		}
+		used += e.Cost
+	}
+	s.Entries = s.Entries[keep:]
+
+	res := models.ConsumeResult{Capacity: p.Limit}
+	if used+cost <= p.Limit {
+		s.Entries = append(s.Entries, LogEntry{At: now, Cost: cost})
+		used += cost
+		res.Allowed = true
+	}
+	res.Tokens = max(p.Limit-used, 0)
+	if len(s.Entries) > 0 {
+		res.NextToken = max(s.Entries[0].At.Add(p.Window).Sub(now), 0)
+		res.Reset = max(s.Entries[len(s.Entries)-1].At.Add(p.Window).Sub(now), 0)
+	}
+	if !res.Allowed {
+		res.RetryAfter = s.retryAfter(p, used, cost, now)
+	}
+	return res
+}
+
+// retryAfter - когда из окна уйдёт столько записей, что cost поместится.
+// Запрос дороже Limit ждёт, пока окно не опустеет
+func (s *SlidingLog) retryAfter(p Params, used, cost int, now time.Time) time.Duration {
+	var at time.Time
+	for _, e := range s.Entries {
+		used -= e.Cost
+		at = e.At
+		if used+cost <= p.Limit {
+			break
+		}
+	}
+	if at.IsZero() {
+		return 0
+	}
+	return max(at.Add(p.Window).Sub(now), 0)
+}
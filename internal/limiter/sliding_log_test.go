@@ -0,0 +1,44 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlidingLog(t *testing.T) {
+	p := Params{Limit: 3, Window: time.Minute}
+
+	t.Run("ExactWindow", func(t *testing.T) {
+		s := &SlidingLog{}
+		require.True(t, s.Consume(p, 2, base.Add(59*time.Second)).Allowed)
+		require.True(t, s.Consume(p, 1, base.Add(61*time.Second)).Allowed)
+
+		// стыка окон нет: запрос в 59s виден до 1m59s
+		res := s.Consume(p, 1, base.Add(time.Minute+58*time.Second))
+		require.False(t, res.Allowed)
+		require.Equal(t, time.Second, res.NextToken)
+		require.Equal(t, time.Second, res.RetryAfter)
+		require.Equal(t, 3*time.Second, res.Reset)
+		require.Len(t, s.Entries, 2, "rejected request is not logged")
+
+		res = s.Consume(p, 2, base.Add(time.Minute+59*time.Second))
+		require.True(t, res.Allowed)
+		require.Zero(t, res.Tokens)
+		require.Len(t, s.Entries, 2, "expired entries are dropped")
+	})
+
+	t.Run("Weighted", func(t *testing.T) {
+		s := &SlidingLog{}
+		require.False(t, s.Consume(p, 4, base).Allowed)
+		require.Empty(t, s.Entries)
+		res := s.Consume(p, 3, base)
+		require.True(t, res.Allowed)
+		require.Equal(t, []LogEntry{{At: base, Cost: 3}}, s.Entries)
+
+		res = s.Consume(p, 4, base.Add(time.Second))
+		require.False(t, res.Allowed)
+		require.Equal(t, res.Reset, res.RetryAfter, "request over the limit waits for an empty window")
+	})
+}
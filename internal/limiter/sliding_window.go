@@ -0,0 +1,67 @@
+package limiter
+
+import (
+	"math"
+	"time"
+
+	"gopher-equalizer/internal/models"
+)
+
+// SlidingWindow - счётчик скользящего окна: выровненное окно плюс предыдущее
+// с весом той доли, что ещё попадает в [now-Window, now]. Памяти столько же,
+// сколько у FixedWindow, а всплеска на стыке окон нет. Считается, что
+// запросы предыдущего окна шли равномерно, поэтому лимит приблизительный
+type SlidingWindow struct {
+	Start    time.Time
+	Used     int
+	PrevUsed int
+}
+
+func (s *SlidingWindow) Consume(p Params, cost int, now time.Time) models.ConsumeResult {
+	if start := now.Truncate(p.Window); start.After(s.Start) {
+		s.PrevUsed = 0
+		if start.Sub(s.Start) == p.Window {
+			s.PrevUsed = s.Used
+		}
+		s.Start, s.Used = start, 0
+	}
+
+	left := min(max(s.Start.Add(p.Window).Sub(now), 0), p.Window)
+	estimate := float64(s.PrevUsed)*float64(left)/float64(p.Window) + float64(s.Used)
+
+	res := models.ConsumeResult{Capacity: p.Limit}
+	if estimate+float64(cost) <= float64(p.Limit) {
+		s.Used += cost
+		estimate += float64(cost)
+		res.Allowed = true
+	}
+	res.Tokens = max(p.Limit-int(math.Ceil(estimate)), 0)
+	if estimate > 0 {
+		res.NextToken = s.wait(p, estimate, math.Ceil(estimate)-1, left)
+		res.Reset = s.wait(p, estimate, 0, left)
+	}
+	if !res.Allowed {
+		res.RetryAfter = s.wait(p, estimate, float64(max(p.Limit-cost, 0)), left)
+	}
+	return res
+}
+
+// wait - через сколько оценка опустится до target. До конца окна она
+// падает за счёт предыдущего окна, после - за счёт текущего
+func (s *SlidingWindow) wait(p Params, estimate, target float64, left time.Duration) time.Duration {
+	if estimate <= target {
+		return 0
+	}
+	window := float64(p.Window)
+	if s.PrevUsed > 0 {
+		if wait := (estimate - target) * window / float64(s.PrevUsed); wait <= float64(left) {
+			return time.Duration(math.Ceil(wait))
+		}
+	}
+	// после смены окна оценка равна Used и падает со скоростью Used за окно
+	need := float64(s.Used) - target
+	if s.Used == 0 || need <= 0 {
+		return left
+	}
+	return left + time.Duration(math.Ceil(need*window/float64(s.Used)))
+}
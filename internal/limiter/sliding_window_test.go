@@ -0,0 +1,50 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	p := Params{Limit: 10, Window: time.Minute}
+
+	t.Run("WeightsPreviousWindow", func(t *testing.T) {
+		s := &SlidingWindow{}
+		require.True(t, s.Consume(p, 10, base.Add(30*time.Second)).Allowed)
+
+		// через 15s после смены окна 3/4 предыдущего ещё в окне: 7.5 из 10
+		now := base.Add(time.Minute + 15*time.Second)
+		res := s.Consume(p, 2, now)
+		require.True(t, res.Allowed)
+		require.Equal(t, 10, s.PrevUsed)
+		require.Equal(t, 2, s.Used)
+		require.Equal(t, 0, res.Tokens, "ceil(7.5 + 2)")
+		// до 9: (9.5 - 9) * 60s / 10
+		require.Equal(t, 3*time.Second, res.NextToken)
+
+		res = s.Consume(p, 1, now)
+		require.False(t, res.Allowed)
+		require.Equal(t, 3*time.Second, res.RetryAfter)
+		// 7.5 уходит за 45s до конца окна, 2 текущего - за следующее окно
+		require.Equal(t, 45*time.Second+time.Minute, res.Reset)
+		require.True(t, s.Consume(p, 1, now.Add(3*time.Second)).Allowed)
+	})
+
+	t.Run("NoBurstAtBoundary", func(t *testing.T) {
+		s := &SlidingWindow{}
+		require.True(t, s.Consume(p, 10, base.Add(59*time.Second)).Allowed)
+		require.False(t, s.Consume(p, 10, base.Add(61*time.Second)).Allowed)
+	})
+
+	t.Run("PreviousWindowForgotten", func(t *testing.T) {
+		s := &SlidingWindow{}
+		require.True(t, s.Consume(p, 10, base).Allowed)
+		res := s.Consume(p, 10, base.Add(2*time.Minute))
+		require.True(t, res.Allowed)
+		require.Zero(t, s.PrevUsed)
+		// предыдущего окна нет, оценка падает после смены окна: 1/10 окна
+		require.Equal(t, time.Minute+6*time.Second, res.NextToken)
+	})
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Состояния бэкенда в пуле
+const (
+	BackendUp      = "up"
+	BackendDown    = "down"    // не прошёл активную проверку
+	BackendEjected = "ejected" // выкинут пассивной проверкой
+)
+
+// BackendStatus - состояние бэкенда для admin API
+type BackendStatus struct {
+	ID                  string    `json:"id"`
+	URL                 string    `json:"url"`
+	State               string    `json:"state"`
+	StateSince          time.Time `json:"state_since"`
+	TimeInState         string    `json:"time_in_state"`
+	LastProbe           time.Time `json:"last_probe"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Weight              int       `json:"weight"`
+	InFlight            int       `json:"in_flight"`
+	// Draining - бэкенд выведен из ротации и не получает новых запросов
+	Draining bool `json:"draining"`
+}
@@ -1,10 +1,115 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 type Bucket struct {
 	ClientID   string    `json:"client_id"`
 	Capacity   int       `json:"capacity"`
 	Tokens     int       `json:"tokens"`
 	LastRefill time.Time `json:"last_refill"`
+	// RefillRate токенов добавляется раз в RefillInterval. 0 - значение
+	// из bucket.refill конфига, каждое поле подставляется отдельно
+	RefillRate     int      `json:"refill_rate,omitempty"`
+	RefillInterval Duration `json:"refill_interval,omitempty"`
+	// Cost - сколько токенов стоит любой запрос клиента,
+	// 0 - стоимость по правилам bucket.cost
+	Cost int `json:"cost,omitempty"`
+}
+
+// CostOf - стоимость запроса с учётом стоимости, заданной бакету
+func (b Bucket) CostOf(cost int) int {
+	if b.Cost > 0 {
+		return b.Cost
+	}
+	return cost
+}
+
+// Refill - скорость пополнения бакета с учётом значений по умолчанию
+func (b Bucket) Refill(defAmount int, defInterval time.Duration) (int, time.Duration) {
+	amount, interval := b.RefillRate, time.Duration(b.RefillInterval)
+	if amount == 0 {
+		amount = defAmount
+	}
+	if interval == 0 {
+		interval = defInterval
+	}
+	return amount, interval
+}
+
+// Duration - time.Duration, которая в JSON пишется строкой, как в конфиге ("1m30s")
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("duration must be a string like \"1m30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ConsumeRequest - списание с одного бакета в TryConsumeAll. Capacity -
+// ёмкость нового бакета, RefillAmount и RefillInterval - пополнение там, где
+// у бакета не задано своё
+type ConsumeRequest struct {
+	ClientID       string
+	Cost           int
+	Capacity       int
+	RefillAmount   int
+	RefillInterval time.Duration
+}
+
+// PolicyBucketID - ключ бакета клиента по политике bucket.policies. Имя
+// политики без двоеточий, а ключи клиентов не начинаются с "policy:",
+// поэтому бакеты политик не пересекаются с обычными
+func PolicyBucketID(policy, clientID string) string {
+	return "policy:" + policy + ":" + clientID
+}
+
+// ConsumeResult - состояние бакета (или другого лимита) после попытки списать токен
+type ConsumeResult struct {
+	Allowed  bool `json:"allowed"`
+	Tokens   int  `json:"tokens"` // сколько токенов осталось
+	Capacity int  `json:"capacity"`
+	// NextToken - через сколько появится следующий токен, 0 если бакет полон
+	NextToken time.Duration `json:"next_token"`
+	// Delay - сколько пропущенный запрос должен подождать своей очереди
+	// (leaky_bucket), у остальных алгоритмов 0
+	Delay time.Duration `json:"delay"`
+	// Reset - через сколько лимит восстановится полностью, 0 если он полон
+	// или не пополняется
+	Reset time.Duration `json:"reset"`
+	// RetryAfter - через сколько пройдёт отклонённый запрос той же стоимости,
+	// у пропущенного 0. Запрос дороже всего лимита ждёт Reset
+	RetryAfter time.Duration `json:"retry_after"`
+}
+
+// Операции над бакетом, о которых реплики оповещают друг друга
+const (
+	BucketCreated         = "create"
+	BucketCapacityUpdated = "update_capacity"
+	BucketTokensUpdated   = "update_tokens"
+	BucketRefillUpdated   = "update_refill"
+	BucketCostUpdated     = "update_cost"
+	BucketRemoved         = "delete"
+)
+
+// BucketEvent - изменение бакета через API, передаётся между репликами
+type BucketEvent struct {
+	Op       string `json:"op"`
+	ClientID string `json:"client_id"`
+	// Origin - реплика, сделавшая запись: своё же уведомление она пропускает
+	Origin string `json:"origin,omitempty"`
 }
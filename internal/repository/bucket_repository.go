@@ -2,6 +2,10 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
 
 	"gopher-equalizer/config"
 	"gopher-equalizer/internal/errdefs"
@@ -17,9 +21,41 @@ const (
 	chkTokensLeCap  = "ck_tokens_le_capacity"
 )
 
+// pgBucketColumns - колонки для scanPgBucket. Незаданные скорость пополнения
+// и стоимость читаются как 0, интервал - в микросекундах
+const pgBucketColumns = `client_id, capacity, tokens, last_refill,
+	COALESCE(refill_rate, 0), COALESCE((EXTRACT(EPOCH FROM refill_interval) * 1000000)::BIGINT, 0),
+	COALESCE(cost, 0)`
+
+func scanPgBucket(row interface{ Scan(...any) error }) (*models.Bucket, error) {
+	var (
+		bucket   models.Bucket
+		interval int64
+	)
+	err := row.Scan(
+		&bucket.ClientID,
+		&bucket.Capacity,
+		&bucket.Tokens,
+		&bucket.LastRefill,
+		&bucket.RefillRate,
+		&interval,
+		&bucket.Cost,
+	)
+	if err != nil {
+		return nil, err
+	}
+	bucket.RefillInterval = models.Duration(time.Duration(interval) * time.Microsecond)
+	return &bucket, nil
+}
+
 type BucketRepository struct {
 	db  *pgxpool.Pool
 	cfg *config.Config
+	// notifyChannel - канал, в который публикуются изменения через API,
+	// пустой - изменения не публикуются
+	notifyChannel string
+	// origin - идентификатор реплики в публикуемых событиях
+	origin string
 }
 
 func NewBucketRepository(db *pgxpool.Pool, cfg *config.Config) BucketRepository {
@@ -29,68 +65,183 @@ func NewBucketRepository(db *pgxpool.Pool, cfg *config.Config) BucketRepository
 	}
 }
 
-// Логика
-func (br BucketRepository) TryConsume(ctx context.Context, clientID string) error {
-	query := `
-	UPDATE token_buckets 
-		SET tokens = tokens - 1
-	WHERE client_id = $1
-	`
+// WithNotifications возвращает репозиторий, который публикует изменения
+// бакетов в channel от имени реплики origin. Уведомление уходит в той же
+// транзакции, что и запись, поэтому реплики получают его только после коммита
+func (br BucketRepository) WithNotifications(channel, origin string) BucketRepository {
+	br.notifyChannel = channel
+	br.origin = origin
+	return br
+}
 
-	tag, err := br.db.Exec(ctx, query, clientID)
+// exec выполняет запись через API и публикует event, если включены уведомления
+func (br BucketRepository) exec(ctx context.Context, event models.BucketEvent, query string, args ...any) (pgconn.CommandTag, error) {
+	if br.notifyChannel == "" {
+		return br.db.Exec(ctx, query, args...)
+	}
+	event.Origin = br.origin
+	payload, err := json.Marshal(event)
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errdefs.As(err, &pgErr) {
-			switch pgErr.ConstraintName {
-			case chkTokensNonNeg:
-				return errdefs.NotEnoughTokens
-			}
+		return pgconn.CommandTag{}, err
+	}
+
+	tx, err := br.db.Begin(ctx)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		return tag, err
+	}
+	// по несуществующему бакету оповещать нечего, вызывающий вернёт ErrNotFound
+	if tag.RowsAffected() > 0 {
+		if _, err := tx.Exec(ctx, "SELECT pg_notify($1, $2)", br.notifyChannel, string(payload)); err != nil {
+			return tag, err
 		}
-		return errdefs.Wrap(errdefs.ErrDB, err.Error())
 	}
+	return tag, tx.Commit(ctx)
+}
 
-	rowsAffected := tag.RowsAffected()
-	if rowsAffected == 0 {
-		return errdefs.ErrNotFound
+// Логика
+func (br BucketRepository) TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error) {
+	res, err := consumeTokens(ctx, br.db, defaultRequest(br.cfg, clientID, cost))
+	if err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to consume token for %s: %v", clientID, err)
 	}
+	return consumed(res)
+}
 
-	return nil
+// TryConsumeAll вызывает consume_tokens по каждому бакету в одной транзакции
+// и откатывает её, если хоть одному не хватило токенов. Бакеты блокируются
+// в порядке ключей, поэтому встречные списания не ловят взаимную блокировку
+func (br BucketRepository) TryConsumeAll(ctx context.Context, reqs []models.ConsumeRequest) (*models.ConsumeResult, error) {
+	sorted := slices.Clone(reqs)
+	slices.SortFunc(sorted, func(a, b models.ConsumeRequest) int { return strings.Compare(a.ClientID, b.ClientID) })
+
+	tx, err := br.db.Begin(ctx)
+	if err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to consume tokens: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	results := make([]models.ConsumeResult, len(sorted))
+	for i, req := range sorted {
+		if results[i], err = consumeTokens(ctx, tx, req); err != nil {
+			return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to consume token for %s: %v", req.ClientID, err)
+		}
+	}
+	res := tightest(results)
+	if !res.Allowed {
+		// откат возвращает токены бакетам, с которых уже списали
+		return consumed(res)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to consume tokens: %v", err)
+	}
+	return consumed(res)
 }
 
-func (br BucketRepository) RefillTokens(ctx context.Context, clientID string, amount int) error {
+// consumeTokens - один вызов consume_tokens, q - пул или транзакция
+func consumeTokens(ctx context.Context, q interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}, req models.ConsumeRequest) (models.ConsumeResult, error) {
 	query := `
-	UPDATE token_buckets 
-		SET tokens = LEAST(tokens + $1, capacity)
-	WHERE client_id = $2
+	SELECT allowed, remaining, bucket_capacity, next_token_ms, reset_ms, retry_after_ms
+	FROM consume_tokens($1, $2, $3, $4, $5)
 	`
 
-	tag, err := br.db.Exec(
-		ctx, query, amount, clientID)
-
+	var (
+		res                           models.ConsumeResult
+		nextTokenMs, resetMs, retryMs int64
+	)
+	err := q.QueryRow(ctx, query,
+		req.ClientID,
+		req.Cost,
+		req.Capacity,
+		req.RefillAmount,
+		req.RefillInterval.Milliseconds(),
+	).Scan(&res.Allowed, &res.Tokens, &res.Capacity, &nextTokenMs, &resetMs, &retryMs)
 	if err != nil {
-		return errdefs.Wrap(errdefs.ErrDB, err.Error())
+		return res, err
 	}
+	res.NextToken = time.Duration(nextTokenMs) * time.Millisecond
+	res.Reset = time.Duration(resetMs) * time.Millisecond
+	res.RetryAfter = time.Duration(retryMs) * time.Millisecond
+	return res, nil
+}
 
-	rowsAffected := tag.RowsAffected()
-	if rowsAffected == 0 {
-		return errdefs.ErrNotFound
+// SpendBuckets вызывает spend_tokens по всем бакетам одним запросом. Бакеты
+// блокируются в порядке ключей, как в TryConsumeAll
+func (br BucketRepository) SpendBuckets(ctx context.Context, reqs []models.ConsumeRequest) ([]models.Bucket, error) {
+	if len(reqs) == 0 {
+		return nil, nil
 	}
+	query := `
+	SELECT s.remaining, s.bucket_capacity, s.refilled_at
+	FROM unnest($1::text[], $2::int[], $3::int[], $4::int[], $5::bigint[])
+	    WITH ORDINALITY AS r(client_id, spent, capacity, amount, interval_ms, n)
+	CROSS JOIN LATERAL spend_tokens(r.client_id, r.spent, r.capacity, r.amount, r.interval_ms) s
+	ORDER BY r.n
+	`
 
-	return nil
+	order := make([]int, len(reqs))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return strings.Compare(reqs[a].ClientID, reqs[b].ClientID) })
+
+	ids := make([]string, len(reqs))
+	spent := make([]int32, len(reqs))
+	capacities := make([]int32, len(reqs))
+	amounts := make([]int32, len(reqs))
+	intervals := make([]int64, len(reqs))
+	for i, n := range order {
+		ids[i] = reqs[n].ClientID
+		spent[i] = int32(reqs[n].Cost)
+		capacities[i] = int32(reqs[n].Capacity)
+		amounts[i] = int32(reqs[n].RefillAmount)
+		intervals[i] = reqs[n].RefillInterval.Milliseconds()
+	}
+
+	rows, err := br.db.Query(ctx, query, ids, spent, capacities, amounts, intervals)
+	if err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to spend %d buckets: %v", len(reqs), err)
+	}
+	defer rows.Close()
+
+	buckets := make([]models.Bucket, len(reqs))
+	i := 0
+	for rows.Next() {
+		b := &buckets[order[i]]
+		b.ClientID = ids[i]
+		if err := rows.Scan(&b.Tokens, &b.Capacity, &b.LastRefill); err != nil {
+			return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to spend bucket %s: %v", ids[i], err)
+		}
+		i++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to spend %d buckets: %v", len(reqs), err)
+	}
+	return buckets, nil
 }
 
 // CRUD
 func (br BucketRepository) CreateBucket(ctx context.Context, bucket *models.Bucket) error {
 	query := `
  		INSERT INTO token_buckets (
- 			client_id, capacity, tokens, last_refill
- 		) VALUES ($1, $2, $3, $4)
+ 			client_id, capacity, tokens, last_refill, refill_rate, refill_interval, cost
+ 		) VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6::BIGINT, 0) * INTERVAL '1 microsecond', NULLIF($7, 0))
 	`
-	_, err := br.db.Exec(ctx, query,
+	_, err := br.exec(ctx, models.BucketEvent{Op: models.BucketCreated, ClientID: bucket.ClientID}, query,
 		bucket.ClientID,
 		bucket.Capacity,
 		bucket.Tokens,
 		bucket.LastRefill,
+		bucket.RefillRate,
+		time.Duration(bucket.RefillInterval).Microseconds(),
+		bucket.Cost,
 	)
 	if err != nil {
 		var pgErr *pgconn.PgError
@@ -112,7 +263,7 @@ func (br BucketRepository) RemoveBucket(ctx context.Context, clientID string) er
 		DELETE FROM token_buckets
 		where client_id = $1
 	`
-	tag, err := br.db.Exec(ctx, query, clientID)
+	tag, err := br.exec(ctx, models.BucketEvent{Op: models.BucketRemoved, ClientID: clientID}, query, clientID)
 
 	if err != nil {
 		return errdefs.Wrapf(errdefs.ErrDB, "failed to delete bucket %q: %v", clientID, err)
@@ -133,13 +284,15 @@ func (br BucketRepository) UpdateCapacity(ctx context.Context, clientID string,
 	    capacity = $1
 	WHERE client_id = $2
 	`
-	tag, err := br.db.Exec(ctx, query, newCapacity, clientID)
+	tag, err := br.exec(ctx, models.BucketEvent{Op: models.BucketCapacityUpdated, ClientID: clientID}, query, newCapacity, clientID)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errdefs.As(err, &pgErr) {
 			switch pgErr.ConstraintName {
 			case chkTokensLeCap:
 				return errdefs.TokensLeCap
+			case chkTokensNonNeg:
+				return errdefs.NotEnoughTokens
 			}
 		}
 		return errdefs.Wrapf(errdefs.ErrDB, "failed to update capacity buckets: %v", err)
@@ -160,13 +313,15 @@ func (br BucketRepository) UpdateCountTokens(ctx context.Context, clientID strin
 	    tokens = $1
 	WHERE client_id = $2
 	`
-	tag, err := br.db.Exec(ctx, query, newCountT, clientID)
+	tag, err := br.exec(ctx, models.BucketEvent{Op: models.BucketTokensUpdated, ClientID: clientID}, query, newCountT, clientID)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errdefs.As(err, &pgErr) {
 			switch pgErr.ConstraintName {
 			case chkTokensLeCap:
 				return errdefs.TokensLeCap
+			case chkTokensNonNeg:
+				return errdefs.NotEnoughTokens
 			}
 		}
 		return errdefs.Wrapf(errdefs.ErrDB, "failed to update tokens buckets: %v", err)
@@ -180,20 +335,61 @@ func (br BucketRepository) UpdateCountTokens(ctx context.Context, clientID strin
 	return nil
 }
 
+// UpdateRefill меняет только скорость пополнения: токены и отсчёт
+// last_refill остаются, поэтому триггер на last_refill не срабатывает
+func (br BucketRepository) UpdateRefill(ctx context.Context, clientID string, rate int, interval time.Duration) error {
+	query := `
+	UPDATE token_buckets
+	SET
+	    refill_rate = NULLIF($1, 0),
+	    refill_interval = NULLIF($2::BIGINT, 0) * INTERVAL '1 microsecond'
+	WHERE client_id = $3
+	`
+	tag, err := br.exec(ctx, models.BucketEvent{Op: models.BucketRefillUpdated, ClientID: clientID}, query,
+		rate, interval.Microseconds(), clientID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errdefs.As(err, &pgErr) && pgErr.Code == "23514" { // check_violation
+			return errdefs.Wrapf(errdefs.ErrInvalidInput, "validation failed: %v", pgErr.Message)
+		}
+		return errdefs.Wrapf(errdefs.ErrDB, "failed to update refill buckets: %v", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return errdefs.ErrNotFound
+	}
+	return nil
+}
+
+func (br BucketRepository) UpdateCost(ctx context.Context, clientID string, cost int) error {
+	query := `
+	UPDATE token_buckets
+	SET
+	    cost = NULLIF($1, 0)
+	WHERE client_id = $2
+	`
+	tag, err := br.exec(ctx, models.BucketEvent{Op: models.BucketCostUpdated, ClientID: clientID}, query, cost, clientID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errdefs.As(err, &pgErr) && pgErr.Code == "23514" { // check_violation
+			return errdefs.Wrapf(errdefs.ErrInvalidInput, "validation failed: %v", pgErr.Message)
+		}
+		return errdefs.Wrapf(errdefs.ErrDB, "failed to update cost buckets: %v", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return errdefs.ErrNotFound
+	}
+	return nil
+}
+
 func (br BucketRepository) GetBucket(ctx context.Context, clientID string) (*models.Bucket, error) {
 	query := `
-		SELECT client_id, capacity, tokens, last_refill
+		SELECT ` + pgBucketColumns + `
 		FROM token_buckets
 		where client_id = $1
 	`
-	var bucket models.Bucket
-
-	err := br.db.QueryRow(ctx, query, clientID).Scan(
-		&bucket.ClientID,
-		&bucket.Capacity,
-		&bucket.Tokens,
-		&bucket.LastRefill,
-	)
+	bucket, err := scanPgBucket(br.db.QueryRow(ctx, query, clientID))
 	if err != nil {
 		if errdefs.Is(err, pgx.ErrNoRows) {
 			return nil, errdefs.ErrNotFound
@@ -201,13 +397,13 @@ func (br BucketRepository) GetBucket(ctx context.Context, clientID string) (*mod
 		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to get bucket %s: %v", clientID, err)
 	}
 
-	return &bucket, nil
+	return bucket, nil
 }
 
 // Были идеи о keyset-плагинации, но я ни разу её не реализовывал, а времени мало...
 func (br BucketRepository) ListBuckets(ctx context.Context, limit, offset int) (*[]models.Bucket, error) {
 	query := `
-		SELECT client_id, capacity, tokens, last_refill
+		SELECT ` + pgBucketColumns + `
 		FROM token_buckets
 		ORDER BY last_refill DESC
 		LIMIT $1 OFFSET $2
@@ -220,11 +416,11 @@ func (br BucketRepository) ListBuckets(ctx context.Context, limit, offset int) (
 
 	var buckets []models.Bucket
 	for rows.Next() {
-		var bucket models.Bucket
-		if err := rows.Scan(&bucket.ClientID, &bucket.Capacity, &bucket.Tokens, &bucket.LastRefill); err != nil {
+		bucket, err := scanPgBucket(rows)
+		if err != nil {
 			return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to scan bucket: %v", err)
 		}
-		buckets = append(buckets, bucket)
+		buckets = append(buckets, *bucket)
 	}
 
 	if rows.Err() != nil {
@@ -2,10 +2,15 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/require"
@@ -13,6 +18,7 @@ import (
 	"gopher-equalizer/config"
 	"gopher-equalizer/internal/database"
 	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/interfaces"
 	"gopher-equalizer/internal/models"
 )
 
@@ -28,29 +34,81 @@ func TestMain(m *testing.M) {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	// Подключаемся к бд
+	// Подключаемся к бд. Без Postgres тесты пропускаются, а не падают
 	db, err = database.Connect(context.Background(), cfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
+	pingCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	err = db.Ping(pingCtx)
+	cancel()
+	if err != nil {
+		log.Printf("Postgres is unavailable, repository tests are skipped: %v", err)
+		db.Close()
+		db = nil
+	} else {
+		// consume_tokens создаётся миграцией
+		if err := database.RunMigrations(context.Background(), cfg, db); err != nil {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
+	}
 
 	code := m.Run()
 	os.Exit(code)
 }
 
 func clearTable(t *testing.T) {
+	if db == nil {
+		t.Skip("Postgres is unavailable")
+	}
 	ctx := context.Background()
 	_, err := db.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s.token_buckets", cfg.DB.Schema))
 
 	require.NoError(t, err, "Не удалось очистить таблицу buckets")
 }
 
+// repositoryDrivers - реализации IBucketRepository, на которых гоняются
+// общие тесты. new возвращает пустое хранилище
+var repositoryDrivers = []struct {
+	name string
+	new  func(t *testing.T) interfaces.IBucketRepository
+}{
+	{config.StoragePostgres, func(t *testing.T) interfaces.IBucketRepository {
+		clearTable(t)
+		return NewBucketRepository(db, cfg)
+	}},
+	{config.StorageMemory, func(t *testing.T) interfaces.IBucketRepository {
+		return NewMemoryRepository(cfg)
+	}},
+	{config.StorageSQLite, func(t *testing.T) interfaces.IBucketRepository {
+		return NewSQLiteRepository(newSQLite(t), cfg)
+	}},
+}
+
+// newSQLite создаёт пустую бд SQLite во временном каталоге
+func newSQLite(t *testing.T) *sql.DB {
+	scfg := *cfg
+	scfg.Storage.SQLite = config.SQLiteConfig{Path: filepath.Join(t.TempDir(), "buckets.db")}
+	sqliteDB, err := database.ConnectSQLite(context.Background(), &scfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { sqliteDB.Close() })
+	require.NoError(t, database.RunSQLiteMigrations(context.Background(), &scfg, sqliteDB))
+	return sqliteDB
+}
+
 func TestBucketRepository(t *testing.T) {
+	for _, driver := range repositoryDrivers {
+		t.Run(driver.name, func(t *testing.T) {
+			testBucketRepository(t, driver.new)
+		})
+	}
+}
+
+func testBucketRepository(t *testing.T, newRepo func(t *testing.T) interfaces.IBucketRepository) {
 	ctx := context.Background()
-	repo := NewBucketRepository(db, cfg)
 
 	t.Run("CreateGetRemove", func(t *testing.T) {
-		clearTable(t)
+		repo := newRepo(t)
 
 		bucket := &models.Bucket{
 			ClientID: "test-client-1",
@@ -74,7 +132,7 @@ func TestBucketRepository(t *testing.T) {
 	})
 
 	t.Run("UniqueClientIDError", func(t *testing.T) {
-		clearTable(t)
+		repo := newRepo(t)
 
 		bucket := &models.Bucket{
 			ClientID: "duplicate-client",
@@ -94,7 +152,7 @@ func TestBucketRepository(t *testing.T) {
 	})
 
 	t.Run("TokensGreaterThanCapacity", func(t *testing.T) {
-		clearTable(t)
+		repo := newRepo(t)
 
 		bucket := &models.Bucket{
 			ClientID: "overflow-client",
@@ -110,13 +168,14 @@ func TestBucketRepository(t *testing.T) {
 	})
 
 	t.Run("TryConsume_SuccessAndEmpty", func(t *testing.T) {
-		clearTable(t)
+		repo := newRepo(t)
 
 		clientID := "consumer-client"
 		bucket := &models.Bucket{
-			ClientID: clientID,
-			Capacity: 3,
-			Tokens:   3,
+			ClientID:   clientID,
+			Capacity:   3,
+			Tokens:     3,
+			LastRefill: time.Now(),
 		}
 
 		err := repo.CreateBucket(ctx, bucket)
@@ -124,43 +183,309 @@ func TestBucketRepository(t *testing.T) {
 
 		// Последовательно потребляем токены
 		for i := 0; i < bucket.Capacity; i++ {
-			err := repo.TryConsume(ctx, clientID)
+			res, err := repo.TryConsume(ctx, clientID, 1)
 			require.NoError(t, err, "Ошибка при потреблении токена")
 
-			got, _ := repo.GetBucket(ctx, clientID)
 			expectedTokens := bucket.Capacity - (i + 1)
-			require.Equal(t, expectedTokens, got.Tokens, "Неправильное число токенов после TryConsume")
+			require.Equal(t, expectedTokens, res.Tokens, "Неправильное число токенов после TryConsume")
+			got, _ := repo.GetBucket(ctx, clientID)
+			require.Equal(t, expectedTokens, got.Tokens, "Неправильное число токенов в бд")
 		}
 
-		err = repo.TryConsume(ctx, clientID)
-		require.Equal(t, err, errdefs.NotEnoughTokens, "Ожидалась ошибка NotEnoughTokens при TryConsume из пустого бакета")
+		res, err := repo.TryConsume(ctx, clientID, 1)
+		require.ErrorIs(t, err, errdefs.NotEnoughTokens, "Ожидалась ошибка NotEnoughTokens при TryConsume из пустого бакета")
+		require.False(t, res.Allowed)
+		require.Equal(t, 0, res.Tokens)
+		require.Positive(t, res.NextToken, "Пустой бакет должен сообщать, когда появится токен")
+		require.Equal(t, res.NextToken, res.RetryAfter)
 	})
 
-	t.Run("RefillTokens", func(t *testing.T) {
-		clearTable(t)
+	t.Run("TryConsume_CreatesBucket", func(t *testing.T) {
+		repo := newRepo(t)
+
+		res, err := repo.TryConsume(ctx, "new-client", 1)
+		require.NoError(t, err)
+		require.Equal(t, cfg.Bucket.Capacity-1, res.Tokens)
+		require.Equal(t, cfg.Bucket.Capacity, res.Capacity)
+
+		got, err := repo.GetBucket(ctx, "new-client")
+		require.NoError(t, err)
+		require.Equal(t, cfg.Bucket.Capacity-1, got.Tokens)
+	})
 
+	t.Run("TryConsume_LazyRefill", func(t *testing.T) {
+		repo := newRepo(t)
+
+		interval := time.Duration(cfg.Bucket.Refill.Interval)
 		clientID := "refill-client"
-		initialTokens := 1
 		capacity := 5
+		// прошло два с половиной интервала - положено два пополнения
 		bucket := &models.Bucket{
-			ClientID: clientID,
-			Capacity: capacity,
-			Tokens:   initialTokens,
+			ClientID:   clientID,
+			Capacity:   capacity,
+			Tokens:     0,
+			LastRefill: time.Now().Add(-interval * 5 / 2),
 		}
-
 		err := repo.CreateBucket(ctx, bucket)
 		require.NoError(t, err)
 
-		// Заполняем токены до capacity
-		err = repo.RefillTokens(ctx, clientID)
-		require.NoError(t, err, "Ошибка при RefillTokens")
+		res, err := repo.TryConsume(ctx, clientID, 1)
+		require.NoError(t, err)
+		require.Equal(t, min(2*cfg.Bucket.Refill.Amount, capacity)-1, res.Tokens)
+		// половина интервала уже прошла и не теряется
+		require.InDelta(t, float64(interval/2), float64(res.NextToken), float64(5*time.Second))
+
+		got, err := repo.GetBucket(ctx, clientID)
+		require.NoError(t, err)
+		require.WithinDuration(t, bucket.LastRefill.Add(2*interval), got.LastRefill, time.Second,
+			"last_refill должен сдвинуться ровно на число пополнений")
+	})
+
+	t.Run("TryConsume_BucketRefill", func(t *testing.T) {
+		repo := newRepo(t)
+
+		// у клиента своя скорость: 3 токена раз в 10 секунд вместо конфига
+		interval := 10 * time.Second
+		bucket := &models.Bucket{
+			ClientID:       "paid",
+			Capacity:       10,
+			Tokens:         0,
+			LastRefill:     time.Now().Add(-interval * 5 / 2),
+			RefillRate:     3,
+			RefillInterval: models.Duration(interval),
+		}
+		require.NoError(t, repo.CreateBucket(ctx, bucket))
+
+		got, err := repo.GetBucket(ctx, "paid")
+		require.NoError(t, err)
+		require.Equal(t, 3, got.RefillRate)
+		require.Equal(t, models.Duration(interval), got.RefillInterval)
+
+		res, err := repo.TryConsume(ctx, "paid", 1)
+		require.NoError(t, err)
+		require.Equal(t, 2*3-1, res.Tokens)
+		require.InDelta(t, float64(interval/2), float64(res.NextToken), float64(time.Second))
+		// до полного не хватает 5: два пополнения по 3
+		require.InDelta(t, float64(interval/2+interval), float64(res.Reset), float64(time.Second))
+
+		// сброс на значения из конфига
+		require.NoError(t, repo.UpdateRefill(ctx, "paid", 0, 0))
+		got, err = repo.GetBucket(ctx, "paid")
+		require.NoError(t, err)
+		require.Zero(t, got.RefillRate)
+		require.Zero(t, got.RefillInterval)
+		require.Equal(t, 5, got.Tokens, "смена скорости не трогает токены")
+
+		require.NoError(t, repo.UpdateRefill(ctx, "paid", 2, 0), "поля подставляются по отдельности")
+		got, err = repo.GetBucket(ctx, "paid")
+		require.NoError(t, err)
+		require.Equal(t, 2, got.RefillRate)
+		require.Zero(t, got.RefillInterval)
+
+		require.ErrorIs(t, repo.UpdateRefill(ctx, "missing", 1, time.Second), errdefs.ErrNotFound)
+		require.ErrorIs(t, repo.UpdateRefill(ctx, "paid", -1, 0), errdefs.ErrInvalidInput)
+		err = repo.CreateBucket(ctx, &models.Bucket{ClientID: "bad", Capacity: 1, RefillInterval: models.Duration(-time.Second)})
+		require.ErrorIs(t, err, errdefs.ErrInvalidInput)
+	})
+
+	t.Run("TryConsume_Cost", func(t *testing.T) {
+		repo := newRepo(t)
+
+		bucket := &models.Bucket{ClientID: "heavy", Capacity: 10, Tokens: 10, LastRefill: time.Now()}
+		require.NoError(t, repo.CreateBucket(ctx, bucket))
+
+		res, err := repo.TryConsume(ctx, "heavy", 4)
+		require.NoError(t, err)
+		require.Equal(t, 6, res.Tokens)
+
+		// запрос дороже остатка отклоняется и ничего не списывает
+		res, err = repo.TryConsume(ctx, "heavy", 7)
+		require.ErrorIs(t, err, errdefs.NotEnoughTokens)
+		require.Equal(t, 6, res.Tokens)
 
-		// ?token == initialTokens+cfg.Bucket.Refill.Amount
+		// стоимость клиента важнее стоимости по правилам
+		require.NoError(t, repo.UpdateCost(ctx, "heavy", 5))
+		res, err = repo.TryConsume(ctx, "heavy", 1)
+		require.NoError(t, err)
+		require.Equal(t, 1, res.Tokens)
+
+		got, err := repo.GetBucket(ctx, "heavy")
+		require.NoError(t, err)
+		require.Equal(t, 5, got.Cost)
+
+		require.NoError(t, repo.UpdateCost(ctx, "heavy", 0))
+		res, err = repo.TryConsume(ctx, "heavy", 1)
+		require.NoError(t, err)
+		require.Equal(t, 0, res.Tokens)
+
+		require.ErrorIs(t, repo.UpdateCost(ctx, "missing", 2), errdefs.ErrNotFound)
+		require.ErrorIs(t, repo.UpdateCost(ctx, "heavy", -1), errdefs.ErrInvalidInput)
+	})
+
+	t.Run("TryConsumeAll", func(t *testing.T) {
+		repo := newRepo(t)
+
+		reqs := []models.ConsumeRequest{
+			{ClientID: models.PolicyBucketID("search", "c1"), Cost: 1, Capacity: 5, RefillAmount: 1, RefillInterval: time.Hour},
+			{ClientID: models.PolicyBucketID("all", "c1"), Cost: 1, Capacity: 2, RefillAmount: 1, RefillInterval: time.Hour},
+		}
+		res, err := repo.TryConsumeAll(ctx, reqs)
+		require.NoError(t, err)
+		require.Equal(t, 1, res.Tokens, "отвечает бакет с наименьшим остатком")
+		require.Equal(t, 2, res.Capacity)
+
+		_, err = repo.TryConsumeAll(ctx, reqs)
+		require.NoError(t, err)
+
+		res, err = repo.TryConsumeAll(ctx, reqs)
+		require.ErrorIs(t, err, errdefs.NotEnoughTokens)
+		require.Equal(t, 2, res.Capacity, "отказал бакет all")
+		require.Positive(t, res.RetryAfter)
+
+		// с search при отказе ничего не списано
+		got, err := repo.GetBucket(ctx, reqs[0].ClientID)
+		require.NoError(t, err)
+		require.Equal(t, 3, got.Tokens)
+		require.Equal(t, 5, got.Capacity)
+	})
+
+	t.Run("SpendBuckets", func(t *testing.T) {
+		repo := newRepo(t)
+		writer, ok := repo.(interfaces.IBucketBatchWriter)
+		require.True(t, ok)
+		require.NoError(t, repo.CreateBucket(ctx, &models.Bucket{ClientID: "a", Capacity: 10, Tokens: 6, LastRefill: time.Now()}))
+
+		// списания складываются, а не перезаписывают друг друга
+		reqs := []models.ConsumeRequest{
+			{ClientID: "b", Cost: 3, Capacity: 5, RefillAmount: 1, RefillInterval: time.Hour},
+			{ClientID: "a", Cost: 2, Capacity: 5, RefillAmount: 1, RefillInterval: time.Hour},
+		}
+		buckets, err := writer.SpendBuckets(ctx, reqs)
+		require.NoError(t, err)
+		require.Len(t, buckets, 2)
+		require.Equal(t, "b", buckets[0].ClientID)
+		require.Equal(t, 2, buckets[0].Tokens, "новый бакет создаётся полным")
+		require.Equal(t, 5, buckets[0].Capacity)
+		require.Equal(t, "a", buckets[1].ClientID)
+		require.Equal(t, 4, buckets[1].Tokens)
+		require.Equal(t, 10, buckets[1].Capacity, "ёмкость существующего бакета не меняется")
+
+		buckets, err = writer.SpendBuckets(ctx, []models.ConsumeRequest{{ClientID: "a", Cost: 7, Capacity: 5}})
+		require.NoError(t, err)
+		require.Equal(t, 0, buckets[0].Tokens, "ниже нуля бакет не уходит")
+		got, err := repo.GetBucket(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, 0, got.Tokens)
+	})
+
+	t.Run("TryConsume_Parallel", func(t *testing.T) {
+		repo := newRepo(t)
+
+		clientID := "parallel-client"
+		capacity := 20
+		err := repo.CreateBucket(ctx, &models.Bucket{
+			ClientID:   clientID,
+			Capacity:   capacity,
+			Tokens:     capacity,
+			LastRefill: time.Now(),
+		})
+		require.NoError(t, err)
+
+		// запросов больше, чем токенов: ни один лишний не должен пройти
+		workers, perWorker := 10, 10
+		var allowed, denied atomic.Int64
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perWorker; i++ {
+					_, err := repo.TryConsume(ctx, clientID, 1)
+					switch {
+					case err == nil:
+						allowed.Add(1)
+					case errdefs.Is(err, errdefs.NotEnoughTokens):
+						denied.Add(1)
+					default:
+						t.Errorf("unexpected error: %v", err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		require.EqualValues(t, capacity, allowed.Load(), "Списано больше токенов, чем было в бакете")
+		require.EqualValues(t, workers*perWorker-capacity, denied.Load())
 		got, err := repo.GetBucket(ctx, clientID)
 		require.NoError(t, err)
-		require.Equal(t,
-			initialTokens+cfg.Bucket.Refill.Amount,
-			got.Tokens,
-			"Токены после RefillTokens должны быть равны capacity")
+		require.Equal(t, 0, got.Tokens)
+	})
+
+	t.Run("UpdateTokensResetsRefill", func(t *testing.T) {
+		repo := newRepo(t)
+
+		clientID := "admin-client"
+		past := time.Now().Add(-time.Hour)
+		err := repo.CreateBucket(ctx, &models.Bucket{ClientID: clientID, Capacity: 5, Tokens: 1, LastRefill: past})
+		require.NoError(t, err)
+
+		// выдача токенов вручную сбрасывает отсчёт пополнения
+		require.NoError(t, repo.UpdateCountTokens(ctx, clientID, 3))
+		got, err := repo.GetBucket(ctx, clientID)
+		require.NoError(t, err)
+		require.Equal(t, 3, got.Tokens)
+		require.WithinDuration(t, time.Now(), got.LastRefill, 5*time.Second)
+	})
+
+	t.Run("UpdateErrors", func(t *testing.T) {
+		repo := newRepo(t)
+
+		err := repo.CreateBucket(ctx, &models.Bucket{ClientID: "limits", Capacity: 5, Tokens: 3, LastRefill: time.Now()})
+		require.NoError(t, err)
+
+		require.ErrorIs(t, repo.UpdateCapacity(ctx, "limits", 2), errdefs.TokensLeCap)
+		require.ErrorIs(t, repo.UpdateCountTokens(ctx, "limits", 6), errdefs.TokensLeCap)
+		require.ErrorIs(t, repo.UpdateCountTokens(ctx, "limits", -1), errdefs.NotEnoughTokens)
+		require.ErrorIs(t, repo.UpdateCapacity(ctx, "missing", 5), errdefs.ErrNotFound)
+		require.ErrorIs(t, repo.UpdateCountTokens(ctx, "missing", 1), errdefs.ErrNotFound)
+		require.ErrorIs(t, repo.RemoveBucket(ctx, "missing"), errdefs.ErrNotFound)
+
+		// неудачные обновления ничего не поменяли
+		got, err := repo.GetBucket(ctx, "limits")
+		require.NoError(t, err)
+		require.Equal(t, 5, got.Capacity)
+		require.Equal(t, 3, got.Tokens)
+	})
+
+	t.Run("ListBuckets", func(t *testing.T) {
+		repo := newRepo(t)
+
+		now := time.Now()
+		for i, id := range []string{"old", "mid", "new"} {
+			err := repo.CreateBucket(ctx, &models.Bucket{
+				ClientID:   id,
+				Capacity:   1,
+				Tokens:     1,
+				LastRefill: now.Add(time.Duration(i) * time.Minute),
+			})
+			require.NoError(t, err)
+		}
+
+		ids := func(buckets *[]models.Bucket) []string {
+			var res []string
+			for _, b := range *buckets {
+				res = append(res, b.ClientID)
+			}
+			return res
+		}
+		page, err := repo.ListBuckets(ctx, 2, 0)
+		require.NoError(t, err)
+		require.Equal(t, []string{"new", "mid"}, ids(page))
+		page, err = repo.ListBuckets(ctx, 2, 2)
+		require.NoError(t, err)
+		require.Equal(t, []string{"old"}, ids(page))
+		page, err = repo.ListBuckets(ctx, 2, 5)
+		require.NoError(t, err)
+		require.Empty(t, ids(page))
 	})
 }
@@ -0,0 +1,589 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/interfaces"
+	"gopher-equalizer/internal/logger"
+	"gopher-equalizer/internal/models"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCacheSize   = 100000
+	defaultCacheShards = 32
+	defaultFlushBatch  = 500
+)
+
+// BucketStore - хранилище, поверх которого работает кеш
+type BucketStore interface {
+	interfaces.IBucketRepository
+	interfaces.IBucketBatchWriter
+}
+
+type cacheEntry struct {
+	bucket models.Bucket
+	// pending - что записать в хранилище при сбросе: Cost - токены, выданные
+	// с прошлого сброса, остальное - ёмкость и пополнение по умолчанию
+	pending models.ConsumeRequest
+	elem    *list.Element
+}
+
+// cacheShard - часть кеша со своим мьютексом и своим LRU
+type cacheShard struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	lru     *list.List // front - самый свежий, значения - clientID
+	// dirty - бакеты, с которых списали после последнего сброса в хранилище
+	dirty map[string]struct{}
+	// evicted - вытесненные из LRU, но ещё не записанные бакеты
+	evicted map[string]*cacheEntry
+	// spent - несброшенные списания бакетов, выкинутых из кеша инвалидацией.
+	// Они уйдут в хранилище при сбросе, а если бакет загрузят раньше, лягут
+	// поверх прочитанного из хранилища состояния
+	spent map[string]models.ConsumeRequest
+	// epoch растёт при каждой инвалидации, чтобы загрузка, начатая до неё,
+	// не положила в кеш устаревшее состояние
+	epoch uint64
+}
+
+// CachedRepository - декоратор IBucketRepository, который списывает токены
+// в памяти и пишет их в хранилище пачками раз в FlushInterval (write-behind).
+// В хранилище уходит не остаток, а сколько списано, и бакет в кеше
+// обновляется ответом хранилища, поэтому списания реплик складываются.
+// Запись через admin API идёт сразу в хранилище, а бакет выкидывается из кеша.
+// Его несброшенные списания при этом сохраняются, кроме записей, которые
+// задают остаток заново или удаляют бакет.
+// Цена скорости: при падении процесса теряются списания за последний интервал,
+// а между сбросами каждая реплика тратит свою копию бакета
+type CachedRepository struct {
+	store    BucketStore
+	cfg      *config.Config
+	shards   []*cacheShard
+	shardCap int
+	// flushMu не даёт сбросу перезаписать изменения, сделанные через API
+	flushMu sync.Mutex
+	now     func() time.Time
+}
+
+func NewCachedRepository(store BucketStore, cfg *config.Config) *CachedRepository {
+	cc := cfg.Bucket.Cache
+	if cc.Size <= 0 {
+		cc.Size = defaultCacheSize
+	}
+	if cc.Shards <= 0 {
+		cc.Shards = defaultCacheShards
+	}
+	if cc.Shards > cc.Size {
+		cc.Shards = cc.Size
+	}
+
+	c := &CachedRepository{
+		store:    store,
+		cfg:      cfg,
+		shards:   make([]*cacheShard, cc.Shards),
+		shardCap: (cc.Size + cc.Shards - 1) / cc.Shards,
+		now:      time.Now,
+	}
+	for i := range c.shards {
+		c.shards[i] = &cacheShard{
+			entries: make(map[string]*cacheEntry),
+			lru:     list.New(),
+			dirty:   make(map[string]struct{}),
+			evicted: make(map[string]*cacheEntry),
+			spent:   make(map[string]models.ConsumeRequest),
+		}
+	}
+	return c
+}
+
+// Start запускает периодический сброс. После отмены ctx делается последний
+// сброс, Done закрывается, когда он закончен
+func (c *CachedRepository) Start(ctx context.Context) (done <-chan struct{}) {
+	ch := make(chan struct{})
+	interval := time.Duration(c.cfg.Bucket.Cache.FlushInterval)
+	log := logger.GetLoggerFromCtx(ctx)
+
+	go func() {
+		defer close(ch)
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				if err := c.Flush(context.WithoutCancel(ctx)); err != nil {
+					log.Error(ctx, "CACHE: final flush failed", zap.Error(err))
+				}
+				return
+			case <-tick:
+				if err := c.Flush(ctx); err != nil {
+					log.Error(ctx, "CACHE: flush failed", zap.Error(err))
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// Логика
+func (c *CachedRepository) TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error) {
+	return c.TryConsumeAll(ctx, []models.ConsumeRequest{defaultRequest(c.cfg, clientID, cost)})
+}
+
+// TryConsumeAll держит мьютексы всех нужных шардов разом, беря их в порядке
+// номеров, поэтому списание с нескольких бакетов атомарно и встречные
+// списания не блокируют друг друга. Списание идёт по копиям бакетов, в кеш
+// они кладутся уже после него: бакетов запроса в шарде может оказаться
+// больше, чем в нём помещается
+func (c *CachedRepository) TryConsumeAll(ctx context.Context, reqs []models.ConsumeRequest) (*models.ConsumeResult, error) {
+	shards := c.lockOrder(reqs)
+	lock := func() {
+		for _, sh := range shards {
+			sh.mu.Lock()
+		}
+	}
+	unlock := func() {
+		for _, sh := range shards {
+			sh.mu.Unlock()
+		}
+	}
+
+	for {
+		lock()
+		buckets := make([]models.Bucket, len(reqs))
+		var missing []int
+		for i, req := range reqs {
+			b, ok := c.shard(req.ClientID).lookup(req.ClientID)
+			if !ok {
+				missing = append(missing, i)
+			}
+			buckets[i] = b
+		}
+		if len(missing) == 0 {
+			res := c.consume(buckets, reqs)
+			unlock()
+			return c.result(ctx, res)
+		}
+		epochs := make(map[*cacheShard]uint64, len(shards))
+		for _, sh := range shards {
+			epochs[sh] = sh.epoch
+		}
+		unlock()
+
+		// загружаем без мьютексов, чтобы не держать шарды на время запроса
+		for _, i := range missing {
+			bucket, err := c.load(ctx, reqs[i])
+			if err != nil {
+				return nil, err
+			}
+			buckets[i] = bucket
+		}
+
+		lock()
+		changed := false
+		for _, sh := range shards {
+			// бакет поменяли через API, пока он грузился
+			changed = changed || sh.epoch != epochs[sh]
+		}
+		if changed {
+			unlock()
+			continue
+		}
+		for i, req := range reqs {
+			sh := c.shard(req.ClientID)
+			// пока шарды были отпущены, бакет мог загрузить и списать
+			// встречный запрос - его состояние свежее
+			if b, ok := sh.lookup(req.ClientID); ok {
+				buckets[i] = b
+			} else if s, ok := sh.spent[req.ClientID]; ok {
+				// списания до инвалидации ещё не дошли до хранилища
+				c.applySpent(&buckets[i], s)
+				delete(sh.spent, req.ClientID)
+				sh.set(s, buckets[i], s.Cost, c.shardCap)
+			}
+		}
+		res := c.consume(buckets, reqs)
+		unlock()
+		return c.result(ctx, res)
+	}
+}
+
+// consume списывает с копий бакетов и кладёт их в кеш, бакеты, с которых
+// списаны токены, помечаются для сброса. Мьютексы шардов reqs уже захвачены
+func (c *CachedRepository) consume(buckets []models.Bucket, reqs []models.ConsumeRequest) models.ConsumeResult {
+	before := slices.Clone(buckets)
+	res, save := consumeAll(buckets, reqs, c.now())
+	for i, req := range reqs {
+		b, spent := before[i], 0
+		if save[i] {
+			b = buckets[i]
+		}
+		if res.Allowed {
+			spent = b.CostOf(req.Cost)
+		}
+		c.shard(req.ClientID).set(req, b, spent, c.shardCap)
+	}
+	return res
+}
+
+// result возвращает ответ как у Postgres-репозитория. Без интервала сброса
+// состояние пишется в хранилище сразу (write-through)
+func (c *CachedRepository) result(ctx context.Context, res models.ConsumeResult) (*models.ConsumeResult, error) {
+	if c.cfg.Bucket.Cache.FlushInterval <= 0 {
+		if err := c.Flush(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if !res.Allowed {
+		return &res, errdefs.NotEnoughTokens
+	}
+	return &res, nil
+}
+
+// load читает бакет из хранилища, отсутствующий создаётся полным и будет
+// записан при ближайшем сбросе
+func (c *CachedRepository) load(ctx context.Context, req models.ConsumeRequest) (models.Bucket, error) {
+	b, err := c.store.GetBucket(ctx, req.ClientID)
+	if err == nil {
+		return *b, nil
+	}
+	if !errdefs.Is(err, errdefs.ErrNotFound) {
+		return models.Bucket{}, err
+	}
+	return newBucket(req, c.now()), nil
+}
+
+// Flush пишет списания всех изменённых бакетов в хранилище пачками по
+// FlushBatch и обновляет бакеты в кеше его ответом: так реплика видит
+// списания остальных. Не записанные из-за ошибки списания ждут следующего сброса
+func (c *CachedRepository) Flush(ctx context.Context) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+	return c.flush(ctx)
+}
+
+// flush - Flush под уже захваченным flushMu
+func (c *CachedRepository) flush(ctx context.Context) error {
+	var pending []cacheEntry
+	epochs := make(map[*cacheShard]uint64, len(c.shards))
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		for id := range sh.dirty {
+			if e, ok := sh.entries[id]; ok {
+				pending = append(pending, *e)
+				e.pending.Cost = 0
+			}
+		}
+		for _, e := range sh.evicted {
+			pending = append(pending, *e)
+		}
+		for _, s := range sh.spent {
+			pending = append(pending, cacheEntry{pending: s})
+		}
+		clear(sh.dirty)
+		clear(sh.evicted)
+		clear(sh.spent)
+		epochs[sh] = sh.epoch
+		sh.mu.Unlock()
+	}
+
+	batch := c.cfg.Bucket.Cache.FlushBatch
+	if batch <= 0 {
+		batch = defaultFlushBatch
+	}
+	for start := 0; start < len(pending); start += batch {
+		end := min(start+batch, len(pending))
+		reqs := make([]models.ConsumeRequest, 0, end-start)
+		for _, e := range pending[start:end] {
+			reqs = append(reqs, e.pending)
+		}
+		buckets, err := c.store.SpendBuckets(ctx, reqs)
+		if err != nil {
+			c.remark(pending[start:])
+			return err
+		}
+		c.refresh(buckets, epochs)
+	}
+	return nil
+}
+
+// refresh кладёт в кеш состояние бакетов из хранилища за вычетом того, что
+// успели списать после начала сброса. Бакеты шардов, где с начала сброса
+// была инвалидация, не трогаются: их уже перечитали после записи через API
+func (c *CachedRepository) refresh(buckets []models.Bucket, epochs map[*cacheShard]uint64) {
+	for _, b := range buckets {
+		sh := c.shard(b.ClientID)
+		sh.mu.Lock()
+		e, ok := sh.entries[b.ClientID]
+		if !ok {
+			e, ok = sh.evicted[b.ClientID]
+		}
+		if ok && sh.epoch == epochs[sh] {
+			e.bucket.Capacity = b.Capacity
+			e.bucket.Tokens = max(0, b.Tokens-e.pending.Cost)
+			e.bucket.LastRefill = b.LastRefill
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// remark возвращает не записанные списания в очередь на сброс. Если бакет
+// уже успели списать ещё раз, в кеше лежит более свежее состояние, а если
+// его выкинули из кеша, списания ждут в spent
+func (c *CachedRepository) remark(pending []cacheEntry) {
+	for _, p := range pending {
+		id := p.pending.ClientID
+		sh := c.shard(id)
+		sh.mu.Lock()
+		if e, ok := sh.entries[id]; ok {
+			e.pending.Cost += p.pending.Cost
+			sh.dirty[id] = struct{}{}
+		} else if e, ok := sh.evicted[id]; ok {
+			e.pending.Cost += p.pending.Cost
+		} else {
+			sh.keep(p.pending)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// Invalidate выкидывает бакет из кеша, следующее обращение прочитает его из
+// хранилища. Несброшенные списания не теряются: они уйдут в хранилище при
+// сбросе, а до того вычитаются из прочитанного состояния
+func (c *CachedRepository) Invalidate(clientID string) {
+	sh := c.shard(clientID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if e, ok := sh.entries[clientID]; ok {
+		sh.keep(e.pending)
+	} else if e, ok := sh.evicted[clientID]; ok {
+		sh.keep(e.pending)
+	}
+	sh.remove(clientID)
+	sh.epoch++
+}
+
+// Reset выкидывает бакет из кеша вместе с несброшенными списаниями. Нужен
+// после записей, которые задают остаток заново или удаляют бакет: списания
+// до них уже ничего не значат
+func (c *CachedRepository) Reset(clientID string) {
+	sh := c.shard(clientID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.remove(clientID)
+	delete(sh.spent, clientID)
+	sh.epoch++
+}
+
+// InvalidateAll сбрасывает несохранённые списания в хранилище и выкидывает
+// из кеша все бакеты. Бакеты, которые записать не удалось, остаются в кеше,
+// чтобы не потерять списания, а ошибка возвращается
+func (c *CachedRepository) InvalidateAll(ctx context.Context) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	err := c.flush(ctx)
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		for id := range sh.entries {
+			if _, ok := sh.dirty[id]; !ok {
+				sh.remove(id)
+			}
+		}
+		sh.epoch++
+		sh.mu.Unlock()
+	}
+	return err
+}
+
+// write выполняет запись через API в обход кеша и выкидывает бакет через
+// invalidate: Invalidate или Reset. Сброс на это время останавливается,
+// чтобы не перезаписать её устаревшим состоянием из памяти
+func (c *CachedRepository) write(clientID string, invalidate func(string), fn func() error) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+	err := fn()
+	invalidate(clientID)
+	return err
+}
+
+// CRUD
+func (c *CachedRepository) CreateBucket(ctx context.Context, bucket *models.Bucket) error {
+	return c.write(bucket.ClientID, c.Invalidate, func() error {
+		return c.store.CreateBucket(ctx, bucket)
+	})
+}
+
+func (c *CachedRepository) RemoveBucket(ctx context.Context, clientID string) error {
+	return c.write(clientID, c.Reset, func() error {
+		return c.store.RemoveBucket(ctx, clientID)
+	})
+}
+
+func (c *CachedRepository) UpdateCapacity(ctx context.Context, clientID string, newCapacity int) error {
+	return c.write(clientID, c.Invalidate, func() error {
+		return c.store.UpdateCapacity(ctx, clientID, newCapacity)
+	})
+}
+
+func (c *CachedRepository) UpdateCountTokens(ctx context.Context, clientID string, newCountT int) error {
+	return c.write(clientID, c.Reset, func() error {
+		return c.store.UpdateCountTokens(ctx, clientID, newCountT)
+	})
+}
+
+func (c *CachedRepository) UpdateRefill(ctx context.Context, clientID string, rate int, interval time.Duration) error {
+	return c.write(clientID, c.Invalidate, func() error {
+		return c.store.UpdateRefill(ctx, clientID, rate, interval)
+	})
+}
+
+func (c *CachedRepository) UpdateCost(ctx context.Context, clientID string, cost int) error {
+	return c.write(clientID, c.Invalidate, func() error {
+		return c.store.UpdateCost(ctx, clientID, cost)
+	})
+}
+
+// GetBucket отдаёт состояние из памяти, оно свежее, чем в хранилище
+func (c *CachedRepository) GetBucket(ctx context.Context, clientID string) (*models.Bucket, error) {
+	sh := c.shard(clientID)
+	sh.mu.Lock()
+	if e, ok := sh.entries[clientID]; ok {
+		b := e.bucket
+		sh.mu.Unlock()
+		return &b, nil
+	}
+	if e, ok := sh.evicted[clientID]; ok {
+		b := e.bucket
+		sh.mu.Unlock()
+		return &b, nil
+	}
+	s, spent := sh.spent[clientID]
+	sh.mu.Unlock()
+
+	b, err := c.store.GetBucket(ctx, clientID)
+	if err == nil && spent {
+		c.applySpent(b, s)
+	}
+	return b, err
+}
+
+// applySpent вычитает из прочитанного из хранилища бакета списания, которые
+// в него ещё не записаны, так же, как это сделает SpendBuckets
+func (c *CachedRepository) applySpent(b *models.Bucket, s models.ConsumeRequest) {
+	amount, interval := b.Refill(s.RefillAmount, s.RefillInterval)
+	spend(b, s.Cost, amount, interval, c.now())
+}
+
+// ListBuckets читает из хранилища, списания за последний интервал сброса в нём не видны
+func (c *CachedRepository) ListBuckets(ctx context.Context, limit, offset int) (*[]models.Bucket, error) {
+	return c.store.ListBuckets(ctx, limit, offset)
+}
+
+// lockOrder - шарды бакетов из reqs без повторов, по возрастанию номера
+func (c *CachedRepository) lockOrder(reqs []models.ConsumeRequest) []*cacheShard {
+	idx := make([]int, 0, len(reqs))
+	for _, req := range reqs {
+		if i := c.shardIndex(req.ClientID); !slices.Contains(idx, i) {
+			idx = append(idx, i)
+		}
+	}
+	slices.Sort(idx)
+	shards := make([]*cacheShard, len(idx))
+	for i, n := range idx {
+		shards[i] = c.shards[n]
+	}
+	return shards
+}
+
+func (c *CachedRepository) shard(clientID string) *cacheShard {
+	return c.shards[c.shardIndex(clientID)]
+}
+
+func (c *CachedRepository) shardIndex(clientID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(clientID))
+	return int(h.Sum32() % uint32(len(c.shards)))
+}
+
+// lookup ищет бакет в кеше и среди вытесненных, но не записанных.
+// sh.mu уже захвачен
+func (sh *cacheShard) lookup(clientID string) (models.Bucket, bool) {
+	if e, ok := sh.entries[clientID]; ok {
+		return e.bucket, true
+	}
+	if e, ok := sh.evicted[clientID]; ok {
+		return e.bucket, true
+	}
+	return models.Bucket{}, false
+}
+
+// set кладёт бакет в начало LRU и добавляет spent к несброшенным списаниям.
+// Самые старые сверх capacity вытесняются, бакеты с несброшенными
+// списаниями при этом ждут сброса в evicted. sh.mu уже захвачен
+func (sh *cacheShard) set(req models.ConsumeRequest, b models.Bucket, spent, capacity int) {
+	id := req.ClientID
+	e, ok := sh.entries[id]
+	if ok {
+		sh.lru.MoveToFront(e.elem)
+	} else {
+		if e, ok = sh.evicted[id]; ok {
+			delete(sh.evicted, id)
+		} else {
+			e = &cacheEntry{}
+		}
+		e.elem = sh.lru.PushFront(id)
+		sh.entries[id] = e
+	}
+	e.bucket = b
+	req.Cost = e.pending.Cost + spent
+	e.pending = req
+	if e.pending.Cost > 0 {
+		sh.dirty[id] = struct{}{}
+	}
+
+	for sh.lru.Len() > capacity {
+		oldest := sh.lru.Back()
+		old := oldest.Value.(string)
+		if _, ok := sh.dirty[old]; ok {
+			sh.evicted[old] = sh.entries[old]
+			delete(sh.dirty, old)
+		}
+		sh.lru.Remove(oldest)
+		delete(sh.entries, old)
+	}
+}
+
+// keep откладывает несброшенные списания бакета, который выкидывается из
+// кеша, в spent. sh.mu уже захвачен
+func (sh *cacheShard) keep(pending models.ConsumeRequest) {
+	if pending.Cost <= 0 {
+		return
+	}
+	if s, ok := sh.spent[pending.ClientID]; ok {
+		pending.Cost += s.Cost
+	}
+	sh.spent[pending.ClientID] = pending
+}
+
+// remove удаляет бакет из кеша, sh.mu уже захвачен
+func (sh *cacheShard) remove(clientID string) {
+	if e, ok := sh.entries[clientID]; ok {
+		sh.lru.Remove(e.elem)
+		delete(sh.entries, clientID)
+	}
+	delete(sh.dirty, clientID)
+	delete(sh.evicted, clientID)
+}
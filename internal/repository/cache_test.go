@@ -0,0 +1,476 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/logger"
+	"gopher-equalizer/internal/models"
+)
+
+// fakeStore - хранилище в памяти, считает обращения кеша
+type fakeStore struct {
+	mu      sync.Mutex
+	buckets map[string]models.Bucket
+	gets    int
+	spends  [][]models.ConsumeRequest
+	saveErr error
+}
+
+func newFakeStore(buckets ...models.Bucket) *fakeStore {
+	fs := &fakeStore{buckets: make(map[string]models.Bucket)}
+	for _, b := range buckets {
+		fs.buckets[b.ClientID] = b
+	}
+	return fs
+}
+
+func (fs *fakeStore) CreateBucket(ctx context.Context, b *models.Bucket) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.buckets[b.ClientID]; ok {
+		return errdefs.ErrConflict
+	}
+	fs.buckets[b.ClientID] = *b
+	return nil
+}
+
+func (fs *fakeStore) RemoveBucket(ctx context.Context, clientID string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.buckets, clientID)
+	return nil
+}
+
+func (fs *fakeStore) UpdateCapacity(ctx context.Context, clientID string, newCapacity int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	b := fs.buckets[clientID]
+	b.Capacity = newCapacity
+	fs.buckets[clientID] = b
+	return nil
+}
+
+func (fs *fakeStore) UpdateCountTokens(ctx context.Context, clientID string, newCountT int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	b := fs.buckets[clientID]
+	b.Tokens = newCountT
+	fs.buckets[clientID] = b
+	return nil
+}
+
+func (fs *fakeStore) UpdateRefill(ctx context.Context, clientID string, rate int, interval time.Duration) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	b := fs.buckets[clientID]
+	b.RefillRate = rate
+	b.RefillInterval = models.Duration(interval)
+	fs.buckets[clientID] = b
+	return nil
+}
+
+func (fs *fakeStore) UpdateCost(ctx context.Context, clientID string, cost int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	b := fs.buckets[clientID]
+	b.Cost = cost
+	fs.buckets[clientID] = b
+	return nil
+}
+
+func (fs *fakeStore) ListBuckets(ctx context.Context, limit, offset int) (*[]models.Bucket, error) {
+	return &[]models.Bucket{}, nil
+}
+
+func (fs *fakeStore) GetBucket(ctx context.Context, clientID string) (*models.Bucket, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.gets++
+	b, ok := fs.buckets[clientID]
+	if !ok {
+		return nil, errdefs.ErrNotFound
+	}
+	return &b, nil
+}
+
+func (fs *fakeStore) TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error) {
+	panic("cache must consume locally")
+}
+
+func (fs *fakeStore) TryConsumeAll(ctx context.Context, reqs []models.ConsumeRequest) (*models.ConsumeResult, error) {
+	panic("cache must consume locally")
+}
+
+func (fs *fakeStore) SpendBuckets(ctx context.Context, reqs []models.ConsumeRequest) ([]models.Bucket, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.saveErr != nil {
+		return nil, fs.saveErr
+	}
+	fs.spends = append(fs.spends, append([]models.ConsumeRequest(nil), reqs...))
+	buckets := make([]models.Bucket, len(reqs))
+	for i, req := range reqs {
+		b, ok := fs.buckets[req.ClientID]
+		if !ok {
+			b = newBucket(req, time.Now())
+		}
+		b.Tokens = max(0, b.Tokens-req.Cost)
+		fs.buckets[req.ClientID] = b
+		buckets[i] = b
+	}
+	return buckets, nil
+}
+
+func (fs *fakeStore) tokens(clientID string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.buckets[clientID].Tokens
+}
+
+func cacheConfig(cache config.CacheConfig) *config.Config {
+	cfg := &config.Config{}
+	cfg.Bucket.Capacity = 10
+	cfg.Bucket.Refill = config.RefillConfig{Interval: config.Duration(time.Minute), Amount: 1}
+	cfg.Bucket.Cache = cache
+	return cfg
+}
+
+// silentLogger - контекст с логгером без вывода
+func silentLogger(t *testing.T) context.Context {
+	cfg := &config.Config{}
+	cfg.Logger.Config = zap.NewProductionConfig()
+	cfg.Logger.OutputPaths = nil
+	cfg.Logger.ErrorOutputPaths = nil
+	ctx, err := logger.New(context.Background(), cfg)
+	require.NoError(t, err)
+	return ctx
+}
+
+func TestCachedRepository(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("WriteBehind", func(t *testing.T) {
+		store := newFakeStore(models.Bucket{ClientID: "a", Capacity: 5, Tokens: 5, LastRefill: time.Now()})
+		cache := NewCachedRepository(store, cacheConfig(config.CacheConfig{FlushInterval: config.Duration(time.Hour)}))
+
+		for i := 0; i < 3; i++ {
+			res, err := cache.TryConsume(ctx, "a", 1)
+			require.NoError(t, err)
+			require.Equal(t, 4-i, res.Tokens)
+		}
+		// бакет прочитан один раз, в хранилище ещё ничего не записано
+		require.Equal(t, 1, store.gets)
+		require.Equal(t, 5, store.tokens("a"))
+
+		// новый клиент создаётся полным и тоже ждёт сброса
+		res, err := cache.TryConsume(ctx, "b", 1)
+		require.NoError(t, err)
+		require.Equal(t, 9, res.Tokens)
+
+		require.NoError(t, cache.Flush(ctx))
+		require.Len(t, store.spends, 1)
+		require.Len(t, store.spends[0], 2)
+		require.Equal(t, 2, store.tokens("a"))
+		require.Equal(t, 9, store.tokens("b"))
+
+		// без изменений сбрасывать нечего
+		require.NoError(t, cache.Flush(ctx))
+		require.Len(t, store.spends, 1)
+	})
+
+	t.Run("FlushBatches", func(t *testing.T) {
+		store := newFakeStore()
+		cache := NewCachedRepository(store, cacheConfig(config.CacheConfig{
+			FlushInterval: config.Duration(time.Hour),
+			FlushBatch:    2,
+		}))
+		for _, id := range []string{"a", "b", "c", "d", "e"} {
+			_, err := cache.TryConsume(ctx, id, 1)
+			require.NoError(t, err)
+		}
+		require.NoError(t, cache.Flush(ctx))
+		require.Len(t, store.spends, 3)
+	})
+
+	t.Run("WriteThrough", func(t *testing.T) {
+		store := newFakeStore()
+		cache := NewCachedRepository(store, cacheConfig(config.CacheConfig{}))
+
+		_, err := cache.TryConsume(ctx, "a", 1)
+		require.NoError(t, err)
+		_, err = cache.TryConsume(ctx, "a", 1)
+		require.NoError(t, err)
+		require.Len(t, store.spends, 2)
+		require.Equal(t, 8, store.tokens("a"))
+	})
+
+	t.Run("NoOverConsume", func(t *testing.T) {
+		store := newFakeStore(models.Bucket{ClientID: "a", Capacity: 50, Tokens: 50, LastRefill: time.Now()})
+		cache := NewCachedRepository(store, cacheConfig(config.CacheConfig{FlushInterval: config.Duration(time.Hour)}))
+
+		var allowed atomic.Int64
+		var wg sync.WaitGroup
+		for w := 0; w < 20; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 10; i++ {
+					if _, err := cache.TryConsume(ctx, "a", 1); err == nil {
+						allowed.Add(1)
+					} else {
+						require.ErrorIs(t, err, errdefs.NotEnoughTokens)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		require.EqualValues(t, 50, allowed.Load())
+	})
+
+	t.Run("ConsumeAllAtomic", func(t *testing.T) {
+		store := newFakeStore(
+			models.Bucket{ClientID: "a", Capacity: 30, Tokens: 30, LastRefill: time.Now()},
+			models.Bucket{ClientID: "b", Capacity: 50, Tokens: 50, LastRefill: time.Now()},
+		)
+		cache := NewCachedRepository(store, cacheConfig(config.CacheConfig{FlushInterval: config.Duration(time.Hour)}))
+
+		// половина горутин перечисляет бакеты в обратном порядке
+		ab := []models.ConsumeRequest{{ClientID: "a", Cost: 1, Capacity: 30}, {ClientID: "b", Cost: 1, Capacity: 50}}
+		ba := []models.ConsumeRequest{ab[1], ab[0]}
+
+		var allowed atomic.Int64
+		var wg sync.WaitGroup
+		for w := 0; w < 20; w++ {
+			reqs := ab
+			if w%2 == 1 {
+				reqs = ba
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 5; i++ {
+					if _, err := cache.TryConsumeAll(ctx, reqs); err == nil {
+						allowed.Add(1)
+					} else {
+						require.ErrorIs(t, err, errdefs.NotEnoughTokens)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		require.EqualValues(t, 30, allowed.Load())
+
+		b, err := cache.GetBucket(ctx, "b")
+		require.NoError(t, err)
+		require.Equal(t, 20, b.Tokens, "с b списано только вместе с a")
+	})
+
+	t.Run("ReplicasShareSpends", func(t *testing.T) {
+		store := newFakeStore(models.Bucket{ClientID: "a", Capacity: 10, Tokens: 10, LastRefill: time.Now()})
+		cfg := cacheConfig(config.CacheConfig{FlushInterval: config.Duration(time.Hour)})
+		r1 := NewCachedRepository(store, cfg)
+		r2 := NewCachedRepository(store, cfg)
+
+		for i := 0; i < 4; i++ {
+			_, err := r1.TryConsume(ctx, "a", 1)
+			require.NoError(t, err)
+			_, err = r2.TryConsume(ctx, "a", 1)
+			require.NoError(t, err)
+		}
+		// в хранилище уходит списанное, а не остаток копии
+		require.NoError(t, r1.Flush(ctx))
+		require.NoError(t, r2.Flush(ctx))
+		require.Equal(t, 2, store.tokens("a"))
+
+		// после сброса реплика видит списания тех, кто сбросил раньше
+		got, err := r1.GetBucket(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, 6, got.Tokens, "r2 сбросил после r1")
+		_, err = r2.TryConsume(ctx, "a", 1)
+		require.NoError(t, err)
+		res, err := r2.TryConsume(ctx, "a", 1)
+		require.NoError(t, err)
+		require.Equal(t, 0, res.Tokens)
+	})
+
+	t.Run("AdminWriteInvalidates", func(t *testing.T) {
+		store := newFakeStore(models.Bucket{ClientID: "a", Capacity: 5, Tokens: 5, LastRefill: time.Now()})
+		cache := NewCachedRepository(store, cacheConfig(config.CacheConfig{FlushInterval: config.Duration(time.Hour)}))
+
+		_, err := cache.TryConsume(ctx, "a", 1)
+		require.NoError(t, err)
+		require.NoError(t, cache.UpdateCountTokens(ctx, "a", 1))
+
+		// несброшенное списание не перезаписывает значение из API
+		require.NoError(t, cache.Flush(ctx))
+		require.Empty(t, store.spends)
+		got, err := cache.GetBucket(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, 1, got.Tokens)
+
+		_, err = cache.TryConsume(ctx, "a", 1)
+		require.NoError(t, err)
+		_, err = cache.TryConsume(ctx, "a", 1)
+		require.ErrorIs(t, err, errdefs.NotEnoughTokens)
+
+		require.NoError(t, cache.RemoveBucket(ctx, "a"))
+		_, err = cache.GetBucket(ctx, "a")
+		require.ErrorIs(t, err, errdefs.ErrNotFound)
+	})
+
+	t.Run("AdminWriteKeepsSpends", func(t *testing.T) {
+		store := newFakeStore(models.Bucket{ClientID: "a", Capacity: 5, Tokens: 5, LastRefill: time.Now()})
+		cache := NewCachedRepository(store, cacheConfig(config.CacheConfig{FlushInterval: config.Duration(time.Hour)}))
+
+		for i := 0; i < 3; i++ {
+			_, err := cache.TryConsume(ctx, "a", 1)
+			require.NoError(t, err)
+		}
+		require.NoError(t, cache.UpdateCapacity(ctx, "a", 6))
+
+		// бакет перечитан с новой ёмкостью, но выданные токены не вернулись
+		got, err := cache.GetBucket(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, 6, got.Capacity)
+		require.Equal(t, 2, got.Tokens)
+		res, err := cache.TryConsume(ctx, "a", 1)
+		require.NoError(t, err)
+		require.Equal(t, 1, res.Tokens)
+
+		require.NoError(t, cache.Flush(ctx))
+		require.Equal(t, 1, store.tokens("a"))
+		require.Len(t, store.spends, 1)
+		require.Equal(t, 4, store.spends[0][0].Cost)
+
+		// списания выкинутого и ещё не перечитанного бакета тоже доходят до хранилища
+		_, err = cache.TryConsume(ctx, "a", 1)
+		require.NoError(t, err)
+		cache.Invalidate("a")
+		require.NoError(t, cache.Flush(ctx))
+		require.Equal(t, 0, store.tokens("a"))
+	})
+
+	t.Run("LRUEviction", func(t *testing.T) {
+		store := newFakeStore()
+		cache := NewCachedRepository(store, cacheConfig(config.CacheConfig{
+			Size:          2,
+			Shards:        1,
+			FlushInterval: config.Duration(time.Hour),
+		}))
+
+		for _, id := range []string{"a", "b", "c"} {
+			_, err := cache.TryConsume(ctx, id, 1)
+			require.NoError(t, err)
+		}
+		require.Equal(t, 2, cache.shards[0].lru.Len())
+
+		// вытесненный бакет не потерян: он виден и вернётся в кеш с тем же состоянием
+		got, err := cache.GetBucket(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, 9, got.Tokens)
+		res, err := cache.TryConsume(ctx, "a", 1)
+		require.NoError(t, err)
+		require.Equal(t, 8, res.Tokens)
+
+		require.NoError(t, cache.Flush(ctx))
+		require.Equal(t, 8, store.tokens("a"))
+		require.Equal(t, 9, store.tokens("b"))
+		require.Equal(t, 9, store.tokens("c"))
+	})
+
+	t.Run("ConsumeAllOverShardCapacity", func(t *testing.T) {
+		store := newFakeStore()
+		cache := NewCachedRepository(store, cacheConfig(config.CacheConfig{
+			Size:          1,
+			Shards:        1,
+			FlushInterval: config.Duration(time.Hour),
+		}))
+
+		// бакетов запроса больше, чем помещается в шард: списание не должно
+		// зацикливаться на вытеснении и не должно терять состояние вытесненных
+		reqs := []models.ConsumeRequest{
+			{ClientID: "client", Cost: 1, Capacity: 10},
+			{ClientID: "policy:search:client", Cost: 1, Capacity: 3},
+		}
+		for i := 0; i < 3; i++ {
+			res, err := cache.TryConsumeAll(ctx, reqs)
+			require.NoError(t, err)
+			require.Equal(t, 2-i, res.Tokens)
+		}
+		_, err := cache.TryConsumeAll(ctx, reqs)
+		require.ErrorIs(t, err, errdefs.NotEnoughTokens)
+		require.Equal(t, 1, cache.shards[0].lru.Len())
+		require.Equal(t, 2, store.gets, "каждый бакет читается из хранилища один раз")
+
+		require.NoError(t, cache.Flush(ctx))
+		require.Equal(t, 7, store.tokens("client"))
+		require.Equal(t, 0, store.tokens("policy:search:client"))
+	})
+
+	t.Run("FlushErrorRetries", func(t *testing.T) {
+		store := newFakeStore()
+		cache := NewCachedRepository(store, cacheConfig(config.CacheConfig{FlushInterval: config.Duration(time.Hour)}))
+
+		_, err := cache.TryConsume(ctx, "a", 1)
+		require.NoError(t, err)
+
+		store.saveErr = errors.New("connection reset")
+		require.Error(t, cache.Flush(ctx))
+		store.saveErr = nil
+		require.NoError(t, cache.Flush(ctx))
+		require.Equal(t, 9, store.tokens("a"))
+	})
+
+	t.Run("InvalidateAll", func(t *testing.T) {
+		store := newFakeStore(
+			models.Bucket{ClientID: "a", Capacity: 5, Tokens: 5, LastRefill: time.Now()},
+			models.Bucket{ClientID: "b", Capacity: 5, Tokens: 5, LastRefill: time.Now()},
+		)
+		cache := NewCachedRepository(store, cacheConfig(config.CacheConfig{FlushInterval: config.Duration(time.Hour)}))
+
+		_, err := cache.TryConsume(ctx, "a", 1)
+		require.NoError(t, err)
+		_, err = cache.TryConsume(ctx, "b", 1)
+		require.NoError(t, err)
+
+		// пока бд недоступна, несохранённые списания остаются в кеше
+		store.saveErr = errors.New("connection reset")
+		require.Error(t, cache.InvalidateAll(ctx))
+		got, err := cache.GetBucket(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, 4, got.Tokens)
+
+		// списания сохраняются, а бакеты перечитываются из хранилища
+		store.saveErr = nil
+		require.NoError(t, cache.InvalidateAll(ctx))
+		require.Equal(t, 4, store.tokens("a"))
+		require.NoError(t, store.UpdateCountTokens(ctx, "b", 1))
+		res, err := cache.TryConsume(ctx, "b", 1)
+		require.NoError(t, err)
+		require.Equal(t, 0, res.Tokens)
+	})
+
+	t.Run("FinalFlushOnStop", func(t *testing.T) {
+		store := newFakeStore()
+		cache := NewCachedRepository(store, cacheConfig(config.CacheConfig{FlushInterval: config.Duration(time.Hour)}))
+
+		runCtx, cancel := context.WithCancel(silentLogger(t))
+		done := cache.Start(runCtx)
+		_, err := cache.TryConsume(ctx, "a", 1)
+		require.NoError(t, err)
+
+		cancel()
+		<-done
+		require.Equal(t, 9, store.tokens("a"))
+	})
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"time"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/models"
+)
+
+// consume - то же, что делает функция consume_tokens в Postgres, но над
+// бакетом в памяти: пополняет его по числу целых интервалов с LastRefill
+// (LastRefill сдвигается ровно на них, остаток не теряется) и списывает cost
+// токенов, если их хватает. Полный бакет не копит прогресс пополнения
+func consume(b *models.Bucket, cost, amount int, interval time.Duration, now time.Time) models.ConsumeResult {
+	refill(b, amount, interval, now)
+	refills := amount > 0 && interval > 0
+
+	res := models.ConsumeResult{Capacity: b.Capacity}
+	if b.Tokens >= cost {
+		b.Tokens -= cost
+		res.Allowed = true
+	}
+	res.Tokens = b.Tokens
+	if refills && b.Tokens < b.Capacity {
+		res.NextToken = max(0, b.LastRefill.Add(interval).Sub(now))
+		res.Reset = refillWait(b.Capacity-b.Tokens, amount, res.NextToken, interval)
+		if !res.Allowed {
+			res.RetryAfter = refillWait(min(cost, b.Capacity)-b.Tokens, amount, res.NextToken, interval)
+		}
+	}
+	return res
+}
+
+// refill пополняет бакет по числу целых интервалов с LastRefill
+func refill(b *models.Bucket, amount int, interval time.Duration, now time.Time) {
+	if amount > 0 && interval > 0 {
+		if steps := now.Sub(b.LastRefill) / interval; steps > 0 {
+			b.Tokens = int(min(int64(b.Capacity), int64(b.Tokens)+int64(steps)*int64(amount)))
+			b.LastRefill = b.LastRefill.Add(steps * interval)
+		}
+	}
+	if b.Tokens >= b.Capacity {
+		b.LastRefill = now
+	}
+}
+
+// spend - то же, что функция spend_tokens в Postgres: пополняет бакет и
+// снимает с него spent токенов, уже выданных кешем, но не ниже нуля
+func spend(b *models.Bucket, spent, amount int, interval time.Duration, now time.Time) {
+	refill(b, amount, interval, now)
+	b.Tokens = max(0, b.Tokens-spent)
+}
+
+// refillWait - через сколько добавится missing токенов, если первые amount
+// придут через next, а дальше по amount раз в interval
+func refillWait(missing, amount int, next, interval time.Duration) time.Duration {
+	if missing <= 0 {
+		return 0
+	}
+	steps := (missing + amount - 1) / amount
+	return next + time.Duration(steps-1)*interval
+}
+
+// defaultRequest - списание TryConsume: новый бакет и пополнение по
+// bucket.capacity и bucket.refill
+func defaultRequest(cfg *config.Config, clientID string, cost int) models.ConsumeRequest {
+	return models.ConsumeRequest{
+		ClientID:       clientID,
+		Cost:           cost,
+		Capacity:       cfg.Bucket.Capacity,
+		RefillAmount:   cfg.Bucket.Refill.Amount,
+		RefillInterval: time.Duration(cfg.Bucket.Refill.Interval),
+	}
+}
+
+// newBucket - полный бакет, который создаётся при первом списании
+func newBucket(req models.ConsumeRequest, now time.Time) models.Bucket {
+	return models.Bucket{
+		ClientID:   req.ClientID,
+		Capacity:   req.Capacity,
+		Tokens:     req.Capacity,
+		LastRefill: now,
+	}
+}
+
+// consumeAll списывает со всех бакетов или ни с одного. buckets меняются на
+// месте, save[i] - нужно ли сохранить бакет: при отказе сохраняются только
+// бакеты, которым не хватило токенов, с них и так ничего не списано, а
+// пополнение и созданный бакет не теряются, как и в TryConsume
+func consumeAll(buckets []models.Bucket, reqs []models.ConsumeRequest, now time.Time) (res models.ConsumeResult, save []bool) {
+	results := make([]models.ConsumeResult, len(reqs))
+	for i, req := range reqs {
+		b := &buckets[i]
+		amount, interval := b.Refill(req.RefillAmount, req.RefillInterval)
+		results[i] = consume(b, b.CostOf(req.Cost), amount, interval, now)
+	}
+	res = tightest(results)
+	save = make([]bool, len(reqs))
+	for i := range results {
+		save[i] = res.Allowed || !results[i].Allowed
+	}
+	return res, save
+}
+
+// tightest - ответ TryConsumeAll по ответам отдельных бакетов: при отказе -
+// бакет, которого ждать дольше всего, иначе - с наименьшим остатком
+func tightest(results []models.ConsumeResult) models.ConsumeResult {
+	res := results[0]
+	for _, r := range results[1:] {
+		switch {
+		case r.Allowed != res.Allowed:
+			if !r.Allowed {
+				res = r
+			}
+		case !r.Allowed:
+			if r.RetryAfter > res.RetryAfter {
+				res = r
+			}
+		case r.Tokens < res.Tokens || r.Tokens == res.Tokens && r.Reset > res.Reset:
+			res = r
+		}
+	}
+	return res
+}
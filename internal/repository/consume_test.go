@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/internal/models"
+)
+
+func TestConsume(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	interval := time.Minute
+
+	t.Run("LazyRefillKeepsRemainder", func(t *testing.T) {
+		b := &models.Bucket{Capacity: 5, Tokens: 0, LastRefill: now.Add(-150 * time.Second)}
+		res := consume(b, 1, 1, interval, now)
+
+		require.True(t, res.Allowed)
+		require.Equal(t, 1, res.Tokens)
+		require.Equal(t, now.Add(-30*time.Second), b.LastRefill)
+		require.Equal(t, 30*time.Second, res.NextToken)
+		require.Equal(t, 30*time.Second+3*interval, res.Reset)
+		require.Zero(t, res.RetryAfter)
+	})
+
+	t.Run("FullBucketResetsClock", func(t *testing.T) {
+		b := &models.Bucket{Capacity: 3, Tokens: 2, LastRefill: now.Add(-time.Hour)}
+		res := consume(b, 1, 1, interval, now)
+
+		require.True(t, res.Allowed)
+		require.Equal(t, 2, res.Tokens)
+		require.Equal(t, now, b.LastRefill)
+		require.Equal(t, interval, res.NextToken)
+	})
+
+	t.Run("NotEnoughTokens", func(t *testing.T) {
+		b := &models.Bucket{Capacity: 3, Tokens: 0, LastRefill: now.Add(-10 * time.Second)}
+		res := consume(b, 1, 1, interval, now)
+
+		require.False(t, res.Allowed)
+		require.Equal(t, 0, b.Tokens)
+		require.Equal(t, 50*time.Second, res.NextToken)
+		require.Equal(t, 50*time.Second, res.RetryAfter)
+		require.Equal(t, 50*time.Second+2*interval, res.Reset)
+
+		// по 2 токена за интервал: 5 из 3 возможных ждут полного бакета
+		res = consume(b, 5, 2, interval, now)
+		require.False(t, res.Allowed)
+		require.Equal(t, 50*time.Second+interval, res.RetryAfter)
+		require.Equal(t, res.Reset, res.RetryAfter)
+	})
+
+	t.Run("RefillDisabled", func(t *testing.T) {
+		b := &models.Bucket{Capacity: 3, Tokens: 1, LastRefill: now.Add(-time.Hour)}
+		res := consume(b, 1, 0, interval, now)
+
+		require.True(t, res.Allowed)
+		require.Equal(t, 0, res.Tokens)
+		require.Zero(t, res.NextToken)
+		require.Zero(t, res.Reset)
+	})
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/limiter"
+	"gopher-equalizer/internal/models"
+)
+
+// limiterState - *S, который умеет учитывать запрос
+type limiterState[S any] interface {
+	*S
+	limiter.State
+}
+
+// stateTable - таблица алгоритма с одной строкой на клиента. Колонки
+// состояния - целые числа, время в микросекундах, поэтому таблица
+// читается и пишется одинаково в Postgres и SQLite
+type stateTable[S any] struct {
+	name    string
+	columns []string // без client_id
+	encode  func(s *S) []int64
+	decode  func(v []int64) S
+}
+
+var (
+	fixedWindowTable = stateTable[limiter.FixedWindow]{
+		name:    "fixed_windows",
+		columns: []string{"window_start", "used"},
+		encode: func(s *limiter.FixedWindow) []int64 {
+			return []int64{s.Start.UnixMicro(), int64(s.Used)}
+		},
+		decode: func(v []int64) limiter.FixedWindow {
+			return limiter.FixedWindow{Start: time.UnixMicro(v[0]), Used: int(v[1])}
+		},
+	}
+	slidingWindowTable = stateTable[limiter.SlidingWindow]{
+		name:    "sliding_windows",
+		columns: []string{"window_start", "used", "prev_used"},
+		encode: func(s *limiter.SlidingWindow) []int64 {
+			return []int64{s.Start.UnixMicro(), int64(s.Used), int64(s.PrevUsed)}
+		},
+		decode: func(v []int64) limiter.SlidingWindow {
+			return limiter.SlidingWindow{Start: time.UnixMicro(v[0]), Used: int(v[1]), PrevUsed: int(v[2])}
+		},
+	}
+	gcraTable = stateTable[limiter.GCRA]{
+		name:    "gcra_states",
+		columns: []string{"tat"},
+		encode:  func(s *limiter.GCRA) []int64 { return []int64{s.TAT.UnixMicro()} },
+		decode:  func(v []int64) limiter.GCRA { return limiter.GCRA{TAT: time.UnixMicro(v[0])} },
+	}
+	leakyBucketTable = stateTable[limiter.LeakyBucket]{
+		name:    "leaky_buckets",
+		columns: []string{"drain_at"},
+		encode:  func(s *limiter.LeakyBucket) []int64 { return []int64{s.DrainAt.UnixMicro()} },
+		decode:  func(v []int64) limiter.LeakyBucket { return limiter.LeakyBucket{DrainAt: time.UnixMicro(v[0])} },
+	}
+)
+
+// queries собирает чтение и upsert состояния, placeholder(i) - i-й параметр запроса
+func (t stateTable[S]) queries(placeholder func(i int) string) (selectQuery, upsertQuery string) {
+	selectQuery = fmt.Sprintf("SELECT %s FROM %s WHERE client_id = %s",
+		strings.Join(t.columns, ", "), t.name, placeholder(1))
+
+	params := []string{placeholder(1)}
+	sets := make([]string, 0, len(t.columns))
+	for i, col := range t.columns {
+		params = append(params, placeholder(i+2))
+		sets = append(sets, col+" = excluded."+col)
+	}
+	upsertQuery = fmt.Sprintf("INSERT INTO %s (client_id, %s) VALUES (%s) ON CONFLICT (client_id) DO UPDATE SET %s",
+		t.name, strings.Join(t.columns, ", "), strings.Join(params, ", "), strings.Join(sets, ", "))
+	return selectQuery, upsertQuery
+}
+
+// scanDest - куда сканировать колонки состояния
+func (t stateTable[S]) scanDest() ([]int64, []any) {
+	values := make([]int64, len(t.columns))
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	return values, dest
+}
+
+// upsertArgs - параметры upsert: client_id и колонки состояния
+func (t stateTable[S]) upsertArgs(clientID string, s *S) []any {
+	args := []any{clientID}
+	for _, v := range t.encode(s) {
+		args = append(args, v)
+	}
+	return args
+}
+
+// consumed - ответ TryConsume по результату алгоритма
+func consumed(res models.ConsumeResult) (*models.ConsumeResult, error) {
+	if !res.Allowed {
+		return &res, errdefs.NotEnoughTokens
+	}
+	return &res, nil
+}
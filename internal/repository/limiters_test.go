@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/interfaces"
+)
+
+// limiterDrivers - реализации алгоритмов на каждом хранилище, new
+// возвращает их над пустым хранилищем
+var limiterDrivers = []struct {
+	name string
+	new  func(t *testing.T, cfg *config.Config) map[string]interfaces.ILimiter
+}{
+	{config.StoragePostgres, func(t *testing.T, cfg *config.Config) map[string]interfaces.ILimiter {
+		if db == nil {
+			t.Skip("Postgres is unavailable")
+		}
+		_, err := db.Exec(context.Background(),
+			"TRUNCATE TABLE fixed_windows, sliding_windows, sliding_log, gcra_states, leaky_buckets")
+		require.NoError(t, err)
+		return NewPostgresLimiters(db, cfg)
+	}},
+	{config.StorageMemory, func(t *testing.T, cfg *config.Config) map[string]interfaces.ILimiter {
+		return NewMemoryLimiters(cfg)
+	}},
+	{config.StorageSQLite, func(t *testing.T, cfg *config.Config) map[string]interfaces.ILimiter {
+		return NewSQLiteLimiters(newSQLite(t), cfg)
+	}},
+}
+
+func TestLimiters(t *testing.T) {
+	// 5 токенов за год, пополнение раз в минуту: за время теста ничего
+	// не освобождается ни в одном алгоритме, а граница выровненного окна
+	// попадает на тест с ничтожной вероятностью
+	lcfg := *cfg
+	lcfg.Bucket.Capacity = 5
+	lcfg.Bucket.Refill = config.RefillConfig{Amount: 1, Interval: config.Duration(time.Minute)}
+	lcfg.Bucket.Window = config.Duration(365 * 24 * time.Hour)
+	ctx := context.Background()
+
+	for _, driver := range limiterDrivers {
+		t.Run(driver.name, func(t *testing.T) {
+			for _, algorithm := range config.Algorithms {
+				if algorithm == config.AlgorithmTokenBucket {
+					continue // это IBucketRepository, его проверяет TestBucketRepository
+				}
+				t.Run(algorithm, func(t *testing.T) {
+					t.Run("Cost", func(t *testing.T) {
+						l := driver.new(t, &lcfg)[algorithm]
+
+						res, err := l.TryConsume(ctx, "heavy", 3)
+						require.NoError(t, err)
+						require.Equal(t, 2, res.Tokens)
+						require.Equal(t, 5, res.Capacity)
+
+						// отказ ничего не учитывает
+						res, err = l.TryConsume(ctx, "heavy", 3)
+						require.ErrorIs(t, err, errdefs.NotEnoughTokens)
+						require.Equal(t, 2, res.Tokens)
+						require.Positive(t, res.NextToken)
+
+						_, err = l.TryConsume(ctx, "heavy", 2)
+						require.NoError(t, err)
+						_, err = l.TryConsume(ctx, "heavy", 1)
+						require.ErrorIs(t, err, errdefs.NotEnoughTokens)
+
+						_, err = l.TryConsume(ctx, "light", 1)
+						require.NoError(t, err, "clients are limited separately")
+					})
+
+					t.Run("Parallel", func(t *testing.T) {
+						l := driver.new(t, &lcfg)[algorithm]
+
+						workers, perWorker := 10, 5
+						var allowed, denied atomic.Int64
+						var wg sync.WaitGroup
+						for w := 0; w < workers; w++ {
+							wg.Add(1)
+							go func() {
+								defer wg.Done()
+								for i := 0; i < perWorker; i++ {
+									_, err := l.TryConsume(ctx, "parallel-client", 1)
+									switch {
+									case err == nil:
+										allowed.Add(1)
+									case errdefs.Is(err, errdefs.NotEnoughTokens):
+										denied.Add(1)
+									default:
+										t.Errorf("unexpected error: %v", err)
+									}
+								}
+							}()
+						}
+						wg.Wait()
+
+						require.EqualValues(t, 5, allowed.Load(), "пропущено больше лимита")
+						require.EqualValues(t, workers*perWorker-5, denied.Load())
+					})
+				})
+			}
+		})
+	}
+}
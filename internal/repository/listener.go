@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/interfaces"
+	"gopher-equalizer/internal/logger"
+	"gopher-equalizer/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// BucketListener слушает канал изменений бакетов и выкидывает изменённые
+// бакеты из локального кеша. Соединение берётся из пула и живёт отдельно от
+// него, пока идёт LISTEN; при разрыве слушатель переподключается с
+// экспоненциальной паузой. Уведомления о своих же записях пропускаются:
+// кеш уже выкинул бакет при записи, а повторная инвалидация после
+// UpdateCountTokens отбросила бы списания, сделанные уже после неё
+type BucketListener struct {
+	db         *pgxpool.Pool
+	target     interfaces.IBucketInvalidator
+	channel    string
+	origin     string
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	// connected вызывается после каждого успешного LISTEN, нужен тестам
+	connected func()
+}
+
+// NewReplicaID - случайный идентификатор реплики для BucketEvent.Origin
+func NewReplicaID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewBucketListener создаёт слушателя для реплики origin, с которым она
+// публикует свои изменения (см. BucketRepository.WithNotifications)
+func NewBucketListener(db *pgxpool.Pool, cfg *config.Config, origin string, target interfaces.IBucketInvalidator) *BucketListener {
+	nc := cfg.Bucket.Notify
+	l := &BucketListener{
+		db:         db,
+		target:     target,
+		channel:    nc.ChannelName(),
+		origin:     origin,
+		minBackoff: time.Duration(nc.MinBackoff),
+		maxBackoff: time.Duration(nc.MaxBackoff),
+		connected:  func() {},
+	}
+	if l.minBackoff <= 0 {
+		l.minBackoff = defaultMinBackoff
+	}
+	if l.maxBackoff < l.minBackoff {
+		l.maxBackoff = max(defaultMaxBackoff, l.minBackoff)
+	}
+	return l
+}
+
+// Start слушает канал, пока не отменён ctx. Done закрывается после остановки
+func (l *BucketListener) Start(ctx context.Context) (done <-chan struct{}) {
+	ch := make(chan struct{})
+	log := logger.GetLoggerFromCtx(ctx)
+
+	go func() {
+		defer close(ch)
+		backoff := l.minBackoff
+		for {
+			listened, err := l.listen(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if listened {
+				// соединение успело поработать, начинаем паузы заново
+				backoff = l.minBackoff
+			}
+			log.Error(ctx, "NOTIFY: listener disconnected",
+				zap.String("channel", l.channel),
+				zap.Duration("retry_in", backoff),
+				zap.Error(err),
+			)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, l.maxBackoff)
+		}
+	}()
+	return ch
+}
+
+// listen подписывается на канал и применяет уведомления до первой ошибки.
+// listened - удалось ли подписаться
+func (l *BucketListener) listen(ctx context.Context) (listened bool, err error) {
+	log := logger.GetLoggerFromCtx(ctx)
+
+	pooled, err := l.db.Acquire(ctx)
+	if err != nil {
+		return false, errdefs.Wrapf(errdefs.ErrDB, "failed to acquire connection: %v", err)
+	}
+	// LISTEN привязан к соединению, в пул его возвращать нельзя
+	conn := pooled.Hijack()
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{l.channel}.Sanitize()); err != nil {
+		return false, errdefs.Wrapf(errdefs.ErrDB, "failed to listen %s: %v", l.channel, err)
+	}
+	// пока слушателя не было, уведомления могли потеряться
+	if err := l.target.InvalidateAll(ctx); err != nil {
+		log.Error(ctx, "NOTIFY: failed to invalidate cache", zap.Error(err))
+	}
+	log.Info(ctx, "NOTIFY: listening", zap.String("channel", l.channel))
+	l.connected()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, errdefs.Wrapf(errdefs.ErrDB, "failed to wait for notification: %v", err)
+		}
+		var event models.BucketEvent
+		if err := json.Unmarshal([]byte(n.Payload), &event); err != nil || event.ClientID == "" {
+			log.Error(ctx, "NOTIFY: bad payload", zap.String("payload", n.Payload))
+			continue
+		}
+		l.apply(event)
+	}
+}
+
+// apply выкидывает из кеша бакет, изменённый другой репликой
+func (l *BucketListener) apply(event models.BucketEvent) {
+	if event.Origin != "" && event.Origin == l.origin {
+		return
+	}
+	switch event.Op {
+	case models.BucketTokensUpdated, models.BucketRemoved:
+		// остаток задан заново, списания до записи уже ничего не значат
+		l.target.Reset(event.ClientID)
+	default:
+		l.target.Invalidate(event.ClientID)
+	}
+}
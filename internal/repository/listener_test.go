@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/models"
+)
+
+// fakeInvalidator запоминает, какие бакеты выкидывали из кеша
+type fakeInvalidator struct {
+	mu          sync.Mutex
+	invalidated []string
+	all         int
+}
+
+func (f *fakeInvalidator) Invalidate(clientID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.invalidated = append(f.invalidated, clientID)
+}
+
+// Reset записывается как "!clientID", чтобы отличать его от Invalidate
+func (f *fakeInvalidator) Reset(clientID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.invalidated = append(f.invalidated, "!"+clientID)
+}
+
+func (f *fakeInvalidator) InvalidateAll(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.all++
+	return nil
+}
+
+func (f *fakeInvalidator) snapshot() ([]string, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.invalidated...), f.all
+}
+
+func TestBucketListenerApply(t *testing.T) {
+	target := &fakeInvalidator{}
+	listener := NewBucketListener(nil, cacheConfig(config.CacheConfig{}), "replica-1", target)
+
+	listener.apply(models.BucketEvent{Op: models.BucketCapacityUpdated, ClientID: "a", Origin: "replica-2"})
+	listener.apply(models.BucketEvent{Op: models.BucketTokensUpdated, ClientID: "b", Origin: "replica-2"})
+	listener.apply(models.BucketEvent{Op: models.BucketRemoved, ClientID: "c"})
+	// своя запись уже выкинула бакет из кеша
+	listener.apply(models.BucketEvent{Op: models.BucketTokensUpdated, ClientID: "d", Origin: "replica-1"})
+
+	got, _ := target.snapshot()
+	require.Equal(t, []string{"a", "!b", "!c"}, got)
+}
+
+func TestBucketListener(t *testing.T) {
+	clearTable(t)
+
+	lcfg := *cfg
+	lcfg.Bucket.Notify = config.NotifyConfig{
+		Channel:    "gopher_equalizer_test",
+		MinBackoff: config.Duration(10 * time.Millisecond),
+		MaxBackoff: config.Duration(50 * time.Millisecond),
+	}
+	target := &fakeInvalidator{}
+	listener := NewBucketListener(db, &lcfg, "replica-1", target)
+	connected := make(chan struct{}, 10)
+	listener.connected = func() { connected <- struct{}{} }
+
+	ctx, cancel := context.WithCancel(silentLogger(t))
+	done := listener.Start(ctx)
+	defer func() {
+		cancel()
+		<-done
+	}()
+	<-connected
+
+	repo := NewBucketRepository(db, &lcfg).WithNotifications(lcfg.Bucket.Notify.ChannelName(), "replica-2")
+	waitFor := func(want []string) {
+		require.Eventually(t, func() bool {
+			got, _ := target.snapshot()
+			return len(got) == len(want)
+		}, 2*time.Second, 10*time.Millisecond)
+		got, _ := target.snapshot()
+		require.Equal(t, want, got)
+	}
+
+	t.Run("PublishesChanges", func(t *testing.T) {
+		require.NoError(t, repo.CreateBucket(ctx, &models.Bucket{ClientID: "n1", Capacity: 5, Tokens: 5, LastRefill: time.Now()}))
+		require.NoError(t, repo.UpdateCapacity(ctx, "n1", 10))
+		require.NoError(t, repo.UpdateCountTokens(ctx, "n1", 7))
+		require.NoError(t, repo.RemoveBucket(ctx, "n1"))
+		// после записи остатка и удаления несброшенные списания не нужны
+		waitFor([]string{"n1", "n1", "!n1", "!n1"})
+
+		// неудачная запись не публикуется
+		require.ErrorIs(t, repo.UpdateCapacity(ctx, "missing", 10), errdefs.ErrNotFound)
+		require.NoError(t, repo.CreateBucket(ctx, &models.Bucket{ClientID: "n2", Capacity: 5, Tokens: 5, LastRefill: time.Now()}))
+		waitFor([]string{"n1", "n1", "!n1", "!n1", "n2"})
+	})
+
+	t.Run("SkipsOwnEvents", func(t *testing.T) {
+		own := NewBucketRepository(db, &lcfg).WithNotifications(lcfg.Bucket.Notify.ChannelName(), "replica-1")
+		require.NoError(t, own.UpdateCapacity(ctx, "n2", 9))
+		require.NoError(t, repo.UpdateCapacity(ctx, "n2", 7))
+		// уведомления приходят по порядку коммитов, своё уже было бы в списке
+		waitFor([]string{"n1", "n1", "!n1", "!n1", "n2", "n2"})
+	})
+
+	t.Run("Reconnects", func(t *testing.T) {
+		_, before := target.snapshot()
+
+		// обрываем соединение слушателя со стороны сервера
+		_, err := db.Exec(ctx, `
+			SELECT pg_terminate_backend(pid) FROM pg_stat_activity
+			WHERE query LIKE 'LISTEN%' AND pid <> pg_backend_pid()
+		`)
+		require.NoError(t, err)
+
+		select {
+		case <-connected:
+		case <-time.After(2 * time.Second):
+			t.Fatal("listener did not reconnect")
+		}
+		// пропущенные за время разрыва изменения сбрасывают весь кеш
+		_, after := target.snapshot()
+		require.Equal(t, before+1, after)
+
+		require.NoError(t, repo.UpdateCapacity(ctx, "n2", 8))
+		waitFor([]string{"n1", "n1", "!n1", "!n1", "n2", "n2", "n2"})
+	})
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/interfaces"
+	"gopher-equalizer/internal/limiter"
+	"gopher-equalizer/internal/models"
+)
+
+// NewMemoryLimiters - алгоритмы кроме token_bucket над состоянием в памяти
+// процесса, для storage.driver: memory
+func NewMemoryLimiters(cfg *config.Config) map[string]interfaces.ILimiter {
+	p := limiter.NewParams(cfg.Bucket)
+	return map[string]interfaces.ILimiter{
+		config.AlgorithmFixedWindow:   newMemoryLimiter[limiter.FixedWindow](p),
+		config.AlgorithmSlidingLog:    newMemoryLimiter[limiter.SlidingLog](p),
+		config.AlgorithmSlidingWindow: newMemoryLimiter[limiter.SlidingWindow](p),
+		config.AlgorithmGCRA:          newMemoryLimiter[limiter.GCRA](p),
+		config.AlgorithmLeakyBucket:   newMemoryLimiter[limiter.LeakyBucket](p),
+	}
+}
+
+type memoryLimiter[S any, PS limiterState[S]] struct {
+	mu     sync.Mutex
+	states map[string]S
+	params limiter.Params
+	now    func() time.Time
+}
+
+func newMemoryLimiter[S any, PS limiterState[S]](p limiter.Params) *memoryLimiter[S, PS] {
+	return &memoryLimiter[S, PS]{
+		states: make(map[string]S),
+		params: p,
+		now:    time.Now,
+	}
+}
+
+func (ml *memoryLimiter[S, PS]) TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	state := ml.states[clientID]
+	res := PS(&state).Consume(ml.params, cost, ml.now())
+	ml.states[clientID] = state
+	return consumed(res)
+}
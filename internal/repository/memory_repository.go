@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/models"
+)
+
+// MemoryRepository хранит бакеты в памяти процесса. Ограничения и ошибки те же,
+// что у таблицы token_buckets в Postgres, включая триггер на last_refill,
+// поэтому сервис и тесты не видят разницы между хранилищами
+type MemoryRepository struct {
+	mu      sync.Mutex
+	buckets map[string]models.Bucket
+	cfg     *config.Config
+	now     func() time.Time
+}
+
+func NewMemoryRepository(cfg *config.Config) *MemoryRepository {
+	return &MemoryRepository{
+		buckets: make(map[string]models.Bucket),
+		cfg:     cfg,
+		now:     time.Now,
+	}
+}
+
+// check повторяет CHECK-ограничения таблицы и возвращает имя нарушенного
+func check(b models.Bucket) string {
+	switch {
+	case b.Capacity <= 0:
+		return "ck_capacity_positive"
+	case b.Tokens < 0:
+		return chkTokensNonNeg
+	case b.Tokens > b.Capacity:
+		return chkTokensLeCap
+	// 0 - значение из конфига, в таблице это NULL
+	case b.RefillRate < 0:
+		return "ck_refill_rate_positive"
+	case b.RefillInterval < 0:
+		return "ck_refill_interval_positive"
+	case b.Cost < 0:
+		return "ck_cost_positive"
+	}
+	return ""
+}
+
+// update меняет существующий бакет так же, как UPDATE с триггером
+// update_last_refill: ручная выдача токенов сбрасывает отсчёт пополнения
+func (mr *MemoryRepository) update(clientID string, fn func(b *models.Bucket)) (string, error) {
+	b, ok := mr.buckets[clientID]
+	if !ok {
+		return "", errdefs.ErrNotFound
+	}
+	old := b
+	fn(&b)
+	if b.Tokens > old.Tokens && b.LastRefill.Equal(old.LastRefill) {
+		b.LastRefill = mr.now()
+	}
+	if violated := check(b); violated != "" {
+		return violated, nil
+	}
+	mr.buckets[clientID] = b
+	return "", nil
+}
+
+// Логика
+func (mr *MemoryRepository) TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error) {
+	return mr.TryConsumeAll(ctx, []models.ConsumeRequest{defaultRequest(mr.cfg, clientID, cost)})
+}
+
+func (mr *MemoryRepository) TryConsumeAll(ctx context.Context, reqs []models.ConsumeRequest) (*models.ConsumeResult, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	now := mr.now()
+	buckets := make([]models.Bucket, len(reqs))
+	for i, req := range reqs {
+		b, ok := mr.buckets[req.ClientID]
+		if !ok {
+			b = newBucket(req, now)
+		}
+		buckets[i] = b
+	}
+	res, save := consumeAll(buckets, reqs, now)
+	for i, b := range buckets {
+		if save[i] {
+			mr.buckets[b.ClientID] = b
+		}
+	}
+	return consumed(res)
+}
+
+// SpendBuckets - то же, что spend_tokens в Postgres: отсутствующий бакет
+// создаётся полным, затем пополняется и теряет req.Cost токенов
+func (mr *MemoryRepository) SpendBuckets(ctx context.Context, reqs []models.ConsumeRequest) ([]models.Bucket, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	now := mr.now()
+	buckets := make([]models.Bucket, len(reqs))
+	for i, req := range reqs {
+		b, ok := mr.buckets[req.ClientID]
+		if !ok {
+			b = newBucket(req, now)
+		}
+		amount, interval := b.Refill(req.RefillAmount, req.RefillInterval)
+		spend(&b, req.Cost, amount, interval, now)
+		mr.buckets[b.ClientID] = b
+		buckets[i] = b
+	}
+	return buckets, nil
+}
+
+// CRUD
+func (mr *MemoryRepository) CreateBucket(ctx context.Context, bucket *models.Bucket) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if _, ok := mr.buckets[bucket.ClientID]; ok {
+		return errdefs.Wrapf(errdefs.ErrConflict, "ClientID '%s' already exists", bucket.ClientID)
+	}
+	if violated := check(*bucket); violated != "" {
+		return errdefs.Wrapf(errdefs.ErrInvalidInput, "validation failed: violates check constraint %q", violated)
+	}
+	mr.buckets[bucket.ClientID] = *bucket
+	return nil
+}
+
+func (mr *MemoryRepository) RemoveBucket(ctx context.Context, clientID string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if _, ok := mr.buckets[clientID]; !ok {
+		return errdefs.ErrNotFound
+	}
+	delete(mr.buckets, clientID)
+	return nil
+}
+
+func (mr *MemoryRepository) UpdateCapacity(ctx context.Context, clientID string, newCapacity int) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	violated, err := mr.update(clientID, func(b *models.Bucket) { b.Capacity = newCapacity })
+	if err != nil {
+		return err
+	}
+	switch violated {
+	case "":
+		return nil
+	case chkTokensLeCap:
+		return errdefs.TokensLeCap
+	case chkTokensNonNeg:
+		return errdefs.NotEnoughTokens
+	}
+	return errdefs.Wrapf(errdefs.ErrDB, "failed to update capacity buckets: violates check constraint %q", violated)
+}
+
+func (mr *MemoryRepository) UpdateCountTokens(ctx context.Context, clientID string, newCountT int) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	violated, err := mr.update(clientID, func(b *models.Bucket) { b.Tokens = newCountT })
+	if err != nil {
+		return err
+	}
+	switch violated {
+	case "":
+		return nil
+	case chkTokensLeCap:
+		return errdefs.TokensLeCap
+	case chkTokensNonNeg:
+		return errdefs.NotEnoughTokens
+	}
+	return errdefs.Wrapf(errdefs.ErrDB, "failed to update tokens buckets: violates check constraint %q", violated)
+}
+
+func (mr *MemoryRepository) UpdateRefill(ctx context.Context, clientID string, rate int, interval time.Duration) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	violated, err := mr.update(clientID, func(b *models.Bucket) {
+		b.RefillRate = rate
+		b.RefillInterval = models.Duration(interval)
+	})
+	if err != nil {
+		return err
+	}
+	if violated != "" {
+		return errdefs.Wrapf(errdefs.ErrInvalidInput, "validation failed: violates check constraint %q", violated)
+	}
+	return nil
+}
+
+func (mr *MemoryRepository) UpdateCost(ctx context.Context, clientID string, cost int) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	violated, err := mr.update(clientID, func(b *models.Bucket) { b.Cost = cost })
+	if err != nil {
+		return err
+	}
+	if violated != "" {
+		return errdefs.Wrapf(errdefs.ErrInvalidInput, "validation failed: violates check constraint %q", violated)
+	}
+	return nil
+}
+
+func (mr *MemoryRepository) GetBucket(ctx context.Context, clientID string) (*models.Bucket, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	b, ok := mr.buckets[clientID]
+	if !ok {
+		return nil, errdefs.ErrNotFound
+	}
+	return &b, nil
+}
+
+// ListBuckets сортирует так же, как запрос в Postgres - по last_refill от новых к старым
+func (mr *MemoryRepository) ListBuckets(ctx context.Context, limit, offset int) (*[]models.Bucket, error) {
+	mr.mu.Lock()
+	all := make([]models.Bucket, 0, len(mr.buckets))
+	for _, b := range mr.buckets {
+		all = append(all, b)
+	}
+	mr.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].LastRefill.Equal(all[j].LastRefill) {
+			return all[i].LastRefill.After(all[j].LastRefill)
+		}
+		return all[i].ClientID < all[j].ClientID
+	})
+
+	var buckets []models.Bucket
+	offset = max(offset, 0)
+	if offset < len(all) {
+		end := len(all)
+		if limit >= 0 {
+			end = min(offset+limit, len(all))
+		}
+		buckets = all[offset:end]
+	}
+	return &buckets, nil
+}
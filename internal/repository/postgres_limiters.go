@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/interfaces"
+	"gopher-equalizer/internal/limiter"
+	"gopher-equalizer/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// NewPostgresLimiters - алгоритмы кроме token_bucket над таблицами Postgres.
+// В отличие от consume_tokens они считаются в Go: транзакция берёт
+// advisory lock на клиента, читает состояние и пишет его обратно. Это
+// несколько запросов вместо одного, зато формулы общие для всех хранилищ
+func NewPostgresLimiters(db *pgxpool.Pool, cfg *config.Config) map[string]interfaces.ILimiter {
+	p := limiter.NewParams(cfg.Bucket)
+	return map[string]interfaces.ILimiter{
+		config.AlgorithmFixedWindow:   newPgLimiter(db, fixedWindowTable, p),
+		config.AlgorithmSlidingLog:    pgSlidingLog{db: db, params: p},
+		config.AlgorithmSlidingWindow: newPgLimiter(db, slidingWindowTable, p),
+		config.AlgorithmGCRA:          newPgLimiter(db, gcraTable, p),
+		config.AlgorithmLeakyBucket:   newPgLimiter(db, leakyBucketTable, p),
+	}
+}
+
+type pgLimiter[S any, PS limiterState[S]] struct {
+	db          *pgxpool.Pool
+	table       stateTable[S]
+	params      limiter.Params
+	selectQuery string
+	upsertQuery string
+}
+
+func newPgLimiter[S any, PS limiterState[S]](db *pgxpool.Pool, table stateTable[S], p limiter.Params) pgLimiter[S, PS] {
+	l := pgLimiter[S, PS]{db: db, table: table, params: p}
+	l.selectQuery, l.upsertQuery = table.queries(func(i int) string { return "$" + strconv.Itoa(i) })
+	return l
+}
+
+func (l pgLimiter[S, PS]) TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error) {
+	var res models.ConsumeResult
+	err := pgx.BeginFunc(ctx, l.db, func(tx pgx.Tx) error {
+		if err := pgLockClient(ctx, tx, l.table.name, clientID); err != nil {
+			return err
+		}
+		var state S
+		values, dest := l.table.scanDest()
+		err := tx.QueryRow(ctx, l.selectQuery, clientID).Scan(dest...)
+		switch {
+		case errdefs.Is(err, pgx.ErrNoRows):
+		case err != nil:
+			return err
+		default:
+			state = l.table.decode(values)
+		}
+
+		res = PS(&state).Consume(l.params, cost, time.Now())
+		_, err = tx.Exec(ctx, l.upsertQuery, l.table.upsertArgs(clientID, &state)...)
+		return err
+	})
+	if err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to consume token for %s: %v", clientID, err)
+	}
+	return consumed(res)
+}
+
+// pgSlidingLog хранит по строке на пропущенный запрос
+type pgSlidingLog struct {
+	db     *pgxpool.Pool
+	params limiter.Params
+}
+
+func (l pgSlidingLog) TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error) {
+	var res models.ConsumeResult
+	err := pgx.BeginFunc(ctx, l.db, func(tx pgx.Tx) error {
+		if err := pgLockClient(ctx, tx, "sliding_log", clientID); err != nil {
+			return err
+		}
+		now := time.Now()
+		// записи старше окна больше не нужны, Consume видит только окно
+		_, err := tx.Exec(ctx, `DELETE FROM sliding_log WHERE client_id = $1 AND at <= $2`,
+			clientID, now.Add(-l.params.Window).UnixMicro())
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query(ctx, `SELECT at, cost FROM sliding_log WHERE client_id = $1 ORDER BY at`, clientID)
+		if err != nil {
+			return err
+		}
+		entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (limiter.LogEntry, error) {
+			var (
+				at    int64
+				entry limiter.LogEntry
+			)
+			err := row.Scan(&at, &entry.Cost)
+			entry.At = time.UnixMicro(at)
+			return entry, err
+		})
+		if err != nil {
+			return err
+		}
+
+		state := limiter.SlidingLog{Entries: entries}
+		res = state.Consume(l.params, cost, now)
+		if res.Allowed {
+			_, err = tx.Exec(ctx, `INSERT INTO sliding_log (client_id, at, cost) VALUES ($1, $2, $3)`,
+				clientID, now.UnixMicro(), cost)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to consume token for %s: %v", clientID, err)
+	}
+	return consumed(res)
+}
+
+// pgLockClient сериализует запросы клиента к таблице до конца транзакции.
+// Строки состояния может ещё не быть, поэтому FOR UPDATE не подходит
+func pgLockClient(ctx context.Context, tx pgx.Tx, table, clientID string) error {
+	_, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtextextended($1, 0))", table+":"+clientID)
+	return err
+}
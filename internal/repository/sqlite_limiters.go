@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/interfaces"
+	"gopher-equalizer/internal/limiter"
+	"gopher-equalizer/internal/models"
+)
+
+// NewSQLiteLimiters - алгоритмы кроме token_bucket над таблицами SQLite.
+// Состояние читается и пишется в транзакции с блокировкой записи, как
+// у SQLiteRepository
+func NewSQLiteLimiters(db *sql.DB, cfg *config.Config) map[string]interfaces.ILimiter {
+	p := limiter.NewParams(cfg.Bucket)
+	return map[string]interfaces.ILimiter{
+		config.AlgorithmFixedWindow:   newSQLiteLimiter(db, fixedWindowTable, p),
+		config.AlgorithmSlidingLog:    sqliteSlidingLog{db: db, params: p},
+		config.AlgorithmSlidingWindow: newSQLiteLimiter(db, slidingWindowTable, p),
+		config.AlgorithmGCRA:          newSQLiteLimiter(db, gcraTable, p),
+		config.AlgorithmLeakyBucket:   newSQLiteLimiter(db, leakyBucketTable, p),
+	}
+}
+
+type sqliteLimiter[S any, PS limiterState[S]] struct {
+	db          *sql.DB
+	table       stateTable[S]
+	params      limiter.Params
+	selectQuery string
+	upsertQuery string
+}
+
+func newSQLiteLimiter[S any, PS limiterState[S]](db *sql.DB, table stateTable[S], p limiter.Params) sqliteLimiter[S, PS] {
+	l := sqliteLimiter[S, PS]{db: db, table: table, params: p}
+	l.selectQuery, l.upsertQuery = table.queries(func(int) string { return "?" })
+	return l
+}
+
+func (l sqliteLimiter[S, PS]) TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error) {
+	res, err := sqliteTx(ctx, l.db, func(tx *sql.Tx) (models.ConsumeResult, error) {
+		var state S
+		values, dest := l.table.scanDest()
+		err := tx.QueryRowContext(ctx, l.selectQuery, clientID).Scan(dest...)
+		switch {
+		case errdefs.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return models.ConsumeResult{}, err
+		default:
+			state = l.table.decode(values)
+		}
+
+		res := PS(&state).Consume(l.params, cost, time.Now())
+		_, err = tx.ExecContext(ctx, l.upsertQuery, l.table.upsertArgs(clientID, &state)...)
+		return res, err
+	})
+	if err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to consume token for %s: %v", clientID, err)
+	}
+	return consumed(res)
+}
+
+// sqliteSlidingLog хранит по строке на пропущенный запрос
+type sqliteSlidingLog struct {
+	db     *sql.DB
+	params limiter.Params
+}
+
+func (l sqliteSlidingLog) TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error) {
+	res, err := sqliteTx(ctx, l.db, func(tx *sql.Tx) (models.ConsumeResult, error) {
+		now := time.Now()
+		// записи старше окна больше не нужны, Consume видит только окно
+		_, err := tx.ExecContext(ctx, `DELETE FROM sliding_log WHERE client_id = ? AND at <= ?`,
+			clientID, now.Add(-l.params.Window).UnixMicro())
+		if err != nil {
+			return models.ConsumeResult{}, err
+		}
+		rows, err := tx.QueryContext(ctx, `SELECT at, cost FROM sliding_log WHERE client_id = ? ORDER BY at`, clientID)
+		if err != nil {
+			return models.ConsumeResult{}, err
+		}
+		var state limiter.SlidingLog
+		for rows.Next() {
+			var (
+				at    int64
+				entry limiter.LogEntry
+			)
+			if err := rows.Scan(&at, &entry.Cost); err != nil {
+				rows.Close()
+				return models.ConsumeResult{}, err
+			}
+			entry.At = time.UnixMicro(at)
+			state.Entries = append(state.Entries, entry)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return models.ConsumeResult{}, err
+		}
+
+		res := state.Consume(l.params, cost, now)
+		if res.Allowed {
+			_, err = tx.ExecContext(ctx, `INSERT INTO sliding_log (client_id, at, cost) VALUES (?, ?, ?)`,
+				clientID, now.UnixMicro(), cost)
+		}
+		return res, err
+	})
+	if err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to consume token for %s: %v", clientID, err)
+	}
+	return consumed(res)
+}
+
+// sqliteTx выполняет fn в транзакции и коммитит её, если fn не вернула ошибку
+func sqliteTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) (models.ConsumeResult, error)) (models.ConsumeResult, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.ConsumeResult{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := fn(tx)
+	if err != nil {
+		return res, err
+	}
+	return res, tx.Commit()
+}
@@ -0,0 +1,328 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/models"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteRepository хранит бакеты в файле SQLite. Схема, ограничения и триггер
+// на last_refill те же, что в Postgres, last_refill хранится в миллисекундах.
+// Списание идёт в транзакции с блокировкой записи, поэтому атомарно
+type SQLiteRepository struct {
+	db  *sql.DB
+	cfg *config.Config
+}
+
+func NewSQLiteRepository(db *sql.DB, cfg *config.Config) SQLiteRepository {
+	return SQLiteRepository{
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+// sqliteCode возвращает расширенный код ошибки SQLite, 0 - если ошибка не от SQLite
+func sqliteCode(err error) int {
+	var sqErr *sqlite.Error
+	if errdefs.As(err, &sqErr) {
+		return sqErr.Code()
+	}
+	return 0
+}
+
+// tokensError переводит нарушение ограничений на токены в ошибки errdefs,
+// для остальных ошибок возвращает nil
+func tokensError(err error) error {
+	if sqliteCode(err) != sqlite3.SQLITE_CONSTRAINT_CHECK {
+		return nil
+	}
+	switch {
+	case strings.Contains(err.Error(), chkTokensLeCap):
+		return errdefs.TokensLeCap
+	case strings.Contains(err.Error(), chkTokensNonNeg):
+		return errdefs.NotEnoughTokens
+	}
+	return nil
+}
+
+// sqliteBucketColumns - колонки для scanBucket, незаданные скорость
+// пополнения и стоимость читаются как 0
+const sqliteBucketColumns = `client_id, capacity, tokens, last_refill,
+	COALESCE(refill_rate, 0), COALESCE(refill_interval, 0), COALESCE(cost, 0)`
+
+func scanBucket(row interface{ Scan(...any) error }) (*models.Bucket, error) {
+	var (
+		bucket     models.Bucket
+		lastRefill int64
+		interval   int64
+	)
+	err := row.Scan(&bucket.ClientID, &bucket.Capacity, &bucket.Tokens, &lastRefill,
+		&bucket.RefillRate, &interval, &bucket.Cost)
+	if err != nil {
+		return nil, err
+	}
+	bucket.LastRefill = time.UnixMilli(lastRefill)
+	bucket.RefillInterval = models.Duration(time.Duration(interval) * time.Millisecond)
+	return &bucket, nil
+}
+
+// Логика
+func (sr SQLiteRepository) TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error) {
+	return sr.TryConsumeAll(ctx, []models.ConsumeRequest{defaultRequest(sr.cfg, clientID, cost)})
+}
+
+// TryConsumeAll читает и пишет все бакеты в одной транзакции, BEGIN IMMEDIATE
+// не даёт другим соединениям вклиниться между ними
+func (sr SQLiteRepository) TryConsumeAll(ctx context.Context, reqs []models.ConsumeRequest) (*models.ConsumeResult, error) {
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to consume tokens: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	buckets := make([]models.Bucket, len(reqs))
+	for i, req := range reqs {
+		bucket, err := scanBucket(tx.QueryRowContext(ctx, `
+			SELECT `+sqliteBucketColumns+`
+			FROM token_buckets
+			WHERE client_id = ?
+		`, req.ClientID))
+		switch {
+		case errdefs.Is(err, sql.ErrNoRows):
+			buckets[i] = newBucket(req, now)
+		case err != nil:
+			return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to consume token for %s: %v", req.ClientID, err)
+		default:
+			buckets[i] = *bucket
+		}
+	}
+
+	res, save := consumeAll(buckets, reqs, now)
+	for i, bucket := range buckets {
+		if !save[i] {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO token_buckets (client_id, capacity, tokens, last_refill)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (client_id) DO UPDATE
+			SET
+			    tokens = excluded.tokens,
+			    last_refill = excluded.last_refill
+		`, bucket.ClientID, bucket.Capacity, bucket.Tokens, bucket.LastRefill.UnixMilli())
+		if err != nil {
+			return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to consume token for %s: %v", bucket.ClientID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to consume tokens: %v", err)
+	}
+	return consumed(res)
+}
+
+// SpendBuckets - то же, что spend_tokens в Postgres, все бакеты в одной транзакции
+func (sr SQLiteRepository) SpendBuckets(ctx context.Context, reqs []models.ConsumeRequest) ([]models.Bucket, error) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to spend %d buckets: %v", len(reqs), err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	buckets := make([]models.Bucket, len(reqs))
+	for i, req := range reqs {
+		bucket, err := scanBucket(tx.QueryRowContext(ctx, `
+			SELECT `+sqliteBucketColumns+`
+			FROM token_buckets
+			WHERE client_id = ?
+		`, req.ClientID))
+		switch {
+		case errdefs.Is(err, sql.ErrNoRows):
+			bucket = new(models.Bucket)
+			*bucket = newBucket(req, now)
+		case err != nil:
+			return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to spend bucket %s: %v", req.ClientID, err)
+		}
+		amount, interval := bucket.Refill(req.RefillAmount, req.RefillInterval)
+		spend(bucket, req.Cost, amount, interval, now)
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO token_buckets (client_id, capacity, tokens, last_refill)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (client_id) DO UPDATE
+			SET
+			    tokens = excluded.tokens,
+			    last_refill = excluded.last_refill
+		`, bucket.ClientID, bucket.Capacity, bucket.Tokens, bucket.LastRefill.UnixMilli())
+		if err != nil {
+			return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to spend bucket %s: %v", req.ClientID, err)
+		}
+		buckets[i] = *bucket
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to spend %d buckets: %v", len(reqs), err)
+	}
+	return buckets, nil
+}
+
+// CRUD
+func (sr SQLiteRepository) CreateBucket(ctx context.Context, bucket *models.Bucket) error {
+	query := `
+		INSERT INTO token_buckets (
+			client_id, capacity, tokens, last_refill, refill_rate, refill_interval, cost
+		) VALUES (?, ?, ?, ?, NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, 0))
+	`
+	_, err := sr.db.ExecContext(ctx, query,
+		bucket.ClientID,
+		bucket.Capacity,
+		bucket.Tokens,
+		bucket.LastRefill.UnixMilli(),
+		bucket.RefillRate,
+		time.Duration(bucket.RefillInterval).Milliseconds(),
+		bucket.Cost,
+	)
+	if err != nil {
+		switch sqliteCode(err) {
+		case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY, sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+			return errdefs.Wrapf(errdefs.ErrConflict, "ClientID '%s' already exists", bucket.ClientID)
+		case sqlite3.SQLITE_CONSTRAINT_CHECK:
+			return errdefs.Wrapf(errdefs.ErrInvalidInput, "validation failed: %v", err)
+		}
+		return errdefs.Wrapf(errdefs.ErrDB, "failed to create bucket: %v", err)
+	}
+	return nil
+}
+
+func (sr SQLiteRepository) RemoveBucket(ctx context.Context, clientID string) error {
+	res, err := sr.db.ExecContext(ctx, `DELETE FROM token_buckets WHERE client_id = ?`, clientID)
+	if err != nil {
+		return errdefs.Wrapf(errdefs.ErrDB, "failed to delete bucket %q: %v", clientID, err)
+	}
+	return rowsAffected(res)
+}
+
+func (sr SQLiteRepository) UpdateCapacity(ctx context.Context, clientID string, newCapacity int) error {
+	res, err := sr.db.ExecContext(ctx, `
+		UPDATE token_buckets
+		SET capacity = ?
+		WHERE client_id = ?
+	`, newCapacity, clientID)
+	if err != nil {
+		if mapped := tokensError(err); mapped != nil {
+			return mapped
+		}
+		return errdefs.Wrapf(errdefs.ErrDB, "failed to update capacity buckets: %v", err)
+	}
+	return rowsAffected(res)
+}
+
+func (sr SQLiteRepository) UpdateCountTokens(ctx context.Context, clientID string, newCountT int) error {
+	res, err := sr.db.ExecContext(ctx, `
+		UPDATE token_buckets
+		SET tokens = ?
+		WHERE client_id = ?
+	`, newCountT, clientID)
+	if err != nil {
+		if mapped := tokensError(err); mapped != nil {
+			return mapped
+		}
+		return errdefs.Wrapf(errdefs.ErrDB, "failed to update tokens buckets: %v", err)
+	}
+	return rowsAffected(res)
+}
+
+func (sr SQLiteRepository) UpdateRefill(ctx context.Context, clientID string, rate int, interval time.Duration) error {
+	res, err := sr.db.ExecContext(ctx, `
+		UPDATE token_buckets
+		SET refill_rate = NULLIF(?, 0), refill_interval = NULLIF(?, 0)
+		WHERE client_id = ?
+	`, rate, interval.Milliseconds(), clientID)
+	if err != nil {
+		if sqliteCode(err) == sqlite3.SQLITE_CONSTRAINT_CHECK {
+			return errdefs.Wrapf(errdefs.ErrInvalidInput, "validation failed: %v", err)
+		}
+		return errdefs.Wrapf(errdefs.ErrDB, "failed to update refill buckets: %v", err)
+	}
+	return rowsAffected(res)
+}
+
+func (sr SQLiteRepository) UpdateCost(ctx context.Context, clientID string, cost int) error {
+	res, err := sr.db.ExecContext(ctx, `
+		UPDATE token_buckets
+		SET cost = NULLIF(?, 0)
+		WHERE client_id = ?
+	`, cost, clientID)
+	if err != nil {
+		if sqliteCode(err) == sqlite3.SQLITE_CONSTRAINT_CHECK {
+			return errdefs.Wrapf(errdefs.ErrInvalidInput, "validation failed: %v", err)
+		}
+		return errdefs.Wrapf(errdefs.ErrDB, "failed to update cost buckets: %v", err)
+	}
+	return rowsAffected(res)
+}
+
+func (sr SQLiteRepository) GetBucket(ctx context.Context, clientID string) (*models.Bucket, error) {
+	bucket, err := scanBucket(sr.db.QueryRowContext(ctx, `
+		SELECT `+sqliteBucketColumns+`
+		FROM token_buckets
+		WHERE client_id = ?
+	`, clientID))
+	if err != nil {
+		if errdefs.Is(err, sql.ErrNoRows) {
+			return nil, errdefs.ErrNotFound
+		}
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to get bucket %s: %v", clientID, err)
+	}
+	return bucket, nil
+}
+
+func (sr SQLiteRepository) ListBuckets(ctx context.Context, limit, offset int) (*[]models.Bucket, error) {
+	rows, err := sr.db.QueryContext(ctx, `
+		SELECT `+sqliteBucketColumns+`
+		FROM token_buckets
+		ORDER BY last_refill DESC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to list buckets: %v", err)
+	}
+	defer rows.Close()
+
+	var buckets []models.Bucket
+	for rows.Next() {
+		bucket, err := scanBucket(rows)
+		if err != nil {
+			return nil, errdefs.Wrapf(errdefs.ErrDB, "failed to scan bucket: %v", err)
+		}
+		buckets = append(buckets, *bucket)
+	}
+
+	if rows.Err() != nil {
+		return nil, errdefs.Wrapf(errdefs.ErrDB, "rows iteration error: %v", rows.Err())
+	}
+
+	return &buckets, nil
+}
+
+func rowsAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errdefs.Wrapf(errdefs.ErrDB, "failed to get rows affected: %v", err)
+	}
+	if n == 0 {
+		return errdefs.ErrNotFound
+	}
+	return nil
+}
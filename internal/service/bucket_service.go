@@ -16,6 +16,8 @@ import (
 type BucketService struct {
     repo interfaces.IBucketRepository
     cfg *config.Config
+    // limiters - алгоритмы кроме token_bucket, им служит repo
+    limiters map[string]interfaces.ILimiter
 }
 
 func NewBucketService(cfg *config.Config, repo interfaces.IBucketRepository) BucketService {
@@ -25,66 +27,131 @@ func NewBucketService(cfg *config.Config, repo interfaces.IBucketRepository) Buc
     }
 }
 
+// WithLimiters возвращает сервис, который для клиентов с другим алгоритмом
+// (bucket.algorithm, bucket.clientAlgorithms) идёт в limiters, а не в бакеты
+func (bs BucketService) WithLimiters(limiters map[string]interfaces.ILimiter) BucketService {
+    bs.limiters = limiters
+    return bs
+}
+
+// limiter - алгоритм клиента, token_bucket - это сам репозиторий бакетов
+func (bs BucketService) limiter(clientID string) (string, interfaces.ILimiter, error) {
+    algorithm := bs.cfg.Bucket.AlgorithmFor(clientID)
+    if algorithm == config.AlgorithmTokenBucket {
+        return algorithm, bs.repo, nil
+    }
+    l, ok := bs.limiters[algorithm]
+    if !ok {
+        return algorithm, nil, errdefs.Wrapf(errdefs.ErrInvalidInput, "limiter %q is not available", algorithm)
+    }
+    return algorithm, l, nil
+}
+
 // Логика
-func (bs BucketService) TryConsume(ctx context.Context, clientID string) error {
+// Учёт запроса делает хранилище алгоритма атомарно, поэтому параллельные
+// запросы одного клиента не гоняются между собой. cost - стоимость запроса
+// по правилам, стоимость бакета важнее. У leaky_bucket пропущенный запрос
+// ждёт здесь своей очереди. Состояние лимита отдаётся прокси для заголовков
+// RateLimit-*. Запрос, подошедший под политики bucket.policies, списывается
+// с бакетов клиента по этим политикам, а не с общего
+func (bs BucketService) TryConsume(ctx context.Context, clientID string, cost int, policies ...string) (*models.ConsumeResult, error) {
     logger := logger.GetLoggerFromCtx(ctx)
-    now := time.Now()
 
-    bucket, err := bs.repo.GetBucket(ctx, clientID)
+    if cost <= 0 {
+        return nil, errdefs.Wrapf(errdefs.ErrInvalidInput, "cost must be positive, got %d", cost)
+    }
+    if len(policies) > 0 {
+        return bs.consumePolicies(ctx, clientID, cost, policies)
+    }
+    algorithm, limiter, err := bs.limiter(clientID)
     if err != nil {
-        if errdefs.Is(err, errdefs.ErrNotFound) {
-            logger.Info(ctx, "creating new token bucket for client", zap.String("clientID", clientID))
-            return bs.repo.CreateBucket(ctx,
-                &models.Bucket{
-                    ClientID:   clientID,
-                    Capacity:   bs.cfg.Bucket.Capacity,
-                    Tokens:     bs.cfg.Bucket.Capacity - 1,
-                    LastRefill: time.Now(),
-                },
+        logger.Error(ctx, "consume failed: ", zap.Error(err))
+        return nil, err
+    }
+    res, err := limiter.TryConsume(ctx, clientID, cost)
+    if err != nil {
+        if errdefs.Is(err, errdefs.NotEnoughTokens) {
+            logger.Info(ctx, "consume failed: ",
+                zap.String("clientID", clientID),
+                zap.String("algorithm", algorithm),
+                zap.Int("cost", cost),
+                zap.Int("tokens", res.Tokens),
+                zap.Duration("next_token", res.NextToken),
+                zap.Error(err),
             )
+            // очищаем ошибку от логов Psql, так как скорее всего 
+            // запрос от proxy
+            return res, errdefs.ErrRateLimitExceeded
         }
-        logger.Error(ctx, "failed to consume token", zap.String("clientID", clientID), zap.Error(err))
-        return err
+        logger.Error(ctx, "consume failed: ", zap.Error(err))
+        return nil, err
     }
-    
-    logger.Info(ctx, "bucket found for client", zap.String("clientID", clientID))
-
-
-    elapsed := now.Sub(bucket.LastRefill)
-    interval := time.Duration(bs.cfg.Bucket.Refill.Interval)
-    if elapsed >= interval {
-        // Сколько шагов интервала прошло
-        steps := int(elapsed / interval)
-        amount := steps * bs.cfg.Bucket.Refill.Amount
-
-        if amount > 0 {
-            logger.Info(ctx, "refilling tokens",
-                    zap.String("clientID", clientID),
-                    zap.Int("amount", amount),
-                    zap.Int("capacity", bucket.Capacity),
-                )
-
-            if err := bs.repo.RefillTokens(ctx, clientID, amount); err != nil {
-                logger.Error(ctx, "refill failed", zap.Error(err))
-                return err
-            }
-            logger.Info(ctx, "tokens refilled", zap.String("clientID", clientID))
+
+    logger.Info(ctx, "token consumed",
+        zap.String("clientID", clientID),
+        zap.String("algorithm", algorithm),
+        zap.Int("tokens", res.Tokens),
+        zap.Duration("delay", res.Delay),
+    )
+    if res.Delay > 0 {
+        timer := time.NewTimer(res.Delay)
+        defer timer.Stop()
+        select {
+        case <-ctx.Done():
+            // место в очереди уже занято, вернуть его нельзя
+            return res, ctx.Err()
+        case <-timer.C:
         }
     }
+    return res, nil
+}
 
-    if err := bs.repo.TryConsume(ctx, clientID); err != nil {
+// consumePolicies списывает cost со всех бакетов клиента по политикам
+// атомарно. Бакеты политик - token bucket, алгоритм клиента к ним не относится
+func (bs BucketService) consumePolicies(ctx context.Context, clientID string, cost int, policies []string) (*models.ConsumeResult, error) {
+    logger := logger.GetLoggerFromCtx(ctx)
+
+    reqs := make([]models.ConsumeRequest, 0, len(policies))
+    for _, name := range policies {
+        pc, ok := bs.cfg.Bucket.Policy(name)
+        if !ok {
+            err := errdefs.Wrapf(errdefs.ErrInvalidInput, "unknown policy %q", name)
+            logger.Error(ctx, "consume failed: ", zap.Error(err))
+            return nil, err
+        }
+        amount, interval := bs.cfg.Bucket.PolicyRefill(pc)
+        reqs = append(reqs, models.ConsumeRequest{
+            ClientID:       models.PolicyBucketID(name, clientID),
+            Cost:           cost,
+            Capacity:       pc.Capacity,
+            RefillAmount:   amount,
+            RefillInterval: interval,
+        })
+    }
+
+    res, err := bs.repo.TryConsumeAll(ctx, reqs)
+    if err != nil {
         if errdefs.Is(err, errdefs.NotEnoughTokens) {
-            logger.Info(ctx, "consume failed: ", zap.Error(err))
-            // очищаем ошибку от логов Psql, так как скорее всего 
-            // запрос от proxy
-            return errdefs.ErrRateLimitExceeded
+            logger.Info(ctx, "consume failed: ",
+                zap.String("clientID", clientID),
+                zap.Strings("policies", policies),
+                zap.Int("cost", cost),
+                zap.Int("tokens", res.Tokens),
+                zap.Duration("retry_after", res.RetryAfter),
+                zap.Error(err),
+            )
+            return res, errdefs.ErrRateLimitExceeded
         }
         logger.Error(ctx, "consume failed: ", zap.Error(err))
-        return err
+        return nil, err
     }
 
-    logger.Info(ctx, "token consumed", zap.String("clientID", clientID))
-    return nil
+    logger.Info(ctx, "token consumed",
+        zap.String("clientID", clientID),
+        zap.Strings("policies", policies),
+        zap.Int("tokens", res.Tokens),
+    )
+    return res, nil
 }
 
 // CRUD
@@ -98,9 +165,27 @@ func (bs BucketService) CreateBucket(ctx context.Context, b *models.Bucket) erro
     if b.Tokens < 0 || b.Tokens > b.Capacity {
         return errdefs.Wrap(errdefs.ErrInvalidInput, "Tokens must be in the range [0, Capacity]")
     }
+    if err := validateRefill(b.RefillRate, time.Duration(b.RefillInterval)); err != nil {
+        return err
+    }
+    if b.Cost < 0 {
+        return errdefs.Wrap(errdefs.ErrInvalidInput, "Cost must be not negative")
+    }
     return bs.repo.CreateBucket(ctx, b)
 }
 
+// validateRefill - 0 означает значение из конфига. Хранилища считают
+// пополнение в миллисекундах, поэтому более точный интервал не принимается
+func validateRefill(rate int, interval time.Duration) error {
+    if rate < 0 {
+        return errdefs.Wrap(errdefs.ErrInvalidInput, "RefillRate must be not negative")
+    }
+    if interval < 0 || interval%time.Millisecond != 0 {
+        return errdefs.Wrap(errdefs.ErrInvalidInput, "RefillInterval must be a non-negative whole number of milliseconds")
+    }
+    return nil
+}
+
 func (bs BucketService) RemoveBucket(ctx context.Context, clientID string) error {
     if clientID == "" {
         return errdefs.Wrap(errdefs.ErrInvalidInput, "ClientID reqiured")
@@ -128,6 +213,27 @@ func (bs BucketService) UpdateTokens(ctx context.Context, clientID string, newTo
     return bs.repo.UpdateCountTokens(ctx, clientID, newTokens)
 }
 
+func (bs BucketService) UpdateRefill(ctx context.Context, clientID string, rate int, interval time.Duration) error {
+    if clientID == "" {
+        return errdefs.Wrap(errdefs.ErrInvalidInput, "ClientID reqiured")
+    }
+    if err := validateRefill(rate, interval); err != nil {
+        return err
+    }
+    return bs.repo.UpdateRefill(ctx, clientID, rate, interval)
+}
+
+// UpdateCost задаёт стоимость любого запроса клиента, 0 - стоимость по правилам
+func (bs BucketService) UpdateCost(ctx context.Context, clientID string, cost int) error {
+    if clientID == "" {
+        return errdefs.Wrap(errdefs.ErrInvalidInput, "ClientID reqiured")
+    }
+    if cost < 0 {
+        return errdefs.Wrap(errdefs.ErrInvalidInput, "Cost must be not negative")
+    }
+    return bs.repo.UpdateCost(ctx, clientID, cost)
+}
+
 func (bs BucketService) GetBucket(ctx context.Context, clientID string) (*models.Bucket, error) {
     if clientID == "" {
         return nil, errdefs.Wrap(errdefs.ErrInvalidInput, "ClientID reqiured")
@@ -15,6 +15,7 @@ import (
     "github.com/stretchr/testify/require"
 
     "gopher-equalizer/internal/errdefs"
+    "gopher-equalizer/internal/interfaces"
     "gopher-equalizer/internal/logger"
     "gopher-equalizer/internal/models"
     "gopher-equalizer/config"
@@ -42,6 +43,14 @@ func (m *MockRepository) UpdateCountTokens(ctx context.Context, clientID string,
     args := m.Called(ctx, clientID, newT)
     return args.Error(0)
 }
+func (m *MockRepository) UpdateRefill(ctx context.Context, clientID string, rate int, interval time.Duration) error {
+    args := m.Called(ctx, clientID, rate, interval)
+    return args.Error(0)
+}
+func (m *MockRepository) UpdateCost(ctx context.Context, clientID string, cost int) error {
+    args := m.Called(ctx, clientID, cost)
+    return args.Error(0)
+}
 func (m *MockRepository) GetBucket(ctx context.Context, clientID string) (*models.Bucket, error) {
     args := m.Called(ctx, clientID)
     return args.Get(0).(*models.Bucket), args.Error(1)
@@ -50,13 +59,13 @@ func (m *MockRepository) ListBuckets(ctx context.Context, limit, offset int) (*[
     args := m.Called(ctx, limit, offset)
     return args.Get(0).(*[]models.Bucket), args.Error(1)
 }
-func (m *MockRepository) TryConsume(ctx context.Context, clientID string) error {
-    args := m.Called(ctx, clientID)
-    return args.Error(0)
+func (m *MockRepository) TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error) {
+    args := m.Called(ctx, clientID, cost)
+    return args.Get(0).(*models.ConsumeResult), args.Error(1)
 }
-func (m *MockRepository) RefillTokens(ctx context.Context, clientID string, amount int) error {
-    args := m.Called(ctx, clientID, amount)
-    return args.Error(0)
+func (m *MockRepository) TryConsumeAll(ctx context.Context, reqs []models.ConsumeRequest) (*models.ConsumeResult, error) {
+    args := m.Called(ctx, reqs)
+    return args.Get(0).(*models.ConsumeResult), args.Error(1)
 }
 
 func TestMain(m *testing.M) {
@@ -107,6 +116,26 @@ func TestBucketService(t *testing.T) {
         mockRepo.AssertNotCalled(t, "CreateBucket", mock.Anything, mock.Anything)
     })
 
+    t.Run("UpdateRefill", func(t *testing.T) {
+        mockRepo := new(MockRepository)
+        svc := NewBucketService(cfg, mockRepo)
+
+        mockRepo.
+            On("UpdateRefill", ctx, "paid", 5, 10*time.Second).
+            Return(nil).
+            Once()
+
+        require.NoError(t, svc.UpdateRefill(ctx, "paid", 5, 10*time.Second))
+
+        // неверные значения до репозитория не доходят
+        require.ErrorIs(t, svc.UpdateRefill(ctx, "paid", -1, 0), errdefs.ErrInvalidInput)
+        require.ErrorIs(t, svc.UpdateRefill(ctx, "paid", 1, -time.Second), errdefs.ErrInvalidInput)
+        require.ErrorIs(t, svc.UpdateRefill(ctx, "paid", 1, 1500*time.Microsecond), errdefs.ErrInvalidInput)
+        require.ErrorIs(t, svc.CreateBucket(ctx, &models.Bucket{ClientID: "c", Capacity: 1, RefillRate: -1}), errdefs.ErrInvalidInput)
+
+        mockRepo.AssertExpectations(t)
+    })
+
     t.Run("DeleteBucketErr", func(t *testing.T) {
         mockRepo := new(MockRepository)
         svc := NewBucketService(cfg, mockRepo)
@@ -164,61 +193,175 @@ func TestTryConsume(t *testing.T) {
     ctx := context.Background()
     ctx, _ = logger.New(ctx, cfg)
 
-   t.Run("CreatesBucketOnFirstConsume", func(t *testing.T) {
-       mockRepo := new(MockRepository)
-       svc := NewBucketService(cfg, mockRepo)
-
-       mockRepo.On("GetBucket", ctx, "client1").Return((*models.Bucket)(nil), errdefs.ErrNotFound).Once()
-       mockRepo.On("CreateBucket", ctx, mock.MatchedBy(func(b *models.Bucket) bool {
-           return b.ClientID == "client1" && b.Tokens == cfg.Bucket.Capacity-1
-       })).Return(nil).Once()
-
-       err := svc.TryConsume(ctx, "client1")
-       require.NoError(t, err)
-       mockRepo.AssertExpectations(t)
-   })
-
-   t.Run("NoRefillAndConsume", func(t *testing.T) {
-       mockRepo := new(MockRepository)
-       svc := NewBucketService(cfg, mockRepo)
-
-       bucket := &models.Bucket{ClientID: "c2", Capacity: 5, Tokens: 3, LastRefill: time.Now()}
-       mockRepo.On("GetBucket", ctx, "c2").Return(bucket, nil).Once()
-       mockRepo.On("TryConsume", ctx, "c2").Return(nil).Once()
-
-       err := svc.TryConsume(ctx, "c2")
-       require.NoError(t, err)
-       mockRepo.AssertExpectations(t)
-   })
-
-   t.Run("RefillThenConsume", func(t *testing.T) {
-       mockRepo := new(MockRepository)
-       svc := NewBucketService(cfg, mockRepo)
-
-       past := time.Now().Add(
-            -2 * time.Duration(cfg.Bucket.Refill.Interval),
-        )
-       bucket := &models.Bucket{ClientID: "c3", Capacity: 10, Tokens: 1, LastRefill: past}
-       mockRepo.On("GetBucket", ctx, "c3").Return(bucket, nil).Once()
-       expectedAmount := 2 * cfg.Bucket.Refill.Amount
-       mockRepo.On("RefillTokens", ctx, "c3", expectedAmount).Return(nil).Once()
-       mockRepo.On("TryConsume", ctx, "c3").Return(nil).Once()
-
-       err := svc.TryConsume(ctx, "c3")
-       require.NoError(t, err)
-       mockRepo.AssertExpectations(t)
-   })
-
-   t.Run("InsufficientTokens", func(t *testing.T) {
-       mockRepo := new(MockRepository)
-       svc := NewBucketService(cfg, mockRepo)
-
-       bucket := &models.Bucket{ClientID: "c4", Capacity: 5, Tokens: 0, LastRefill: time.Now()}
-       mockRepo.On("GetBucket", ctx, "c4").Return(bucket, nil).Once()
-       mockRepo.On("TryConsume", ctx, "c4").Return(errdefs.NotEnoughTokens).Once()
-
-       err := svc.TryConsume(ctx, "c4")
-       require.ErrorIs(t, err, errdefs.NotEnoughTokens)
-       mockRepo.AssertExpectations(t)
-   })
+    t.Run("Consume", func(t *testing.T) {
+        mockRepo := new(MockRepository)
+        svc := NewBucketService(cfg, mockRepo)
+
+        // пополнение и создание бакета теперь целиком на стороне репозитория
+        res := &models.ConsumeResult{Allowed: true, Tokens: 4, Capacity: 5}
+        mockRepo.On("TryConsume", ctx, "c2", 1).Return(res, nil).Once()
+
+        got, err := svc.TryConsume(ctx, "c2", 1)
+        require.NoError(t, err)
+        require.Equal(t, res, got)
+        mockRepo.AssertExpectations(t)
+        mockRepo.AssertNumberOfCalls(t, "TryConsume", 1)
+        mockRepo.AssertNotCalled(t, "GetBucket", mock.Anything, mock.Anything)
+    })
+
+    t.Run("InsufficientTokens", func(t *testing.T) {
+        mockRepo := new(MockRepository)
+        svc := NewBucketService(cfg, mockRepo)
+
+        res := &models.ConsumeResult{Capacity: 5, NextToken: 30 * time.Second}
+        mockRepo.On("TryConsume", ctx, "c4", 1).Return(res, errdefs.NotEnoughTokens).Once()
+
+        got, err := svc.TryConsume(ctx, "c4", 1)
+        require.ErrorIs(t, err, errdefs.ErrRateLimitExceeded)
+        require.Equal(t, res, got, "состояние нужно прокси для Retry-After")
+        mockRepo.AssertExpectations(t)
+    })
+
+    t.Run("WeightedCost", func(t *testing.T) {
+        mockRepo := new(MockRepository)
+        svc := NewBucketService(cfg, mockRepo)
+
+        // стоимость уходит в репозиторий как есть, списание атомарно там
+        res := &models.ConsumeResult{Tokens: 3, Capacity: 10, NextToken: time.Second}
+        mockRepo.On("TryConsume", ctx, "export", 100).Return(res, errdefs.NotEnoughTokens).Once()
+
+        _, err := svc.TryConsume(ctx, "export", 100)
+
+        require.ErrorIs(t, err, errdefs.ErrRateLimitExceeded)
+        _, err = svc.TryConsume(ctx, "export", 0)
+        require.ErrorIs(t, err, errdefs.ErrInvalidInput)
+        mockRepo.AssertExpectations(t)
+    })
+
+    t.Run("RepositoryError", func(t *testing.T) {
+        mockRepo := new(MockRepository)
+        svc := NewBucketService(cfg, mockRepo)
+
+        mockRepo.On("TryConsume", ctx, "c5", 1).Return((*models.ConsumeResult)(nil), errdefs.ErrDB).Once()
+
+        got, err := svc.TryConsume(ctx, "c5", 1)
+        require.ErrorIs(t, err, errdefs.ErrDB)
+        require.Nil(t, got)
+        mockRepo.AssertExpectations(t)
+    })
+}
+
+type MockLimiter struct {
+    mock.Mock
+}
+
+func (m *MockLimiter) TryConsume(ctx context.Context, clientID string, cost int) (*models.ConsumeResult, error) {
+    args := m.Called(ctx, clientID, cost)
+    return args.Get(0).(*models.ConsumeResult), args.Error(1)
+}
+
+func TestTryConsumeAlgorithms(t *testing.T) {
+    ctx := context.Background()
+    ctx, _ = logger.New(ctx, cfg)
+
+    acfg := *cfg
+    acfg.Bucket.Algorithm = config.AlgorithmGCRA
+    acfg.Bucket.ClientAlgorithms = map[string]string{
+        "legacy": config.AlgorithmTokenBucket,
+        "queued": config.AlgorithmLeakyBucket,
+    }
+
+    t.Run("GlobalAndOverride", func(t *testing.T) {
+        mockRepo, gcra := new(MockRepository), new(MockLimiter)
+        svc := NewBucketService(&acfg, mockRepo).WithLimiters(map[string]interfaces.ILimiter{
+            config.AlgorithmGCRA: gcra,
+        })
+
+        gcra.On("TryConsume", ctx, "c1", 2).Return(&models.ConsumeResult{Allowed: true}, nil).Once()
+        mockRepo.On("TryConsume", ctx, "legacy", 2).Return(&models.ConsumeResult{Allowed: true}, nil).Once()
+
+        _, err := svc.TryConsume(ctx, "c1", 2)
+
+        require.NoError(t, err)
+        _, err = svc.TryConsume(ctx, "legacy", 2)
+        require.NoError(t, err)
+        gcra.AssertExpectations(t)
+        mockRepo.AssertExpectations(t)
+    })
+
+    t.Run("LimiterNotAvailable", func(t *testing.T) {
+        svc := NewBucketService(&acfg, new(MockRepository))
+        _, err := svc.TryConsume(ctx, "c1", 1)
+        require.ErrorIs(t, err, errdefs.ErrInvalidInput)
+    })
+
+    t.Run("LeakyBucketWaits", func(t *testing.T) {
+        leaky := new(MockLimiter)
+        svc := NewBucketService(&acfg, new(MockRepository)).WithLimiters(map[string]interfaces.ILimiter{
+            config.AlgorithmLeakyBucket: leaky,
+        })
+        res := &models.ConsumeResult{Allowed: true, Delay: 50 * time.Millisecond}
+        leaky.On("TryConsume", mock.Anything, "queued", 1).Return(res, nil)
+
+        start := time.Now()
+        _, err := svc.TryConsume(ctx, "queued", 1)
+        require.NoError(t, err)
+        require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+        // клиент ушёл, пока запрос стоял в очереди
+        cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+        defer cancel()
+        _, err = svc.TryConsume(cctx, "queued", 1)
+        require.ErrorIs(t, err, context.DeadlineExceeded)
+    })
+}
+
+func TestTryConsumePolicies(t *testing.T) {
+    ctx := context.Background()
+    ctx, _ = logger.New(ctx, cfg)
+
+    pcfg := *cfg
+    pcfg.Bucket.Algorithm = config.AlgorithmGCRA
+    pcfg.Bucket.Refill = config.RefillConfig{Amount: 1, Interval: config.Duration(time.Minute)}
+    pcfg.Bucket.Policies = []config.PolicyConfig{
+        {Name: "search", Capacity: 5, Refill: config.RefillConfig{Amount: 2}},
+        {Name: "all", Capacity: 100, Refill: config.RefillConfig{Amount: 10, Interval: config.Duration(time.Second)}},
+    }
+
+    t.Run("AllPoliciesAtOnce", func(t *testing.T) {
+        mockRepo := new(MockRepository)
+        // алгоритм клиента к бакетам политик не относится, limiters не нужны
+        svc := NewBucketService(&pcfg, mockRepo)
+
+        reqs := []models.ConsumeRequest{
+            {ClientID: "policy:search:c1", Cost: 3, Capacity: 5, RefillAmount: 2, RefillInterval: time.Minute},
+            {ClientID: "policy:all:c1", Cost: 3, Capacity: 100, RefillAmount: 10, RefillInterval: time.Second},
+        }
+        res := &models.ConsumeResult{Allowed: true, Tokens: 2, Capacity: 5}
+        mockRepo.On("TryConsumeAll", ctx, reqs).Return(res, nil).Once()
+
+        got, err := svc.TryConsume(ctx, "c1", 3, "search", "all")
+        require.NoError(t, err)
+        require.Equal(t, res, got)
+        mockRepo.AssertExpectations(t)
+        mockRepo.AssertNotCalled(t, "TryConsume", mock.Anything, mock.Anything, mock.Anything)
+    })
+
+    t.Run("Rejected", func(t *testing.T) {
+        mockRepo := new(MockRepository)
+        svc := NewBucketService(&pcfg, mockRepo)
+
+        res := &models.ConsumeResult{Capacity: 5, RetryAfter: time.Minute}
+        mockRepo.On("TryConsumeAll", ctx, mock.Anything).Return(res, errdefs.NotEnoughTokens).Once()
+
+        got, err := svc.TryConsume(ctx, "c1", 1, "search")
+        require.ErrorIs(t, err, errdefs.ErrRateLimitExceeded)
+        require.Equal(t, res, got)
+    })
+
+    t.Run("UnknownPolicy", func(t *testing.T) {
+        svc := NewBucketService(&pcfg, new(MockRepository))
+        _, err := svc.TryConsume(ctx, "c1", 1, "missing")
+        require.ErrorIs(t, err, errdefs.ErrInvalidInput)
+    })
 }
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// handleListBackends обрабатывает GET /backends
+func (h *Handler) handleListBackends() http.Handler {
+	ctx := GenerateRequestID(h.ctx)
+	logger := logger.GetLoggerFromCtx(ctx)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Info(ctx, "incoming request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+		)
+
+		backends := h.backends.ListBackends()
+
+		logger.Info(ctx, "listed backends", zap.Int("returned", len(backends)))
+		encode(w, r, http.StatusOK, backends)
+	})
+}
+
+// handleGetBackend обрабатывает GET /backends/{id}
+func (h *Handler) handleGetBackend() http.Handler {
+	ctx := GenerateRequestID(h.ctx)
+	logger := logger.GetLoggerFromCtx(ctx)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Info(ctx, "incoming request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+		)
+
+		id := r.URL.Path[len("/backends/"):]
+		if id == "" {
+			logger.Info(ctx, "backend id missing")
+			http.Error(w, "backend id required", http.StatusBadRequest)
+			return
+		}
+		backend, err := h.backends.GetBackend(id)
+		if err != nil {
+			handleServiceError(ctx, w, err)
+			return
+		}
+
+		logger.Info(ctx, "fetched backend", zap.String("id", id))
+		encode(w, r, http.StatusOK, backend)
+	})
+}
+
+// handleAddBackend обрабатывает POST /backends. Тело - бэкенд в том же виде,
+// что и в balancer.backends конфига, включая healthCheck и tls
+func (h *Handler) handleAddBackend() http.Handler {
+	ctx := GenerateRequestID(h.ctx)
+	logger := logger.GetLoggerFromCtx(ctx)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Info(ctx, "incoming request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+		)
+
+		backend, err := decode[config.BackendConfig](r)
+		if err != nil {
+			logger.Info(ctx, "invalid JSON payload", zap.Error(err))
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		// Add проверяет бэкенд через Validate и подставляет id по умолчанию
+		if err := h.registry.Add(backend); err != nil {
+			handleServiceError(ctx, w, err)
+			return
+		}
+		if added, ok := h.registry.Lookup(backend.URL); ok {
+			backend.ID = added.ID
+		}
+		status, err := h.backends.GetBackend(backend.ID)
+		if err != nil {
+			handleServiceError(ctx, w, err)
+			return
+		}
+
+		logger.Info(ctx, "backend added",
+			zap.String("id", status.ID),
+			zap.String("url", status.URL),
+			zap.Int("weight", status.Weight),
+		)
+		encode(w, r, http.StatusCreated, status)
+	})
+}
+
+// handleRemoveBackend обрабатывает DELETE /backends/{id}
+func (h *Handler) handleRemoveBackend() http.Handler {
+	ctx := GenerateRequestID(h.ctx)
+	logger := logger.GetLoggerFromCtx(ctx)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Info(ctx, "incoming request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+		)
+
+		id := r.URL.Path[len("/backends/"):]
+		backend, err := h.registry.Remove(id)
+		if err != nil {
+			handleServiceError(ctx, w, err)
+			return
+		}
+
+		logger.Info(ctx, "backend removed", zap.String("id", id), zap.String("url", backend.URL))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// handleDrainBackend обрабатывает POST /backends/{id}/drain (drain == true)
+// и POST /backends/{id}/enable. Начатые запросы к бэкенду доходят до конца
+func (h *Handler) handleDrainBackend(drain bool) http.Handler {
+	ctx := GenerateRequestID(h.ctx)
+	logger := logger.GetLoggerFromCtx(ctx)
+	action, msg := "enable", "backend enabled"
+	if drain {
+		action, msg = "drain", "backend draining"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Info(ctx, "incoming request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+		)
+
+		id := strings.TrimSuffix(r.URL.Path[len("/backends/"):], "/"+action)
+		if _, err := h.registry.SetDraining(id, drain); err != nil {
+			handleServiceError(ctx, w, err)
+			return
+		}
+		status, err := h.backends.GetBackend(id)
+		if err != nil {
+			handleServiceError(ctx, w, err)
+			return
+		}
+
+		logger.Info(ctx, msg,
+			zap.String("id", id),
+			zap.Int("in_flight", status.InFlight),
+		)
+		encode(w, r, http.StatusOK, status)
+	})
+}
@@ -12,6 +12,8 @@ import (
 	"encoding/json"
 	"net/http"
     "strconv"
+    "strings"
+    "time"
 
 	"go.uber.org/zap"
     "github.com/google/uuid"
@@ -21,11 +23,15 @@ type Handler struct {
 	ctx context.Context
     cfg *config.Config
 	bsrv interfaces.IBucketService
+    backends interfaces.IBackendMonitor
+    registry interfaces.IBackendRegistry
 }
 
-func NewHandler(ctx context.Context, cfg *config.Config, bsrv interfaces.IBucketService) *Handler {
+func NewHandler(ctx context.Context, cfg *config.Config, bsrv interfaces.IBucketService, backends interfaces.IBackendMonitor, registry interfaces.IBackendRegistry) *Handler {
 	return &Handler{
 		bsrv: bsrv,
+        backends: backends,
+        registry: registry,
 		ctx: ctx,
         cfg: cfg,
 	}
@@ -204,6 +210,77 @@ func (h *Handler) handleUpdateTokens() http.Handler {
     })
 }
 
+// handleUpdateRefill обрабатывает PUT /buckets/{id}/refill.
+// Нулевые или пропущенные поля возвращают значения из конфига
+func (h *Handler) handleUpdateRefill() http.Handler {
+    ctx := GenerateRequestID(h.ctx)
+    logger := logger.GetLoggerFromCtx(ctx)
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        logger.Info(ctx, "incoming request",
+            zap.String("method", r.Method),
+            zap.String("path", r.URL.Path),
+        )
+
+        clientID := strings.TrimSuffix(r.URL.Path[len("/buckets/"):], "/refill")
+        payload, err := decode[struct {
+            RefillRate     int             `json:"refill_rate"`
+            RefillInterval models.Duration `json:"refill_interval"`
+        }](r)
+        if err != nil {
+            logger.Info(ctx, "invalid JSON payload", zap.Error(err))
+            http.Error(w, "Bad Request", http.StatusBadRequest)
+            return
+        }
+        interval := time.Duration(payload.RefillInterval)
+        if err := h.bsrv.UpdateRefill(ctx, clientID, payload.RefillRate, interval); err != nil {
+            handleServiceError(ctx, w, err)
+            return
+        }
+
+        logger.Info(ctx, "refill updated",
+            zap.String("client_id", clientID),
+            zap.Int("refill_rate", payload.RefillRate),
+            zap.Duration("refill_interval", interval),
+        )
+        w.WriteHeader(http.StatusNoContent)
+    })
+}
+
+// handleUpdateCost обрабатывает PUT /buckets/{id}/cost.
+// Нулевая стоимость возвращает расчёт по правилам bucket.cost
+func (h *Handler) handleUpdateCost() http.Handler {
+    ctx := GenerateRequestID(h.ctx)
+    logger := logger.GetLoggerFromCtx(ctx)
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        logger.Info(ctx, "incoming request",
+            zap.String("method", r.Method),
+            zap.String("path", r.URL.Path),
+        )
+
+        clientID := strings.TrimSuffix(r.URL.Path[len("/buckets/"):], "/cost")
+        payload, err := decode[struct {
+            Cost int `json:"cost"`
+        }](r)
+        if err != nil {
+            logger.Info(ctx, "invalid JSON payload", zap.Error(err))
+            http.Error(w, "Bad Request", http.StatusBadRequest)
+            return
+        }
+        if err := h.bsrv.UpdateCost(ctx, clientID, payload.Cost); err != nil {
+            handleServiceError(ctx, w, err)
+            return
+        }
+
+        logger.Info(ctx, "cost updated",
+            zap.String("client_id", clientID),
+            zap.Int("cost", payload.Cost),
+        )
+        w.WriteHeader(http.StatusNoContent)
+    })
+}
+
 // handleDeleteBucket обрабатывает DELETE /clients/{id}
 func (h *Handler) handleDeleteBucket() http.Handler {
     ctx := GenerateRequestID(h.ctx)
@@ -2,6 +2,7 @@ package api
 
 import (
     "net/http"
+    "strings"
 )
 
 func NewRouter(h *Handler) http.Handler {
@@ -20,20 +21,54 @@ func NewRouter(h *Handler) http.Handler {
     })
 
     // /buckets/{id} — GET, PUT, PATCH, DELETE
+    // /buckets/{id}/refill, /buckets/{id}/cost — PUT
     mux.HandleFunc("/buckets/", func(w http.ResponseWriter, r *http.Request) {
-        switch r.Method {
-        case http.MethodGet:
+        switch {
+        case strings.HasSuffix(r.URL.Path, "/refill") && r.Method == http.MethodPut:
+            h.handleUpdateRefill().ServeHTTP(w, r)
+        case strings.HasSuffix(r.URL.Path, "/cost") && r.Method == http.MethodPut:
+            h.handleUpdateCost().ServeHTTP(w, r)
+        case r.Method == http.MethodGet:
             h.handleGetBucket().ServeHTTP(w, r)
-        case http.MethodPut:
+        case r.Method == http.MethodPut:
             h.handleUpdateCapacity().ServeHTTP(w, r)
-        case http.MethodPatch:
+        case r.Method == http.MethodPatch:
             h.handleUpdateTokens().ServeHTTP(w, r)
-        case http.MethodDelete:
+        case r.Method == http.MethodDelete:
             h.handleDeleteBucket().ServeHTTP(w, r)
         default:
             http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
         }
     })
 
+    // /backends — состояние пула бэкендов, POST добавляет бэкенд
+    mux.HandleFunc("/backends", func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            h.handleListBackends().ServeHTTP(w, r)
+        case http.MethodPost:
+            h.handleAddBackend().ServeHTTP(w, r)
+        default:
+            http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    // /backends/{id} — GET, DELETE
+    // /backends/{id}/drain, /backends/{id}/enable — POST
+    mux.HandleFunc("/backends/", func(w http.ResponseWriter, r *http.Request) {
+        switch {
+        case strings.HasSuffix(r.URL.Path, "/drain") && r.Method == http.MethodPost:
+            h.handleDrainBackend(true).ServeHTTP(w, r)
+        case strings.HasSuffix(r.URL.Path, "/enable") && r.Method == http.MethodPost:
+            h.handleDrainBackend(false).ServeHTTP(w, r)
+        case r.Method == http.MethodGet:
+            h.handleGetBackend().ServeHTTP(w, r)
+        case r.Method == http.MethodDelete:
+            h.handleRemoveBackend().ServeHTTP(w, r)
+        default:
+            http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
     return mux
 }
\ No newline at end of file
@@ -2,31 +2,228 @@ package http
 
 import (
     "context"
+    "fmt"
+    "io"
     "net"
     "net/http"
+    "net/url"
+    "strings"
     "time"
     "sync"
 
     "gopher-equalizer/internal/interfaces"
     "gopher-equalizer/internal/logger"
+    "gopher-equalizer/internal/models"
     "gopher-equalizer/config"
     "go.uber.org/zap"
 )
 
+const (
+	defaultRise = 2
+	defaultFall = 3
+)
+
+// backendState - состояние бэкенда между проверками.
+// Бэкенд меняет состояние только после rise успешных
+// или fall неудачных проверок подряд
+type backendState struct {
+	healthy   bool
+	successes int // успешных проверок подряд
+	failures  int // неудачных проверок подряд
+	since     time.Time // время последней смены состояния
+	lastProbe time.Time
+	lastErr   error
+}
+
+// record учитывает результат проверки и возвращает true, если состояние сменилось
+func (s *backendState) record(err error, now time.Time, rise, fall int) bool {
+	s.lastProbe = now
+	s.lastErr = err
+
+	if err != nil {
+		s.successes = 0
+		s.failures++
+		if s.healthy && s.failures >= fall {
+			s.healthy = false
+			s.since = now
+			return true
+		}
+		return false
+	}
+
+	s.failures = 0
+	s.successes++
+	if !s.healthy && s.successes >= rise {
+		s.healthy = true
+		s.since = now
+		return true
+	}
+	return false
+}
+
 type HealthChecker struct {
 	mu sync.RWMutex
 	cfg *config.Config
 	balancer interfaces.IBalancer
+	// registry - текущий состав пула, может меняться через admin API
+	registry interfaces.IBackendRegistry
+	states map[string]*backendState
+	// outliers - пассивная проверка по живому трафику, nil если выключена
+	outliers *OutlierDetector
+	// readmit - таймеры возврата выкинутых бэкендов в пул. После остановки
+	// проверок (stopped) новые не заводятся
+	readmitMu sync.Mutex
+	readmit map[string]*time.Timer
+	stopped bool
+	// probe - проверка одного бэкенда, подменяется в тестах
+	probe func(backend config.BackendConfig, check config.HealthCheckConfig) error
+	// клиенты для проверок по бэкендам, у https-бэкендов свой TLS
+	clientsMu sync.Mutex
+	clients map[string]*http.Client
 }
 
-func NewHealthChecker(cfg *config.Config, balancer interfaces.IBalancer) *HealthChecker {
-	return &HealthChecker{
+func NewHealthChecker(cfg *config.Config, balancer interfaces.IBalancer, registry interfaces.IBackendRegistry) *HealthChecker {
+	// Стратегия создаётся со всеми бэкендами из конфига,
+	// поэтому изначально все они считаются живыми
+	now := time.Now()
+	backends := registry.Backends()
+	states := make(map[string]*backendState, len(backends))
+	for _, b := range backends {
+		states[b.URL] = &backendState{healthy: true, since: now}
+	}
+
+	hc := &HealthChecker{
 		cfg: cfg,
 		balancer: balancer,
+		registry: registry,
+		states: states,
+		clients: make(map[string]*http.Client),
+		readmit: make(map[string]*time.Timer),
+	}
+	hc.probe = hc.checkBackend
+	if cfg.Proxy.OutlierDetection.Enabled {
+		hc.outliers = NewOutlierDetector(cfg.Proxy.OutlierDetection, registry.Len)
+	}
+	registry.Subscribe(hc.sync)
+	return hc
+}
+
+// sync подстраивает состояния под изменившийся пул и сразу публикует его
+func (hc *HealthChecker) sync() {
+	backends := hc.registry.Backends()
+	rise, _ := hc.thresholds()
+	now := time.Now()
+
+	hc.mu.Lock()
+	current := make(map[string]bool, len(backends))
+	for _, b := range backends {
+		current[b.URL] = true
+		if _, ok := hc.states[b.URL]; ok {
+			continue
+		}
+		// добавленный на лету бэкенд ещё не проверялся,
+		// в пул его пускает первая же удачная проверка
+		hc.states[b.URL] = &backendState{successes: rise - 1, since: now}
+		hc.balancer.SetWeight(b.URL, b.Weight)
+	}
+	var removed []string
+	for url := range hc.states {
+		if !current[url] {
+			delete(hc.states, url)
+			removed = append(removed, url)
+		}
+	}
+	hc.mu.Unlock()
+
+	hc.clientsMu.Lock()
+	for _, url := range removed {
+		if c, ok := hc.clients[url]; ok {
+			c.CloseIdleConnections()
+			delete(hc.clients, url)
+		}
+	}
+	hc.clientsMu.Unlock()
+	if hc.outliers != nil {
+		for _, url := range removed {
+			hc.outliers.Forget(url)
+			hc.cancelReadmit(url)
+		}
+	}
+
+	hc.publish()
+}
+
+// ReportResult получает от прокси результат запроса к бэкенду
+// (код ответа или ошибку транспорта) для пассивной проверки
+func (hc *HealthChecker) ReportResult(ctx context.Context, backend string, status int, err error) {
+	if hc.outliers == nil {
+		return
+	}
+
+	d, reason := hc.outliers.Report(backend, status, err, time.Now())
+	if d == 0 {
+		return
+	}
+
+	logger := logger.GetLoggerFromCtx(ctx)
+	logger.Info(ctx, "OUTLIER: backend ejected",
+		zap.String("backend", backend),
+		zap.String("reason", reason),
+		zap.Duration("duration", d),
+	)
+
+	// контекст запроса скоро отменят, а он нужен ещё для логгера
+	ctx = context.WithoutCancel(ctx)
+	hc.publish()
+
+	hc.readmitMu.Lock()
+	defer hc.readmitMu.Unlock()
+	if hc.stopped {
+		return
+	}
+	if t, ok := hc.readmit[backend]; ok {
+		t.Stop()
 	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		hc.readmitMu.Lock()
+		// таймер успели отменить или заменить, пока он срабатывал
+		current := hc.readmit[backend] == t
+		if current {
+			delete(hc.readmit, backend)
+		}
+		hc.readmitMu.Unlock()
+		if !current {
+			return
+		}
+		logger.Info(ctx, "OUTLIER: backend returned to pool", zap.String("backend", backend))
+		hc.publish()
+	})
+	hc.readmit[backend] = t
 }
 
-func (hc HealthChecker) StartHealthChecks(ctx context.Context) {
+// cancelReadmit отменяет возврат в пул бэкенда, убранного из него
+func (hc *HealthChecker) cancelReadmit(backend string) {
+	hc.readmitMu.Lock()
+	defer hc.readmitMu.Unlock()
+	if t, ok := hc.readmit[backend]; ok {
+		t.Stop()
+		delete(hc.readmit, backend)
+	}
+}
+
+// stopReadmits отменяет все возвраты в пул при остановке проверок
+func (hc *HealthChecker) stopReadmits() {
+	hc.readmitMu.Lock()
+	defer hc.readmitMu.Unlock()
+	hc.stopped = true
+	for backend, t := range hc.readmit {
+		t.Stop()
+		delete(hc.readmit, backend)
+	}
+}
+
+func (hc *HealthChecker) StartHealthChecks(ctx context.Context) {
 	interval := time.Duration(hc.cfg.Proxy.HealthChecker.Interval)
 	ticker := time.NewTicker(interval)    
 
@@ -36,6 +233,7 @@ func (hc HealthChecker) StartHealthChecks(ctx context.Context) {
             select {
             	// У нас есть Gracefull Shutdown
             case <-ctx.Done():
+                hc.stopReadmits()
                 return
             case <-ticker.C:
                 hc.runOnce(ctx)
@@ -46,45 +244,321 @@ func (hc HealthChecker) StartHealthChecks(ctx context.Context) {
 
 func (hc *HealthChecker) runOnce(ctx context.Context) {
 	logger := logger.GetLoggerFromCtx(ctx)
+	rise, fall := hc.thresholds()
 
-	alive := make(
-		[]string,
-		0,
-		len(hc.cfg.Balancer.Backends),
-	)
+    // Проверяем без блокировки, чтобы не держать мьютекс на время сетевых
+    // запросов, и параллельно: раунд занимает примерно один timeout,
+    // а не сумму таймаутов всех мёртвых бэкендов
+    backends := hc.registry.Backends()
+    results := make([]error, len(backends))
+    limit := hc.cfg.Proxy.HealthChecker.Concurrency
+    if limit <= 0 {
+        limit = len(backends)
+    }
+    sem := make(chan struct{}, max(limit, 1))
+    var wg sync.WaitGroup
+    for i, backend := range backends {
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(i int, backend config.BackendConfig) {
+            defer wg.Done()
+            defer func() { <-sem }()
+            results[i] = hc.probe(backend, hc.cfg.Proxy.HealthChecker.Check(backend))
+        }(i, backend)
+    }
+    wg.Wait()
 
-    for _, addr := range hc.cfg.Balancer.Backends {
-        if checkOne(
-        	addr,
-        	time.Duration(hc.cfg.Proxy.HealthChecker.HealthCheckTimeout),
-        ) {
-            alive = append(alive, addr)
-        } else {
-            logger.Info(ctx, "HEALTH-CHECK: failed", zap.String("backend", addr))
+    hc.mu.Lock()
+    for i, backend := range backends {
+        err := results[i]
+        st, ok := hc.states[backend.URL]
+        if !ok {
+            // бэкенд удалили, пока шла проверка
+            continue
+        }
+        if st.record(err, time.Now(), rise, fall) {
+            if st.healthy {
+                logger.Info(ctx, "HEALTH-CHECK: backend is up",
+                    zap.String("backend", backend.URL),
+                    zap.String("reason", fmt.Sprintf("%d successful probes in a row", st.successes)),
+                )
+            } else {
+                logger.Info(ctx, "HEALTH-CHECK: backend is down",
+                    zap.String("backend", backend.URL),
+                    zap.String("reason", fmt.Sprintf("%d failed probes in a row", st.failures)),
+                    zap.Error(err),
+                )
+            }
+        } else if err != nil {
+            logger.Info(ctx, "HEALTH-CHECK: failed",
+                zap.String("backend", backend.URL),
+                zap.Int("failures", st.failures),
+                zap.Error(err),
+            )
         }
+
     }
+    hc.mu.Unlock()
 
+    alive := hc.publish()
     logger.Info(ctx, "HEALTH-CHECK: result",
         zap.Int("alive", len(alive)),
-        zap.Int("total", len(hc.cfg.Balancer.Backends)),
+        zap.Int("total", len(backends)),
     )
+}
+
+// publish отдаёт балансировщику бэкенды, живые по активной проверке,
+// не выкинутые пассивной и не выведенные из ротации. Мьютекс держится и на время ResetBackends,
+// чтобы параллельные вызовы не перезаписали пул устаревшим списком
+func (hc *HealthChecker) publish() []string {
+    hc.mu.Lock()
+    defer hc.mu.Unlock()
+
+    now := time.Now()
+    backends := hc.registry.Backends()
+    alive := make([]string, 0, len(backends))
+    for _, backend := range backends {
+        if hc.registry.IsDraining(backend.URL) {
+            continue
+        }
+        if st, ok := hc.states[backend.URL]; ok && !st.healthy {
+            continue
+        }
+        if hc.outliers != nil && hc.outliers.IsEjected(backend.URL, now) {
+            continue
+        }
+        alive = append(alive, backend.URL)
+    }
+
+    hc.balancer.ResetBackends(alive)
+    return alive
+}
+
+// ListBackends возвращает состояние всех бэкендов пула в порядке добавления
+func (hc *HealthChecker) ListBackends() []models.BackendStatus {
+    hc.mu.RLock()
+    defer hc.mu.RUnlock()
+
+    now := time.Now()
+    backends := hc.registry.Backends()
+    list := make([]models.BackendStatus, 0, len(backends))
+    for _, backend := range backends {
+        list = append(list, hc.status(backend, now))
+    }
+    return list
+}
+
+func (hc *HealthChecker) GetBackend(id string) (*models.BackendStatus, error) {
+    hc.mu.RLock()
+    defer hc.mu.RUnlock()
 
-  	hc.balancer.ResetBackends(alive)
+    backend, err := hc.registry.Get(id)
+    if err != nil {
+        return nil, err
+    }
+    st := hc.status(backend, time.Now())
+    return &st, nil
+}
+
+// status собирает состояние бэкенда, hc.mu уже захвачен
+func (hc *HealthChecker) status(backend config.BackendConfig, now time.Time) models.BackendStatus {
+    bs := models.BackendStatus{
+        ID:       backend.ID,
+        URL:      backend.URL,
+        State:    models.BackendUp,
+        Weight:   backend.Weight,
+        InFlight: hc.balancer.InFlight(backend.URL),
+        Draining: hc.registry.IsDraining(backend.URL),
+    }
+
+    if st, ok := hc.states[backend.URL]; ok {
+        bs.StateSince = st.since
+        bs.LastProbe = st.lastProbe
+        bs.ConsecutiveFailures = st.failures
+        if st.lastErr != nil {
+            bs.LastError = st.lastErr.Error()
+        }
+        if !st.healthy {
+            bs.State = models.BackendDown
+        }
+    }
+    if bs.State == models.BackendUp && hc.outliers != nil {
+        if since, ok := hc.outliers.EjectedSince(backend.URL, now); ok {
+            bs.State = models.BackendEjected
+            bs.StateSince = since
+        }
+    }
+    if !bs.StateSince.IsZero() {
+        bs.TimeInState = now.Sub(bs.StateSince).Round(time.Second).String()
+    }
+    return bs
+}
+
+func (hc *HealthChecker) thresholds() (rise, fall int) {
+    rise, fall = hc.cfg.Proxy.HealthChecker.Rise, hc.cfg.Proxy.HealthChecker.Fall
+    if rise <= 0 {
+        rise = defaultRise
+    }
+    if fall <= 0 {
+        fall = defaultFall
+    }
+    return rise, fall
+}
+
+// сколько тела ответа читаем для проверки bodyContains
+const maxHealthBody = 64 << 10
+
+// checkBackend проверяет бэкенд клиентом с его настройками TLS
+func (hc *HealthChecker) checkBackend(backend config.BackendConfig, check config.HealthCheckConfig) error {
+    client, err := hc.client(backend)
+    if err != nil {
+        return err
+    }
+    return checkOne(client, backend.URL, check)
+}
+
+// client возвращает (и кеширует) http-клиент для бэкенда
+func (hc *HealthChecker) client(backend config.BackendConfig) (*http.Client, error) {
+    hc.clientsMu.Lock()
+    defer hc.clientsMu.Unlock()
+
+    if c, ok := hc.clients[backend.URL]; ok {
+        return c, nil
+    }
+    tlsCfg, err := backend.TLS.Build()
+    if err != nil {
+        return nil, fmt.Errorf("tls config: %w", err)
+    }
+    c := &http.Client{
+        Transport: &http.Transport{
+            Proxy:             http.ProxyFromEnvironment,
+            TLSClientConfig:   tlsCfg,
+            DisableKeepAlives: true,
+        },
+    }
+    hc.clients[backend.URL] = c
+    return c, nil
+}
+
+// defaultPathStatus - ожидаемые коды, когда задан path, а expectedStatus нет
+var defaultPathStatus = []config.StatusRange{{Min: 200, Max: 299}}
+
+// checkOne возвращает nil, если бэкенд жив, иначе причину
+func checkOne(client *http.Client, addr string, check config.HealthCheckConfig) error {
+    timeout := time.Duration(check.Timeout)
+    method := check.Method
+    if method == "" {
+        method = http.MethodGet
+    }
+
+    target, err := probeURL(addr, check.Path)
+    if err != nil {
+        return err
+    }
+
+    ctx := context.Background()
+    if timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+        defer cancel()
+    }
+    req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+    if err != nil {
+        return fmt.Errorf("build request: %w", err)
+    }
+    for k, v := range check.Headers {
+        req.Header.Set(k, v)
+    }
+    // заголовок Host в net/http задаётся полем запроса
+    if host := req.Header.Get("Host"); host != "" {
+        req.Host = host
+    }
+
+    // Отдельный путь проверки - это health endpoint, и без expectedStatus
+    // живым на нём считается только 2xx: 404 на /healthz значит, что
+    // проверять нечего. Без пути и ожиданий действует старое поведение:
+    // живым считается всё, что не 5xx, а если HTTP не прошёл, но хост
+    // доступен по TCP - тоже жив
+    expected := check.ExpectedStatus
+    if len(expected) == 0 && check.Path != "" {
+        expected = defaultPathStatus
+    }
+    strict := len(expected) > 0 || check.BodyContains != ""
+
+    resp, err := client.Do(req)
+    if err != nil {
+        if strict {
+            return err
+        }
+        conn, err2 := net.DialTimeout("tcp", hostPort(target), timeout)
+        if err2 == nil {
+            conn.Close()
+            return nil
+        }
+        return err
+    }
+    defer resp.Body.Close()
+
+    if !statusOK(resp.StatusCode, expected) {
+        return fmt.Errorf("unexpected status %d", resp.StatusCode)
+    }
+
+    if check.BodyContains != "" {
+        body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBody))
+        if err != nil {
+            return fmt.Errorf("read body: %w", err)
+        }
+        if !strings.Contains(string(body), check.BodyContains) {
+            return fmt.Errorf("body does not contain %q", check.BodyContains)
+        }
+    }
+    return nil
 }
 
-func checkOne(addr string, timeout time.Duration) bool {
-    client := &http.Client{Timeout: timeout}
-    resp, err := client.Get(addr)
-    if err == nil {
-        resp.Body.Close()
-        return resp.StatusCode < 500
+// probeURL дописывает путь проверки к пути бэкенда:
+// http://host/app + /healthz?full=1 = http://host/app/healthz?full=1
+func probeURL(addr, path string) (*url.URL, error) {
+    u, err := url.Parse(addr)
+    if err != nil {
+        return nil, fmt.Errorf("parse backend url: %w", err)
+    }
+    if u.Scheme != "http" && u.Scheme != "https" {
+        return nil, fmt.Errorf("unsupported backend scheme %q", u.Scheme)
+    }
+    if path == "" {
+        return u, nil
+    }
+
+    ref, err := url.Parse(path)
+    if err != nil {
+        return nil, fmt.Errorf("parse health check path: %w", err)
     }
-    // Если HTTP не прошёл, но хост доступен по TCP
-    // то мы всё равно считаем «alive», так как ответ пришел не с 500-кой
-    conn, err2 := net.DialTimeout("tcp", addr[len("http://"):], timeout)
-    if err2 == nil {
-        conn.Close()
-        return true
+    u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(ref.Path, "/")
+    u.RawPath = ""
+    u.RawQuery = ref.RawQuery
+    return u, nil
+}
+
+// hostPort возвращает host:port бэкенда, порт по умолчанию берётся из схемы
+func hostPort(u *url.URL) string {
+    if u.Port() != "" {
+        return u.Host
+    }
+    port := "80"
+    if u.Scheme == "https" {
+        port = "443"
+    }
+    return net.JoinHostPort(u.Hostname(), port)
+}
+
+func statusOK(code int, expected []config.StatusRange) bool {
+    if len(expected) == 0 {
+        return code < 500
+    }
+    for _, r := range expected {
+        if r.Contains(code) {
+            return true
+        }
     }
     return false
 }
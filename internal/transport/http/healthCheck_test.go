@@ -0,0 +1,429 @@
+package http
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/balancer"
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/logger"
+	"gopher-equalizer/internal/models"
+	"gopher-equalizer/pkg/strategies"
+)
+
+func TestCheckOne(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Probe") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"status":"ok"}`)
+	})
+	mux.HandleFunc("/head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	timeout := config.Duration(time.Second)
+	client := &http.Client{}
+
+	t.Run("DefaultTreatsNon5xxAsAlive", func(t *testing.T) {
+		// на корне mux отдаёт 404, по старому поведению это "жив"
+		require.NoError(t, checkOne(client, srv.URL, config.HealthCheckConfig{Timeout: timeout}))
+		require.Error(t, checkOne(client, srv.URL, config.HealthCheckConfig{Path: "/broken", Timeout: timeout}))
+	})
+
+	t.Run("PathExpects2xx", func(t *testing.T) {
+		// с отдельным путём 404 и 403 - не "жив", даже без expectedStatus
+		require.Error(t, checkOne(client, srv.URL, config.HealthCheckConfig{Path: "/missing", Timeout: timeout}))
+		require.Error(t, checkOne(client, srv.URL, config.HealthCheckConfig{Path: "/healthz", Timeout: timeout}))
+
+		check := config.HealthCheckConfig{
+			Path:    "/healthz",
+			Headers: map[string]string{"X-Probe": "secret"},
+			Timeout: timeout,
+		}
+		require.NoError(t, checkOne(client, srv.URL, check))
+	})
+
+	t.Run("ExpectedStatus", func(t *testing.T) {
+		check := config.HealthCheckConfig{
+			Path:           "/missing",
+			ExpectedStatus: []config.StatusRange{{Min: 200, Max: 299}},
+			Timeout:        timeout,
+		}
+		require.Error(t, checkOne(client, srv.URL, check), "404 не должен считаться живым")
+
+		check.ExpectedStatus = []config.StatusRange{{Min: 200, Max: 299}, {Min: 404, Max: 404}}
+		require.NoError(t, checkOne(client, srv.URL, check))
+	})
+
+	t.Run("HeadersAndBody", func(t *testing.T) {
+		check := config.HealthCheckConfig{
+			Path:           "/healthz",
+			ExpectedStatus: []config.StatusRange{{Min: 200, Max: 200}},
+			BodyContains:   `"status":"ok"`,
+			Timeout:        timeout,
+		}
+		require.Error(t, checkOne(client, srv.URL, check))
+
+		check.Headers = map[string]string{"X-Probe": "secret"}
+		require.NoError(t, checkOne(client, srv.URL, check))
+
+		check.BodyContains = "ready"
+		require.Error(t, checkOne(client, srv.URL, check))
+	})
+
+	t.Run("Method", func(t *testing.T) {
+		check := config.HealthCheckConfig{
+			Path:           "/head",
+			ExpectedStatus: []config.StatusRange{{Min: 200, Max: 200}},
+			Timeout:        timeout,
+		}
+		require.Error(t, checkOne(client, srv.URL, check))
+
+		check.Method = http.MethodHead
+		require.NoError(t, checkOne(client, srv.URL, check))
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer slow.Close()
+
+		check := config.HealthCheckConfig{
+			ExpectedStatus: []config.StatusRange{{Min: 200, Max: 200}},
+			Timeout:        config.Duration(50 * time.Millisecond),
+		}
+		require.Error(t, checkOne(client, slow.URL, check))
+	})
+}
+
+func TestHealthCheckMerge(t *testing.T) {
+	hc := config.HealthCheckerConfig{
+		HealthCheckTimeout: config.Duration(5 * time.Second),
+		HealthCheckConfig: config.HealthCheckConfig{
+			Path:           "/healthz",
+			ExpectedStatus: []config.StatusRange{{Min: 200, Max: 299}},
+		},
+	}
+
+	// бэкенд без своей проверки получает настройки пула
+	got := hc.Check(config.BackendConfig{URL: "http://a"})
+	require.Equal(t, "/healthz", got.Path)
+	require.Equal(t, config.Duration(5*time.Second), got.Timeout)
+
+	// свои поля бэкенда важнее, остальное из пула
+	got = hc.Check(config.BackendConfig{
+		URL: "http://b",
+		HealthCheck: &config.HealthCheckConfig{
+			Path:    "/ready",
+			Timeout: config.Duration(time.Second),
+		},
+	})
+	require.Equal(t, "/ready", got.Path)
+	require.Equal(t, config.Duration(time.Second), got.Timeout)
+	require.Equal(t, []config.StatusRange{{Min: 200, Max: 299}}, got.ExpectedStatus)
+}
+
+type fakeBalancer struct {
+	mu    sync.Mutex
+	alive [][]string
+}
+
+func (f *fakeBalancer) NextBackend(r *http.Request) (string, error) { return "", nil }
+func (f *fakeBalancer) ResetBackends(backs []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.alive = append(f.alive, backs)
+}
+func (f *fakeBalancer) OnRequestStart(backend string)                        {}
+func (f *fakeBalancer) OnRequestDone(backend string, err error)              {}
+func (f *fakeBalancer) ObserveLatency(backend string, latency time.Duration) {}
+func (f *fakeBalancer) InFlight(backend string) int                          { return 0 }
+func (f *fakeBalancer) SetWeight(backend string, weight int)                 {}
+
+func (f *fakeBalancer) last() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.alive[len(f.alive)-1]
+}
+
+func testConfig(backends ...string) *config.Config {
+	cfg := &config.Config{}
+	for _, b := range backends {
+		backend := config.BackendConfig{URL: b, Weight: 1}
+		backend.ID = backend.DefaultID()
+		cfg.Balancer.Backends = append(cfg.Balancer.Backends, backend)
+	}
+	// логгер без вывода
+	cfg.Logger.Config = zap.NewProductionConfig()
+	cfg.Logger.OutputPaths = nil
+	cfg.Logger.ErrorOutputPaths = nil
+	return cfg
+}
+
+func TestRiseFall(t *testing.T) {
+	cfg := testConfig("http://a", "http://b")
+	cfg.Proxy.HealthChecker.Rise = 2
+	cfg.Proxy.HealthChecker.Fall = 3
+	ctx, err := logger.New(context.Background(), cfg)
+	require.NoError(t, err)
+
+	bal := &fakeBalancer{}
+	hc := NewHealthChecker(cfg, bal, balancer.NewRegistry(cfg.Balancer.Backends))
+
+	// b отвечает по расписанию, a всегда жив
+	var bDown bool
+	hc.probe = func(backend config.BackendConfig, check config.HealthCheckConfig) error {
+		if backend.URL == "http://b" && bDown {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	hc.runOnce(ctx)
+	require.Equal(t, []string{"http://a", "http://b"}, bal.last())
+
+	// одна-две неудачи не выкидывают бэкенд
+	bDown = true
+	hc.runOnce(ctx)
+	hc.runOnce(ctx)
+	require.Equal(t, []string{"http://a", "http://b"}, bal.last())
+
+	// третья подряд - выкидывает
+	hc.runOnce(ctx)
+	require.Equal(t, []string{"http://a"}, bal.last())
+
+	// успех, затем снова неудача - счётчик успехов сбрасывается
+	bDown = false
+	hc.runOnce(ctx)
+	bDown = true
+	hc.runOnce(ctx)
+	bDown = false
+	hc.runOnce(ctx)
+	require.Equal(t, []string{"http://a"}, bal.last())
+
+	// второй успех подряд возвращает бэкенд
+	hc.runOnce(ctx)
+	require.Equal(t, []string{"http://a", "http://b"}, bal.last())
+}
+
+func TestBackendStateRecord(t *testing.T) {
+	now := time.Now()
+	st := &backendState{healthy: true, since: now}
+	probeErr := errors.New("timeout")
+
+	require.False(t, st.record(probeErr, now, 1, 2))
+	require.Equal(t, 1, st.failures)
+	require.Equal(t, probeErr, st.lastErr)
+
+	later := now.Add(time.Second)
+	require.True(t, st.record(probeErr, later, 1, 2))
+	require.False(t, st.healthy)
+	require.Equal(t, later, st.since)
+
+	require.True(t, st.record(nil, later, 1, 2))
+	require.True(t, st.healthy)
+	require.Equal(t, 0, st.failures)
+	require.Nil(t, st.lastErr)
+}
+
+func TestProbeURL(t *testing.T) {
+	cases := []struct {
+		addr, path, want string
+	}{
+		{"http://localhost:8081", "", "http://localhost:8081"},
+		{"http://localhost:8081", "/healthz", "http://localhost:8081/healthz"},
+		{"http://localhost:8081/", "healthz", "http://localhost:8081/healthz"},
+		{"https://api.example.com/app/", "/healthz?full=1", "https://api.example.com/app/healthz?full=1"},
+	}
+	for _, c := range cases {
+		u, err := probeURL(c.addr, c.path)
+		require.NoError(t, err)
+		require.Equal(t, c.want, u.String())
+	}
+
+	_, err := probeURL("ftp://localhost", "")
+	require.Error(t, err)
+
+	u, _ := url.Parse("https://api.example.com/app")
+	require.Equal(t, "api.example.com:443", hostPort(u))
+	u, _ = url.Parse("http://localhost:8081/app")
+	require.Equal(t, "localhost:8081", hostPort(u))
+}
+
+func TestHTTPSBackend(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, certPEM, 0o600))
+
+	check := config.HealthCheckConfig{
+		ExpectedStatus: []config.StatusRange{{Min: 200, Max: 200}},
+		Timeout:        config.Duration(time.Second),
+	}
+	hc := NewHealthChecker(testConfig(), &fakeBalancer{}, balancer.NewRegistry(nil))
+
+	// самоподписанный сертификат без CA не проходит
+	err := hc.checkBackend(config.BackendConfig{URL: srv.URL}, check)
+	require.Error(t, err)
+
+	err = hc.checkBackend(config.BackendConfig{
+		URL: srv.URL + "/",
+		TLS: &config.TLSConfig{CAFile: caFile},
+	}, check)
+	require.NoError(t, err)
+
+	err = hc.checkBackend(config.BackendConfig{
+		URL: srv.URL + "/skip",
+		TLS: &config.TLSConfig{InsecureSkipVerify: true},
+	}, check)
+	require.NoError(t, err)
+}
+
+func TestProbesRunConcurrently(t *testing.T) {
+	var backends []string
+	for i := 0; i < 20; i++ {
+		backends = append(backends, fmt.Sprintf("http://dead-%d", i))
+	}
+	cfg := testConfig(backends...)
+	cfg.Proxy.HealthChecker.Concurrency = 10
+	ctx, err := logger.New(context.Background(), cfg)
+	require.NoError(t, err)
+
+	var (
+		mu            sync.Mutex
+		running, peak int
+	)
+	hc := NewHealthChecker(cfg, &fakeBalancer{}, balancer.NewRegistry(cfg.Balancer.Backends))
+	hc.probe = func(backend config.BackendConfig, check config.HealthCheckConfig) error {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return errors.New("timeout")
+	}
+
+	start := time.Now()
+	hc.runOnce(ctx)
+	// 20 проверок по 50мс с лимитом 10 - два "таймаута", а не двадцать
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+	require.Equal(t, 10, peak)
+}
+
+func TestRuntimeBackends(t *testing.T) {
+	cfg := testConfig("http://a", "http://b")
+	ctx, err := logger.New(context.Background(), cfg)
+	require.NoError(t, err)
+
+	reg := balancer.NewRegistry(cfg.Balancer.Backends)
+	bal := &fakeBalancer{}
+	hc := NewHealthChecker(cfg, bal, reg)
+	var cDown bool
+	hc.probe = func(backend config.BackendConfig, check config.HealthCheckConfig) error {
+		if backend.URL == "http://c" && cDown {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	// новый бэкенд попадает в пул только после удачной проверки
+	require.NoError(t, reg.Add(config.BackendConfig{URL: "http://c", Weight: 1}))
+	require.Equal(t, []string{"http://a", "http://b"}, bal.last())
+	c, err := hc.GetBackend("c")
+	require.NoError(t, err)
+	require.Equal(t, models.BackendDown, c.State)
+
+	hc.runOnce(ctx)
+	require.Equal(t, []string{"http://a", "http://b", "http://c"}, bal.last())
+
+	// выведенный из ротации бэкенд проверяется, но запросов не получает
+	_, err = reg.SetDraining("a", true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://b", "http://c"}, bal.last())
+	hc.runOnce(ctx)
+	require.Equal(t, []string{"http://b", "http://c"}, bal.last())
+	a, err := hc.GetBackend("a")
+	require.NoError(t, err)
+	require.True(t, a.Draining)
+	require.Equal(t, models.BackendUp, a.State)
+
+	_, err = reg.SetDraining("a", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://a", "http://b", "http://c"}, bal.last())
+
+	// удалённый бэкенд пропадает и из пула, и из состояний
+	_, err = reg.Remove("b")
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://a", "http://c"}, bal.last())
+	_, err = hc.GetBackend("b")
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	require.Len(t, hc.ListBackends(), 2)
+
+	// добавленный на лету и сразу упавший бэкенд в пул не попадает
+	cDown = true
+	_, err = reg.Remove("c")
+	require.NoError(t, err)
+	require.NoError(t, reg.Add(config.BackendConfig{URL: "http://c", Weight: 1}))
+	hc.runOnce(ctx)
+	require.Equal(t, []string{"http://a"}, bal.last())
+}
+
+func TestDrainKeepsInFlight(t *testing.T) {
+	cfg := testConfig("http://a", "http://b")
+	reg := balancer.NewRegistry(cfg.Balancer.Backends)
+	bal := balancer.NewBalancer(strategies.NewRoundRobin(cfg.Balancer.URLs()), nil)
+	hc := NewHealthChecker(cfg, bal, reg)
+
+	bal.OnRequestStart("http://a")
+	_, err := reg.SetDraining("a", true)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for i := 0; i < 4; i++ {
+		backend, err := bal.NextBackend(r)
+		require.NoError(t, err)
+		require.Equal(t, "http://b", backend)
+	}
+
+	// начатый запрос доходит до конца и виден в статусе
+	a, err := hc.GetBackend("a")
+	require.NoError(t, err)
+	require.Equal(t, 1, a.InFlight)
+	bal.OnRequestDone("http://a", nil)
+	a, _ = hc.GetBackend("a")
+	require.Equal(t, 0, a.InFlight)
+}
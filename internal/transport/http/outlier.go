@@ -0,0 +1,163 @@
+package http
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gopher-equalizer/config"
+)
+
+const (
+	defaultBaseEjectionTime   = 30 * time.Second
+	defaultMaxEjectionTime    = 5 * time.Minute
+	defaultMaxEjectionPercent = 50
+	defaultErrorRateMinReqs   = 10
+)
+
+// outlierStat - пассивная статистика бэкенда по живому трафику
+type outlierStat struct {
+	consecutive  int // ошибок подряд
+	windowStart  time.Time
+	windowTotal  int
+	windowErrors int
+
+	ejections    int // сколько раз подряд бэкенд выкидывали, растит время выброса
+	ejectedAt    time.Time
+	ejectedUntil time.Time
+}
+
+// OutlierDetector выкидывает бэкенд из пула по ответам, которые видит прокси:
+// после consecutiveErrors ошибок подряд (5xx или ошибка соединения) или
+// если доля ошибок в окне превысила errorRateThreshold процентов.
+// Время выброса растёт как baseEjectionTime * 2^(n-1), но не больше
+// maxEjectionTime, а одновременно выкинуть можно не больше maxEjectionPercent пула
+type OutlierDetector struct {
+	mu    sync.Mutex
+	cfg   config.OutlierDetectionConfig
+	stats map[string]*outlierStat
+	// poolSize - текущий размер пула, от него считается maxEjectionPercent
+	poolSize func() int
+}
+
+func NewOutlierDetector(cfg config.OutlierDetectionConfig, poolSize func() int) *OutlierDetector {
+	if cfg.BaseEjectionTime <= 0 {
+		cfg.BaseEjectionTime = config.Duration(defaultBaseEjectionTime)
+	}
+	if cfg.MaxEjectionTime <= 0 {
+		cfg.MaxEjectionTime = config.Duration(defaultMaxEjectionTime)
+	}
+	if cfg.MaxEjectionPercent <= 0 {
+		cfg.MaxEjectionPercent = defaultMaxEjectionPercent
+	}
+	if cfg.ErrorRateMinRequests <= 0 {
+		cfg.ErrorRateMinRequests = defaultErrorRateMinReqs
+	}
+	return &OutlierDetector{
+		cfg:      cfg,
+		stats:    make(map[string]*outlierStat),
+		poolSize: poolSize,
+	}
+}
+
+// Report учитывает результат запроса к бэкенду. Если бэкенд выкинут,
+// возвращает время выброса и причину, иначе нулевое время
+func (od *OutlierDetector) Report(backend string, status int, err error, now time.Time) (time.Duration, string) {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	st, ok := od.stats[backend]
+	if !ok {
+		st = &outlierStat{windowStart: now}
+		od.stats[backend] = st
+	}
+	// ответы, пришедшие уже после выброса, не учитываем
+	if now.Before(st.ejectedUntil) {
+		return 0, ""
+	}
+	// бэкенд долго жил без выбросов - прощаем ему прошлые
+	if st.ejections > 0 && now.Sub(st.ejectedUntil) > time.Duration(od.cfg.MaxEjectionTime) {
+		st.ejections = 0
+	}
+
+	failed := err != nil || status >= 500
+	if window := time.Duration(od.cfg.ErrorRateWindow); window > 0 && now.Sub(st.windowStart) >= window {
+		st.windowStart, st.windowTotal, st.windowErrors = now, 0, 0
+	}
+	st.windowTotal++
+	if failed {
+		st.consecutive++
+		st.windowErrors++
+	} else {
+		st.consecutive = 0
+	}
+
+	var reason string
+	switch {
+	case od.cfg.ConsecutiveErrors > 0 && st.consecutive >= od.cfg.ConsecutiveErrors:
+		reason = fmt.Sprintf("%d errors in a row", st.consecutive)
+	case od.cfg.ErrorRateThreshold > 0 &&
+		st.windowTotal >= od.cfg.ErrorRateMinRequests &&
+		st.windowErrors*100 >= od.cfg.ErrorRateThreshold*st.windowTotal:
+		reason = fmt.Sprintf("error rate %d%% (%d/%d)",
+			st.windowErrors*100/st.windowTotal, st.windowErrors, st.windowTotal)
+	default:
+		return 0, ""
+	}
+
+	if !od.canEject(now) {
+		return 0, ""
+	}
+
+	st.ejections++
+	shift := st.ejections - 1
+	if shift > 30 {
+		shift = 30
+	}
+	d := time.Duration(od.cfg.BaseEjectionTime) << shift
+	if max := time.Duration(od.cfg.MaxEjectionTime); d > max || d <= 0 {
+		d = max
+	}
+	st.ejectedAt = now
+	st.ejectedUntil = now.Add(d)
+	st.consecutive = 0
+	st.windowStart, st.windowTotal, st.windowErrors = now, 0, 0
+	return d, reason
+}
+
+// IsEjected сообщает, выкинут ли бэкенд в данный момент
+func (od *OutlierDetector) IsEjected(backend string, now time.Time) bool {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	st, ok := od.stats[backend]
+	return ok && now.Before(st.ejectedUntil)
+}
+
+// EjectedSince возвращает время выброса, если бэкенд сейчас выкинут
+func (od *OutlierDetector) EjectedSince(backend string, now time.Time) (time.Time, bool) {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	st, ok := od.stats[backend]
+	if !ok || !now.Before(st.ejectedUntil) {
+		return time.Time{}, false
+	}
+	return st.ejectedAt, true
+}
+
+// Forget удаляет статистику бэкенда, убранного из пула
+func (od *OutlierDetector) Forget(backend string) {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	delete(od.stats, backend)
+}
+
+// canEject проверяет ограничение maxEjectionPercent, od.mu уже захвачен
+func (od *OutlierDetector) canEject(now time.Time) bool {
+	ejected := 0
+	for _, st := range od.stats {
+		if now.Before(st.ejectedUntil) {
+			ejected++
+		}
+	}
+	return (ejected+1)*100 <= od.cfg.MaxEjectionPercent*od.poolSize()
+}
@@ -0,0 +1,235 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/balancer"
+	"gopher-equalizer/internal/errdefs"
+	"gopher-equalizer/internal/logger"
+	"gopher-equalizer/internal/models"
+)
+
+func TestOutlierDetector(t *testing.T) {
+	pool := func(n int) func() int { return func() int { return n } }
+	connErr := errors.New("connection refused")
+
+	t.Run("ConsecutiveErrors", func(t *testing.T) {
+		od := NewOutlierDetector(config.OutlierDetectionConfig{
+			ConsecutiveErrors: 3,
+			BaseEjectionTime:  config.Duration(10 * time.Second),
+		}, pool(4))
+		now := time.Now()
+
+		d, _ := od.Report("a", 502, nil, now)
+		require.Zero(t, d)
+		d, _ = od.Report("a", 0, connErr, now)
+		require.Zero(t, d)
+		// успешный ответ обнуляет серию
+		d, _ = od.Report("a", 200, nil, now)
+		require.Zero(t, d)
+
+		od.Report("a", 500, nil, now)
+		od.Report("a", 503, nil, now)
+		d, reason := od.Report("a", 0, connErr, now)
+		require.Equal(t, 10*time.Second, d)
+		require.Contains(t, reason, "3 errors in a row")
+		require.True(t, od.IsEjected("a", now))
+		require.False(t, od.IsEjected("a", now.Add(11*time.Second)))
+	})
+
+	t.Run("ErrorRate", func(t *testing.T) {
+		od := NewOutlierDetector(config.OutlierDetectionConfig{
+			ErrorRateThreshold:   50,
+			ErrorRateWindow:      config.Duration(time.Minute),
+			ErrorRateMinRequests: 10,
+		}, pool(4))
+		now := time.Now()
+
+		// чередование ошибок не даёт серии, но даёт 50% в окне
+		var d time.Duration
+		for i := 0; i < 10; i++ {
+			status := 200
+			if i%2 == 1 {
+				status = 500
+			}
+			d, _ = od.Report("a", status, nil, now)
+			if i < 9 {
+				require.Zero(t, d, "request %d", i)
+			}
+		}
+		require.Equal(t, defaultBaseEjectionTime, d)
+	})
+
+	t.Run("ErrorRateWindowResets", func(t *testing.T) {
+		od := NewOutlierDetector(config.OutlierDetectionConfig{
+			ErrorRateThreshold:   50,
+			ErrorRateWindow:      config.Duration(time.Second),
+			ErrorRateMinRequests: 4,
+		}, pool(4))
+		now := time.Now()
+
+		od.Report("a", 500, nil, now)
+		od.Report("a", 500, nil, now)
+		// окно истекло, старые ошибки не считаются
+		later := now.Add(2 * time.Second)
+		for i := 0; i < 3; i++ {
+			d, _ := od.Report("a", 200, nil, later)
+			require.Zero(t, d)
+		}
+		d, _ := od.Report("a", 500, nil, later)
+		require.Zero(t, d)
+	})
+
+	t.Run("ExponentialEjectionTime", func(t *testing.T) {
+		od := NewOutlierDetector(config.OutlierDetectionConfig{
+			ConsecutiveErrors: 1,
+			BaseEjectionTime:  config.Duration(10 * time.Second),
+			MaxEjectionTime:   config.Duration(30 * time.Second),
+		}, pool(2))
+		now := time.Now()
+
+		var got []time.Duration
+		for i := 0; i < 4; i++ {
+			d, _ := od.Report("a", 500, nil, now)
+			got = append(got, d)
+			now = now.Add(d)
+		}
+		require.Equal(t, []time.Duration{
+			10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second,
+		}, got)
+
+		// долгая жизнь без выбросов сбрасывает множитель
+		now = now.Add(time.Minute)
+		d, _ := od.Report("a", 500, nil, now)
+		require.Equal(t, 10*time.Second, d)
+	})
+
+	t.Run("MaxEjectionPercent", func(t *testing.T) {
+		od := NewOutlierDetector(config.OutlierDetectionConfig{
+			ConsecutiveErrors:  1,
+			MaxEjectionPercent: 50,
+		}, pool(4))
+		now := time.Now()
+
+		d, _ := od.Report("a", 500, nil, now)
+		require.NotZero(t, d)
+		d, _ = od.Report("b", 500, nil, now)
+		require.NotZero(t, d)
+		// половина пула уже выкинута
+		d, _ = od.Report("c", 500, nil, now)
+		require.Zero(t, d)
+		require.False(t, od.IsEjected("c", now))
+	})
+}
+
+func TestHealthCheckerPassive(t *testing.T) {
+	cfg := testConfig("http://a", "http://b", "http://c")
+	cfg.Proxy.OutlierDetection = config.OutlierDetectionConfig{
+		Enabled:           true,
+		ConsecutiveErrors: 2,
+		BaseEjectionTime:  config.Duration(50 * time.Millisecond),
+	}
+	ctx, err := logger.New(context.Background(), cfg)
+	require.NoError(t, err)
+
+	bal := &fakeBalancer{}
+	hc := NewHealthChecker(cfg, bal, balancer.NewRegistry(cfg.Balancer.Backends))
+	hc.probe = func(backend config.BackendConfig, check config.HealthCheckConfig) error { return nil }
+
+	hc.ReportResult(ctx, "http://b", 502, nil)
+	require.Empty(t, bal.alive)
+	hc.ReportResult(ctx, "http://b", 502, nil)
+	require.Equal(t, []string{"http://a", "http://c"}, bal.last())
+
+	// активная проверка не возвращает выкинутый бэкенд раньше времени
+	hc.runOnce(ctx)
+	require.Equal(t, []string{"http://a", "http://c"}, bal.last())
+
+	require.Eventually(t, func() bool {
+		return len(bal.last()) == 3
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHealthCheckerReadmitLifecycle(t *testing.T) {
+	cfg := testConfig("http://a", "http://b", "http://c", "http://d")
+	cfg.Proxy.HealthChecker.Interval = config.Duration(time.Hour)
+	cfg.Proxy.OutlierDetection = config.OutlierDetectionConfig{
+		Enabled:           true,
+		ConsecutiveErrors: 1,
+		BaseEjectionTime:  config.Duration(time.Hour),
+		MaxEjectionTime:   config.Duration(time.Hour),
+	}
+	ctx, err := logger.New(context.Background(), cfg)
+	require.NoError(t, err)
+
+	registry := balancer.NewRegistry(cfg.Balancer.Backends)
+	hc := NewHealthChecker(cfg, &fakeBalancer{}, registry)
+	timers := func() int {
+		hc.readmitMu.Lock()
+		defer hc.readmitMu.Unlock()
+		return len(hc.readmit)
+	}
+
+	hc.ReportResult(ctx, "http://a", 502, nil)
+	hc.ReportResult(ctx, "http://b", 502, nil)
+	require.Equal(t, 2, timers())
+
+	// убранный из пула бэкенд возвращать некуда
+	_, err = registry.Remove("a")
+	require.NoError(t, err)
+	require.Equal(t, 1, timers())
+
+	// после остановки проверок таймеры не живут и новые не заводятся
+	runCtx, cancel := context.WithCancel(ctx)
+	hc.StartHealthChecks(runCtx)
+	cancel()
+	require.Eventually(t, func() bool { return timers() == 0 }, time.Second, 10*time.Millisecond)
+	hc.ReportResult(ctx, "http://c", 502, nil)
+	require.Equal(t, 0, timers())
+}
+
+func TestBackendStatus(t *testing.T) {
+	cfg := testConfig("http://a", "http://b", "http://c")
+	cfg.Proxy.HealthChecker.Fall = 1
+	cfg.Proxy.OutlierDetection = config.OutlierDetectionConfig{
+		Enabled:           true,
+		ConsecutiveErrors: 1,
+		BaseEjectionTime:  config.Duration(time.Minute),
+	}
+	ctx, err := logger.New(context.Background(), cfg)
+	require.NoError(t, err)
+
+	bal := &fakeBalancer{}
+	hc := NewHealthChecker(cfg, bal, balancer.NewRegistry(cfg.Balancer.Backends))
+	hc.probe = func(backend config.BackendConfig, check config.HealthCheckConfig) error {
+		if backend.URL == "http://b" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	hc.runOnce(ctx)
+	hc.ReportResult(ctx, "http://c", 503, nil)
+
+	list := hc.ListBackends()
+	require.Len(t, list, 3)
+	require.Equal(t, []string{"a", "b", "c"}, []string{list[0].ID, list[1].ID, list[2].ID})
+	require.Equal(t, []string{models.BackendUp, models.BackendDown, models.BackendEjected},
+		[]string{list[0].State, list[1].State, list[2].State})
+
+	b, err := hc.GetBackend("b")
+	require.NoError(t, err)
+	require.Equal(t, "http://b", b.URL)
+	require.Equal(t, 1, b.ConsecutiveFailures)
+	require.Equal(t, "connection refused", b.LastError)
+	require.False(t, b.LastProbe.IsZero())
+
+	_, err = hc.GetBackend("missing")
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
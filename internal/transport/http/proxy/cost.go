@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"gopher-equalizer/config"
+)
+
+// CostFunc возвращает, сколько токенов стоит запрос
+type CostFunc func(r *http.Request) int
+
+type costRule struct {
+	methods      map[string]bool
+	pathPrefix   string
+	pathRegex    *regexp.Regexp
+	minBodyBytes int64
+	cost         int
+}
+
+// NewCostFunc собирает правила bucket.cost. Правила проверяются по порядку,
+// первое подошедшее задаёт стоимость, без совпадений запрос стоит Default
+func NewCostFunc(cfg config.CostConfig) (CostFunc, error) {
+	def := cfg.Default
+	switch {
+	case def == 0:
+		def = 1
+	case def < 0:
+		return nil, fmt.Errorf("bucket.cost.default must be positive, got %d", def)
+	}
+
+	rules := make([]costRule, 0, len(cfg.Rules))
+	for i, rc := range cfg.Rules {
+		if rc.Cost <= 0 {
+			return nil, fmt.Errorf("bucket.cost.rules[%d]: cost must be positive, got %d", i, rc.Cost)
+		}
+		if rc.MinBodyBytes < 0 {
+			return nil, fmt.Errorf("bucket.cost.rules[%d]: minBodyBytes must be not negative", i)
+		}
+		rule := costRule{
+			pathPrefix:   rc.PathPrefix,
+			minBodyBytes: rc.MinBodyBytes,
+			cost:         rc.Cost,
+		}
+		if len(rc.Methods) > 0 {
+			rule.methods = make(map[string]bool, len(rc.Methods))
+			for _, m := range rc.Methods {
+				rule.methods[strings.ToUpper(m)] = true
+			}
+		}
+		if rc.PathRegex != "" {
+			re, err := regexp.Compile(rc.PathRegex)
+			if err != nil {
+				return nil, fmt.Errorf("bucket.cost.rules[%d]: invalid pathRegex: %v", i, err)
+			}
+			rule.pathRegex = re
+		}
+		rules = append(rules, rule)
+	}
+
+	return func(r *http.Request) int {
+		for _, rule := range rules {
+			if rule.match(r) {
+				return rule.cost
+			}
+		}
+		return def
+	}, nil
+}
+
+func (cr costRule) match(r *http.Request) bool {
+	if cr.methods != nil && !cr.methods[r.Method] {
+		return false
+	}
+	if !strings.HasPrefix(r.URL.Path, cr.pathPrefix) {
+		return false
+	}
+	if cr.pathRegex != nil && !cr.pathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+	// без Content-Length размер неизвестен, и большое тело нельзя
+	// протащить по цене маленького, отправив его chunked
+	if cr.minBodyBytes > 0 && r.ContentLength >= 0 && r.ContentLength < cr.minBodyBytes {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/config"
+)
+
+func TestCostFunc(t *testing.T) {
+	cost, err := NewCostFunc(config.CostConfig{
+		Default: 2,
+		Rules: []config.CostRule{
+			{Methods: []string{"get"}, PathPrefix: "/search", Cost: 5},
+			{Methods: []string{"POST"}, PathPrefix: "/upload", MinBodyBytes: 1024, Cost: 20},
+			{PathRegex: `^/reports/\d+/export$`, Cost: 50},
+			{PathPrefix: "/upload", Cost: 3},
+		},
+	})
+	require.NoError(t, err)
+
+	upload := func(size int) int {
+		r := httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("x", size)))
+		return cost(r)
+	}
+
+	require.Equal(t, 5, cost(httptest.NewRequest("GET", "/search?q=go", nil)))
+	require.Equal(t, 2, cost(httptest.NewRequest("POST", "/search", nil)), "метод не подошёл")
+	require.Equal(t, 50, cost(httptest.NewRequest("GET", "/reports/42/export", nil)))
+	require.Equal(t, 2, cost(httptest.NewRequest("GET", "/reports/all/export", nil)))
+	require.Equal(t, 20, upload(2048))
+	require.Equal(t, 3, upload(100), "маленькое тело уходит в следующее правило")
+
+	chunked := httptest.NewRequest("POST", "/upload", strings.NewReader("x"))
+	chunked.ContentLength = -1
+	require.Equal(t, 20, cost(chunked), "неизвестный размер считается большим")
+
+	t.Run("DefaultCost", func(t *testing.T) {
+		cost, err := NewCostFunc(config.CostConfig{})
+		require.NoError(t, err)
+		require.Equal(t, 1, cost(httptest.NewRequest("GET", "/", nil)))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := NewCostFunc(config.CostConfig{Default: -1})
+		require.Error(t, err)
+		_, err = NewCostFunc(config.CostConfig{Rules: []config.CostRule{{PathPrefix: "/", Cost: 0}}})
+		require.Error(t, err)
+		_, err = NewCostFunc(config.CostConfig{Rules: []config.CostRule{{PathRegex: "(", Cost: 1}}})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,316 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"gopher-equalizer/config"
+)
+
+// maxIdentityLen - значения длиннее не становятся ключом бакета: иначе
+// клиент может завести сколько угодно огромных строк в хранилище
+const maxIdentityLen = 256
+
+const defaultAnonymousKey = "anonymous"
+
+// IdentityFunc возвращает ключ бакета для запроса. false - клиента не удалось
+// определить и запрос нужно отклонить
+type IdentityFunc func(r *http.Request) (string, bool)
+
+type identitySource func(r *http.Request) (string, bool)
+
+// NewIdentityFunc собирает источники bucket.identity. Источники проверяются
+// по порядку, ключом становится первый найденный. Без источников клиент
+// определяется по адресу соединения
+func NewIdentityFunc(cfg config.IdentityConfig) (IdentityFunc, error) {
+	trusted := make([]netip.Prefix, 0, len(cfg.TrustedProxies))
+	for i, s := range cfg.TrustedProxies {
+		prefix, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("bucket.identity.trustedProxies[%d]: %v", i, err)
+		}
+		trusted = append(trusted, prefix)
+	}
+
+	sources := cfg.Sources
+	if len(sources) == 0 {
+		sources = []config.IdentitySource{{Type: config.IdentityIP}}
+	}
+	extractors := make([]identitySource, 0, len(sources))
+	for i, sc := range sources {
+		src, err := newIdentitySource(sc, trusted)
+		if err != nil {
+			return nil, fmt.Errorf("bucket.identity.sources[%d]: %v", i, err)
+		}
+		extractors = append(extractors, src)
+	}
+
+	anonymous := true
+	switch cfg.Anonymous {
+	case "", config.AnonymousShared:
+	case config.AnonymousReject:
+		anonymous = false
+	default:
+		return nil, fmt.Errorf("bucket.identity.anonymous must be %s or %s, got %q",
+			config.AnonymousShared, config.AnonymousReject, cfg.Anonymous)
+	}
+	anonymousKey := cfg.AnonymousKey
+	if anonymousKey == "" {
+		anonymousKey = defaultAnonymousKey
+	}
+	if strings.HasPrefix(anonymousKey, "policy:") {
+		// так начинаются ключи бакетов bucket.policies
+		return nil, fmt.Errorf("bucket.identity.anonymousKey must not start with \"policy:\"")
+	}
+
+	return func(r *http.Request) (string, bool) {
+		for _, src := range extractors {
+			if id, ok := src(r); ok {
+				return id, true
+			}
+		}
+		return anonymousKey, anonymous
+	}, nil
+}
+
+func newIdentitySource(sc config.IdentitySource, trusted []netip.Prefix) (identitySource, error) {
+	if sc.IPv4Prefix < 0 || sc.IPv4Prefix > 32 {
+		return nil, fmt.Errorf("ipv4Prefix must be in 0..32, got %d", sc.IPv4Prefix)
+	}
+	if sc.IPv6Prefix < 0 || sc.IPv6Prefix > 128 {
+		return nil, fmt.Errorf("ipv6Prefix must be in 0..128, got %d", sc.IPv6Prefix)
+	}
+	mask := func(addr netip.Addr) string {
+		return maskAddr(addr, sc.IPv4Prefix, sc.IPv6Prefix)
+	}
+
+	switch sc.Type {
+	case config.IdentityHeader, config.IdentityCookie, config.IdentityQuery:
+		if sc.Name == "" {
+			return nil, fmt.Errorf("%s source requires name", sc.Type)
+		}
+	}
+
+	switch sc.Type {
+	case config.IdentityHeader:
+		name := http.CanonicalHeaderKey(sc.Name)
+		return func(r *http.Request) (string, bool) {
+			return identity("header:", r.Header.Get(name))
+		}, nil
+	case config.IdentityCookie:
+		return func(r *http.Request) (string, bool) {
+			c, err := r.Cookie(sc.Name)
+			if err != nil {
+				return "", false
+			}
+			return identity("cookie:", c.Value)
+		}, nil
+	case config.IdentityQuery:
+		return func(r *http.Request) (string, bool) {
+			return identity("query:", r.URL.Query().Get(sc.Name))
+		}, nil
+	case config.IdentityBearer:
+		secret := []byte(sc.Secret)
+		return func(r *http.Request) (string, bool) {
+			return identity("sub:", bearerSubject(r, secret, time.Now()))
+		}, nil
+	case config.IdentityForwarded:
+		if len(trusted) == 0 {
+			return nil, fmt.Errorf("forwarded source requires bucket.identity.trustedProxies")
+		}
+		return func(r *http.Request) (string, bool) {
+			addr, ok := forwardedClient(r, trusted)
+			if !ok {
+				return "", false
+			}
+			return mask(addr), true
+		}, nil
+	case config.IdentityIP:
+		return func(r *http.Request) (string, bool) {
+			addr, ok := remoteAddr(r)
+			if !ok {
+				return "", false
+			}
+			return mask(addr), true
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown source type %q", sc.Type)
+}
+
+func identity(prefix, value string) (string, bool) {
+	if value == "" || len(value) > maxIdentityLen {
+		return "", false
+	}
+	return prefix + value, true
+}
+
+// maskAddr оставляет от адреса первые bits бит, 0 - адрес целиком. Так все
+// адреса одной /64 считаются одним клиентом и не обходят лимит
+func maskAddr(addr netip.Addr, v4Bits, v6Bits int) string {
+	bits := v6Bits
+	if addr.Is4() {
+		bits = v4Bits
+	}
+	if bits == 0 || bits == addr.BitLen() {
+		return addr.String()
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix.String()
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return parseHop(r.RemoteAddr)
+	}
+	return ap.Addr().Unmap(), true
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClient берёт адрес клиента из Forwarded или, если его нет, из
+// X-Forwarded-For. Заголовкам верим, только если соединение пришло от
+// доверенного прокси. Цепочка читается справа налево: левые адреса дописал
+// сам клиент, поэтому клиентом считается первый недоверенный адрес
+func forwardedClient(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	remote, ok := remoteAddr(r)
+	if !ok || !isTrusted(remote, trusted) {
+		return netip.Addr{}, false
+	}
+
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if hops == nil {
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+	}
+	if len(hops) == 0 {
+		return netip.Addr{}, false
+	}
+
+	var addr netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok = parseHop(hops[i])
+		if !ok {
+			// unknown или обфусцированный адрес: кто за ним - неизвестно
+			return netip.Addr{}, false
+		}
+		if !isTrusted(addr, trusted) {
+			return addr, true
+		}
+	}
+	// вся цепочка из доверенных адресов - клиент в самой левой позиции
+	return addr, true
+}
+
+// forwardedFor возвращает параметры for= из заголовков Forwarded (RFC 7239)
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, value)
+				}
+			}
+		}
+	}
+	return hops
+}
+
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+type jwtClaims struct {
+	Sub string   `json:"sub"`
+	Exp *float64 `json:"exp"`
+}
+
+// bearerSubject достаёт claim sub из JWT в Authorization. С secret подпись
+// HS256 проверяется, без него токену верим на слово: так можно, только если
+// его уже проверил кто-то перед сервисом
+func bearerSubject(r *http.Request, secret []byte, now time.Time) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	if len(secret) > 0 {
+		var header struct {
+			Alg string `json:"alg"`
+		}
+		if !decodeSegment(parts[0], &header) || header.Alg != "HS256" {
+			return ""
+		}
+		sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			return ""
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return ""
+		}
+	}
+
+	var claims jwtClaims
+	if !decodeSegment(parts[1], &claims) {
+		return ""
+	}
+	if claims.Exp != nil && float64(now.Unix()) >= *claims.Exp {
+		return ""
+	}
+	return claims.Sub
+}
+
+func decodeSegment(seg string, v any) bool {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/config"
+)
+
+func signHS256(t *testing.T, payload, secret string) string {
+	t.Helper()
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+func TestIdentityFunc(t *testing.T) {
+	identify, err := NewIdentityFunc(config.IdentityConfig{
+		Sources: []config.IdentitySource{
+			{Type: config.IdentityHeader, Name: "x-api-key"},
+			{Type: config.IdentityBearer, Secret: "s3cret"},
+			{Type: config.IdentityCookie, Name: "session"},
+			{Type: config.IdentityQuery, Name: "token"},
+			{Type: config.IdentityIP, IPv4Prefix: 24, IPv6Prefix: 64},
+		},
+	})
+	require.NoError(t, err)
+
+	check := func(r *http.Request, want string) {
+		t.Helper()
+		id, ok := identify(r)
+		require.True(t, ok)
+		require.Equal(t, want, id)
+	}
+
+	r := httptest.NewRequest("GET", "/?token=q1", nil)
+	r.RemoteAddr = "198.51.100.7:5000"
+	check(r, "query:q1")
+
+	r.AddCookie(&http.Cookie{Name: "session", Value: "c1"})
+	check(r, "cookie:c1")
+
+	valid := signHS256(t, `{"sub":"user-1"}`, "s3cret")
+	r.Header.Set("Authorization", "Bearer "+valid)
+	check(r, "sub:user-1")
+
+	r.Header.Set("X-Api-Key", "k1")
+	check(r, "header:k1")
+
+	t.Run("IPPrefix", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "198.51.100.7:5000"
+		check(r, "198.51.100.0/24")
+		r.RemoteAddr = "[2001:db8:1:2:3::4]:5000"
+		check(r, "2001:db8:1:2::/64")
+		r.RemoteAddr = "[::ffff:198.51.100.9]:5000"
+		check(r, "198.51.100.0/24")
+	})
+
+	t.Run("InvalidBearer", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "198.51.100.7:5000"
+
+		r.Header.Set("Authorization", "Bearer "+signHS256(t, `{"sub":"user-1"}`, "other"))
+		check(r, "198.51.100.0/24")
+
+		expired := signHS256(t, `{"sub":"user-1","exp":1}`, "s3cret")
+		r.Header.Set("Authorization", "Bearer "+expired)
+		check(r, "198.51.100.0/24")
+
+		r.Header.Set("Authorization", "Bearer not-a-jwt")
+		check(r, "198.51.100.0/24")
+	})
+}
+
+func TestBearerSubjectWithoutSecret(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signHS256(t, `{"sub":"user-2","exp":2000000000}`, "any"))
+	require.Equal(t, "user-2", bearerSubject(r, nil, time.Unix(1900000000, 0)))
+	require.Empty(t, bearerSubject(r, nil, time.Unix(2000000000, 0)), "токен истёк")
+}
+
+func TestForwardedIdentity(t *testing.T) {
+	identify, err := NewIdentityFunc(config.IdentityConfig{
+		Sources: []config.IdentitySource{
+			{Type: config.IdentityForwarded},
+			{Type: config.IdentityIP},
+		},
+		TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"},
+	})
+	require.NoError(t, err)
+
+	request := func(remote string, headers ...string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remote
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Add(headers[i], headers[i+1])
+		}
+		return r
+	}
+	check := func(r *http.Request, want string) {
+		t.Helper()
+		id, ok := identify(r)
+		require.True(t, ok)
+		require.Equal(t, want, id)
+	}
+
+	check(request("10.1.1.1:80", "X-Forwarded-For", "203.0.113.5"), "203.0.113.5")
+	check(request("198.51.100.7:80", "X-Forwarded-For", "203.0.113.5"), "198.51.100.7")
+	check(request("10.1.1.1:80", "X-Forwarded-For", "1.1.1.1, 203.0.113.5, 10.2.2.2"), "203.0.113.5")
+	check(request("10.1.1.1:80", "X-Forwarded-For", "1.1.1.1", "X-Forwarded-For", "203.0.113.5"), "203.0.113.5")
+	check(request("192.0.2.1:80", "X-Forwarded-For", "10.3.3.3, 10.2.2.2"), "10.3.3.3")
+
+	check(request("10.1.1.1:80",
+		"Forwarded", `for=1.1.1.1;proto=http, for="[2001:db8::1]:4711";by=10.0.0.1`,
+		"X-Forwarded-For", "203.0.113.5",
+	), "2001:db8::1")
+
+	// неразборчивый адрес в цепочке - берём адрес соединения
+	check(request("10.1.1.1:80", "Forwarded", "for=unknown"), "10.1.1.1")
+}
+
+func TestAnonymousIdentity(t *testing.T) {
+	sources := []config.IdentitySource{{Type: config.IdentityHeader, Name: "X-Api-Key"}}
+	r := httptest.NewRequest("GET", "/", nil)
+
+	shared, err := NewIdentityFunc(config.IdentityConfig{Sources: sources})
+	require.NoError(t, err)
+	id, ok := shared(r)
+	require.True(t, ok)
+	require.Equal(t, "anonymous", id)
+
+	reject, err := NewIdentityFunc(config.IdentityConfig{Sources: sources, Anonymous: config.AnonymousReject})
+	require.NoError(t, err)
+	_, ok = reject(r)
+	require.False(t, ok)
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, cfg := range []config.IdentityConfig{
+			{Anonymous: "deny"},
+			{Sources: []config.IdentitySource{{Type: "token"}}},
+			{Sources: []config.IdentitySource{{Type: config.IdentityHeader}}},
+			{Sources: []config.IdentitySource{{Type: config.IdentityIP, IPv6Prefix: 129}}},
+			{Sources: []config.IdentitySource{{Type: config.IdentityForwarded}}},
+			{TrustedProxies: []string{"10.0.0.0/33"}},
+		} {
+			_, err := NewIdentityFunc(cfg)
+			require.Error(t, err, "%+v", cfg)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"gopher-equalizer/config"
+)
+
+// PolicyFunc возвращает имена политик bucket.policies, под которые подходит
+// запрос. Пусто - запрос списывается с общего бакета клиента
+type PolicyFunc func(r *http.Request) []string
+
+type policyMatcher struct {
+	name       string
+	hosts      []string
+	pathPrefix string
+	methods    map[string]bool
+	headers    map[string]string
+}
+
+// NewPolicyFunc собирает условия политик. В отличие от правил стоимости
+// срабатывает не первая политика, а все подошедшие: запрос списывается
+// с каждой из них
+func NewPolicyFunc(policies []config.PolicyConfig) PolicyFunc {
+	matchers := make([]policyMatcher, 0, len(policies))
+	for _, pc := range policies {
+		m := policyMatcher{name: pc.Name, pathPrefix: pc.Match.PathPrefix}
+		for _, host := range pc.Match.Hosts {
+			m.hosts = append(m.hosts, strings.ToLower(host))
+		}
+		if len(pc.Match.Methods) > 0 {
+			m.methods = make(map[string]bool, len(pc.Match.Methods))
+			for _, method := range pc.Match.Methods {
+				m.methods[strings.ToUpper(method)] = true
+			}
+		}
+		if len(pc.Match.Headers) > 0 {
+			m.headers = make(map[string]string, len(pc.Match.Headers))
+			for name, value := range pc.Match.Headers {
+				m.headers[http.CanonicalHeaderKey(name)] = value
+			}
+		}
+		matchers = append(matchers, m)
+	}
+
+	return func(r *http.Request) []string {
+		var names []string
+		for _, m := range matchers {
+			if m.match(r) {
+				names = append(names, m.name)
+			}
+		}
+		return names
+	}
+}
+
+func (m policyMatcher) match(r *http.Request) bool {
+	if m.methods != nil && !m.methods[r.Method] {
+		return false
+	}
+	if !strings.HasPrefix(r.URL.Path, m.pathPrefix) {
+		return false
+	}
+	if len(m.hosts) > 0 && !matchHost(m.hosts, requestHost(r)) {
+		return false
+	}
+	for name, want := range m.headers {
+		values, ok := r.Header[name]
+		if !ok {
+			return false
+		}
+		if want != "" && !containsValue(values, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestHost - Host запроса без порта и в нижнем регистре
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// matchHost - имя хоста целиком или *.example.com для любого поддомена
+func matchHost(hosts []string, host string) bool {
+	for _, pattern := range hosts {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func containsValue(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
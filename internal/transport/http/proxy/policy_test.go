@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/config"
+)
+
+func TestPolicyFunc(t *testing.T) {
+	policies := NewPolicyFunc([]config.PolicyConfig{
+		{Name: "search", Match: config.PolicyMatch{PathPrefix: "/api/search", Methods: []string{"get"}}},
+		{Name: "upload", Match: config.PolicyMatch{PathPrefix: "/api/upload"}},
+		{Name: "partner", Match: config.PolicyMatch{Hosts: []string{"*.partner.example"}}},
+		{Name: "beta", Match: config.PolicyMatch{Headers: map[string]string{"x-beta": "1", "X-Tenant": ""}}},
+		{Name: "all"},
+	})
+
+	require.Equal(t, []string{"search", "all"}, policies(httptest.NewRequest("GET", "http://api.example/api/search?q=go", nil)))
+	require.Equal(t, []string{"all"}, policies(httptest.NewRequest("POST", "http://api.example/api/search", nil)))
+	require.Equal(t, []string{"upload", "all"}, policies(httptest.NewRequest("PUT", "http://api.example/api/upload/1", nil)))
+
+	t.Run("Host", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "http://EU.Partner.example:8080/", nil)
+		require.Equal(t, []string{"partner", "all"}, policies(r))
+		r = httptest.NewRequest("GET", "http://partner.example/", nil)
+		require.Equal(t, []string{"all"}, policies(r), "wildcard needs a subdomain")
+	})
+
+	t.Run("Headers", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Beta", "1")
+		require.Equal(t, []string{"all"}, policies(r), "X-Tenant is missing")
+		r.Header.Set("X-Tenant", "acme")
+		require.Equal(t, []string{"beta", "all"}, policies(r))
+		r.Header.Set("X-Beta", "2")
+		require.Equal(t, []string{"all"}, policies(r))
+	})
+
+	require.Empty(t, NewPolicyFunc(nil)(httptest.NewRequest("GET", "/", nil)))
+}
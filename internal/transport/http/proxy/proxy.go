@@ -2,13 +2,13 @@ package proxy
 
 import (
     "context"
-    "net"
+    "io"
     "net/http"
     "net/http/httputil"
     "net/url"
+    "sync"
     "time"
 
-    "gopher-equalizer/internal/balancer"
     "gopher-equalizer/internal/logger"
     "gopher-equalizer/internal/errdefs"
     "gopher-equalizer/config"
@@ -18,43 +18,130 @@ import (
     "github.com/google/uuid"
 )
 
-const proxyErrorKey = "proxyErr"
+// выбранный бэкенд кладётся в контекст запроса в ServeHTTP,
+// его читают director и trackingTransport
+const backendKey = "proxyBackend"
 
 type Proxy struct {
     rp        *httputil.ReverseProxy
-    balancer  *balancer.Balancer
+    balancer  interfaces.IBalancer
+    health    interfaces.IHealthReporter
     bsrv interfaces.IBucketService
+    cost CostFunc
+    identity IdentityFunc
+    policies PolicyFunc
     cfg *config.Config
     logger *logger.Logger
 }
 
-type errorTransport struct {
-    base http.RoundTripper
+// trackingTransport сообщает балансировщику о начале и завершении
+// каждого запроса к бэкенду. Запрос считается завершённым, когда
+// ReverseProxy закрывает тело ответа, либо сразу при ошибке транспорта.
+// Задержкой бэкенда считается время до получения заголовков ответа.
+// Код ответа или ошибка транспорта уходят в пассивную проверку здоровья
+type trackingTransport struct {
+    base     http.RoundTripper
+    balancer interfaces.IBalancer
+    health   interfaces.IHealthReporter
 }
 
-func NewProxy(cfg *config.Config, bal *balancer.Balancer, bsrv interfaces.IBucketService, logger *logger.Logger) *Proxy {
-    transport := &http.Transport{
-        Proxy: http.ProxyFromEnvironment,
-        DialContext: (&net.Dialer{
-            Timeout:   time.Duration(cfg.Proxy.Timeout),
-            KeepAlive: time.Duration(cfg.Proxy.KeepAlive),
-        }).DialContext,
-        IdleConnTimeout:     time.Duration(cfg.Proxy.IdleConnTimeout),
-        MaxIdleConns:        cfg.Proxy.MaxIdleConns,
-        MaxIdleConnsPerHost: cfg.Proxy.MaxIdleConnsPerHost,
-        TLSHandshakeTimeout: time.Duration(cfg.Proxy.TLSHandshakeTimeout),
+func (t *trackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    backend, _ := req.Context().Value(backendKey).(string)
+
+    t.balancer.OnRequestStart(backend)
+    start := time.Now()
+    resp, err := t.base.RoundTrip(req)
+    if err != nil {
+        t.balancer.OnRequestDone(backend, err)
+        t.report(req.Context(), backend, 0, err)
+        return nil, err
+    }
+    t.balancer.ObserveLatency(backend, time.Since(start))
+    t.report(req.Context(), backend, resp.StatusCode, nil)
+
+    done := func(err error) {
+        t.balancer.OnRequestDone(backend, err)
+    }
+    // ReverseProxy пишет в тело ответа 101 как в соединение, поэтому
+    // обёртка должна остаться io.ReadWriteCloser
+    if rwc, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+        resp.Body = &trackedConn{ReadWriteCloser: rwc, done: done}
+        return resp, nil
+    }
+    resp.Body = &trackedBody{ReadCloser: resp.Body, done: done}
+    return resp, nil
+}
+
+func (t *trackingTransport) report(ctx context.Context, backend string, status int, err error) {
+    // клиент сам отменил запрос - бэкенд тут ни при чём
+    if errdefs.Is(err, context.Canceled) {
+        return
     }
+    if t.health != nil {
+        t.health.ReportResult(ctx, backend, status, err)
+    }
+}
+
+// trackedBody вызывает done ровно один раз при закрытии тела ответа.
+// Если бэкенд оборвал ответ посреди чтения, done получит эту ошибку
+type trackedBody struct {
+    io.ReadCloser
+    done    func(err error)
+    readErr error
+    once    sync.Once
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+    n, err := b.ReadCloser.Read(p)
+    if err != nil && err != io.EOF {
+        b.readErr = err
+    }
+    return n, err
+}
+
+func (b *trackedBody) Close() error {
+    err := b.ReadCloser.Close()
+    b.once.Do(func() { b.done(b.readErr) })
+    return err
+}
+
+// trackedConn - тело ответа 101 Switching Protocols, то есть само
+// соединение после смены протокола. Запрос длится, пока соединение открыто:
+// ReverseProxy закрывает его, когда одна из сторон отключилась. Ошибки чтения
+// здесь не учитываются - обрыв долгого соединения для бэкенда штатная ситуация
+type trackedConn struct {
+    io.ReadWriteCloser
+    done func(err error)
+    once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+    err := c.ReadWriteCloser.Close()
+    c.once.Do(func() { c.done(nil) })
+    return err
+}
+
+// health может быть nil, тогда пассивная проверка бэкендов не ведётся.
+// cost определяет, сколько токенов списать за запрос, identity - чей бакет,
+// policies - с каких бакетов клиента списывать
+func NewProxy(cfg *config.Config, bal interfaces.IBalancer, health interfaces.IHealthReporter, registry interfaces.IBackendRegistry, bsrv interfaces.IBucketService, cost CostFunc, identity IdentityFunc, policies PolicyFunc, logger *logger.Logger) *Proxy {
+    transport := newBackendTransport(cfg, registry)
 
     p := &Proxy{
         balancer:     bal,
+        health:       health,
         bsrv:    bsrv,
+        cost:    cost,
+        identity: identity,
+        policies: policies,
         logger:  logger,
     }
 
     p.rp = &httputil.ReverseProxy{
         Director:     p.director,
-        Transport:    transport,
+        Transport:    &trackingTransport{base: transport, balancer: bal, health: health},
         ErrorHandler: p.errHandler,
+        ModifyResponse: stripRateLimitHeaders,
     }
 
     return p
@@ -62,26 +149,18 @@ func NewProxy(cfg *config.Config, bal *balancer.Balancer, bsrv interfaces.IBucke
 
 func (p *Proxy) errHandler(w http.ResponseWriter, req *http.Request, err error) {
     if errdefs.Is(err, errdefs.ErrNoBackends) {
-        p.logger.Info(req.Context(), "no backends")
+        p.logger.Info(req.Context(), "no backends", zap.Error(err))
         http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
         return
     }
 
-    p.logger.Error(req.Context(), "PROXY: unexpected proxy error")
+    p.logger.Error(req.Context(), "PROXY: unexpected proxy error", zap.Error(err))
     http.Error(w, "Bad Gateway", http.StatusBadGateway)
 }
 
 func (p *Proxy) director(req *http.Request) {
-    ctx := GenerateRequestID(req.Context())
-    req = req.WithContext(ctx)
-    ctx = logger.SetLoggerInCtx(ctx, p.logger)
-
-    backend, err := p.balancer.NextBackend()
-    if err != nil {
-        p.logger.Info(ctx, "PROXY-DIRECTION: no available backends", zap.Error(err))
-        req = req.WithContext(context.WithValue(ctx, proxyErrorKey, err))
-        return
-    }
+    ctx := req.Context()
+    backend, _ := ctx.Value(backendKey).(string)
 
     target, _ := url.Parse(backend)
     req.URL.Scheme = target.Scheme
@@ -97,28 +176,46 @@ func (p *Proxy) director(req *http.Request) {
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
     ctx := logger.SetLoggerInCtx(r.Context(), p.logger)
     ctx = GenerateRequestID(ctx)
-    ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+    client, ok := p.identity(r)
+    if !ok {
+        p.logger.Info(ctx, "client identity not found", zap.String("remote_addr", r.RemoteAddr))
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
 
-    if err := p.bsrv.TryConsume(ctx, ip); err != nil {
-        p.logger.Info(ctx, "rate limit exceeded", zap.String("client_ip", ip), zap.Error(err))
+    cost := p.cost(r)
+    policies := p.policies(r)
+    res, err := p.bsrv.TryConsume(ctx, client, cost, policies...)
+    if res != nil {
+        setRateLimitHeaders(w.Header(), res, time.Now())
+    }
+    if err != nil {
+        p.logger.Info(ctx, "rate limit exceeded",
+            zap.String("client", client),
+            zap.Int("cost", cost),
+            zap.Strings("policies", policies),
+            zap.Error(err),
+        )
         http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
         return
     }
 
-    // backend, err := p.balancer.NextBackend()
-    // if err != nil {
-    //     p.logger.Info(ctx, "no backends available", zap.Error(err))
-    //     http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
-    //     return
-    // }
+    // Бэкенд выбираем здесь, а не в director: так прокси знает, на какой
+    // бэкенд ушёл запрос, и может сообщить балансировщику о его завершении
+    backend, err := p.balancer.NextBackend(r)
+    if err != nil {
+        p.errHandler(w, r.WithContext(ctx), err)
+        return
+    }
+    ctx = context.WithValue(ctx, backendKey, backend)
 
     p.logger.Info(ctx, "proxy to backend",
-        // zap.String("backend", backend),
+        zap.String("backend", backend),
         zap.String("method", r.Method),
         zap.String("path", r.URL.Path),
     )
 
-    p.rp.ServeHTTP(w, r)
+    p.rp.ServeHTTP(w, r.WithContext(ctx))
 }
 
 func GenerateRequestID(ctx context.Context) context.Context {
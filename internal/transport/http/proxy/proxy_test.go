@@ -0,0 +1,259 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/balancer"
+	"gopher-equalizer/internal/interfaces"
+	"gopher-equalizer/internal/logger"
+	"gopher-equalizer/internal/models"
+
+	"go.uber.org/zap"
+)
+
+type event struct {
+	kind    string
+	backend string
+	err     error
+}
+
+type fakeBalancer struct {
+	mu      sync.Mutex
+	events  []event
+	backend string
+}
+
+func (f *fakeBalancer) NextBackend(r *http.Request) (string, error) { return f.backend, nil }
+func (f *fakeBalancer) ResetBackends(backs []string)                {}
+func (f *fakeBalancer) InFlight(backend string) int                 { return 0 }
+func (f *fakeBalancer) SetWeight(backend string, weight int)        {}
+func (f *fakeBalancer) OnRequestStart(backend string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, event{kind: "start", backend: backend})
+}
+func (f *fakeBalancer) OnRequestDone(backend string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, event{kind: "done", backend: backend, err: err})
+}
+
+func (f *fakeBalancer) ObserveLatency(backend string, latency time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, event{kind: "latency", backend: backend})
+}
+
+func (f *fakeBalancer) kinds() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kinds := make([]string, 0, len(f.events))
+	for _, e := range f.events {
+		kinds = append(kinds, e.kind)
+	}
+	return kinds
+}
+
+type fakeHealth struct {
+	statuses []int
+	errs     []error
+}
+
+func (f *fakeHealth) ReportResult(ctx context.Context, backend string, status int, err error) {
+	f.statuses = append(f.statuses, status)
+	f.errs = append(f.errs, err)
+}
+
+// allowAll пропускает любой запрос, остальные методы сервиса прокси не нужны
+type allowAll struct {
+	interfaces.IBucketService
+}
+
+func (allowAll) TryConsume(ctx context.Context, clientID string, cost int, policies ...string) (*models.ConsumeResult, error) {
+	return &models.ConsumeResult{Allowed: true, Tokens: 1, Capacity: 1}, nil
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestTrackingTransport(t *testing.T) {
+	backend := "http://backend:8081"
+	newReq := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, backend+"/ping", nil)
+		return r.WithContext(context.WithValue(r.Context(), backendKey, backend))
+	}
+
+	t.Run("DoneOnBodyClose", func(t *testing.T) {
+		bal := &fakeBalancer{}
+		health := &fakeHealth{}
+		tr := &trackingTransport{
+			balancer: bal,
+			health:   health,
+			base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: 503, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+			}),
+		}
+
+		resp, err := tr.RoundTrip(newReq())
+		require.NoError(t, err)
+		// пока тело не закрыто, запрос считается активным
+		require.Equal(t, []event{
+			{kind: "start", backend: backend},
+			{kind: "latency", backend: backend},
+		}, bal.events)
+
+		_, _ = io.ReadAll(resp.Body)
+		require.NoError(t, resp.Body.Close())
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, []event{
+			{kind: "start", backend: backend},
+			{kind: "latency", backend: backend},
+			{kind: "done", backend: backend},
+		}, bal.events)
+		require.Equal(t, []int{503}, health.statuses)
+	})
+
+	t.Run("DoneOnTransportError", func(t *testing.T) {
+		bal := &fakeBalancer{}
+		health := &fakeHealth{}
+		dialErr := errors.New("connection refused")
+		tr := &trackingTransport{
+			balancer: bal,
+			health:   health,
+			base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				return nil, dialErr
+			}),
+		}
+
+		_, err := tr.RoundTrip(newReq())
+		require.ErrorIs(t, err, dialErr)
+		require.Equal(t, []event{
+			{kind: "start", backend: backend},
+			{kind: "done", backend: backend, err: dialErr},
+		}, bal.events)
+		require.Equal(t, []error{dialErr}, health.errs)
+	})
+}
+
+func TestBackendTransportTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, certPEM, 0o600))
+
+	cfg := &config.Config{}
+	cfg.Balancer.Backends = []config.BackendConfig{
+		{ID: "tls", URL: srv.URL, Weight: 1, TLS: &config.TLSConfig{CAFile: caFile}},
+		{ID: "no-ca", URL: "https://no-ca.local", Weight: 1},
+	}
+	registry := balancer.NewRegistry(cfg.Balancer.Backends)
+	bt := newBackendTransport(cfg, registry)
+
+	do := func(backend string) (*http.Response, error) {
+		r := httptest.NewRequest(http.MethodGet, srv.URL+"/", nil)
+		r.RequestURI = ""
+		return bt.RoundTrip(r.WithContext(context.WithValue(r.Context(), backendKey, backend)))
+	}
+
+	resp, err := do(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// без своего CA самоподписанный сертификат не принимается
+	_, err = do("https://no-ca.local")
+	require.Error(t, err)
+	require.Len(t, bt.transports, 1, "бэкенды без TLS идут через общий транспорт")
+
+	// удалённый бэкенд уносит свой транспорт с собой
+	_, err = registry.Remove("tls")
+	require.NoError(t, err)
+	require.Empty(t, bt.transports)
+
+	// тот же url с другими настройками TLS не получает старый транспорт
+	require.NoError(t, registry.Add(config.BackendConfig{ID: "tls", URL: srv.URL, Weight: 1, TLS: &config.TLSConfig{InsecureSkipVerify: true}}))
+	resp, err = do(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.True(t, bt.transports[srv.URL].tr.TLSClientConfig.InsecureSkipVerify)
+	require.Nil(t, bt.transports[srv.URL].tr.TLSClientConfig.RootCAs)
+}
+
+func TestProxyUpgrade(t *testing.T) {
+	// бэкенд переключается на эхо-протокол и возвращает всё, что получил
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = brw.Flush()
+		_, _ = io.Copy(conn, brw)
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{}
+	cfg.Balancer.Backends = []config.BackendConfig{{URL: backend.URL, Weight: 1}}
+	cfg.Logger.Config = zap.NewProductionConfig()
+	cfg.Logger.OutputPaths = nil
+	cfg.Logger.ErrorOutputPaths = nil
+	ctx, err := logger.New(context.Background(), cfg)
+	require.NoError(t, err)
+
+	cost, err := NewCostFunc(config.CostConfig{})
+	require.NoError(t, err)
+	bal := &fakeBalancer{backend: backend.URL}
+	identity := func(r *http.Request) (string, bool) { return "client", true }
+	p := NewProxy(cfg, bal, nil, balancer.NewRegistry(cfg.Balancer.Backends), allowAll{},
+		cost, identity, NewPolicyFunc(nil), logger.GetLoggerFromCtx(ctx))
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: proxy\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	_, err = io.WriteString(conn, "ping")
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(br, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	// пока соединение открыто, запрос к бэкенду считается активным
+	require.Equal(t, []string{"start", "latency"}, bal.kinds())
+	require.NoError(t, conn.Close())
+	require.Eventually(t, func() bool {
+		return len(bal.kinds()) == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"start", "latency", "done"}, bal.kinds())
+}
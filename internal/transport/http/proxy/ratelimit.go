@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopher-equalizer/internal/models"
+)
+
+// rateLimitHeaders - заголовки, которые прокси ставит сам. Такие же
+// заголовки бэкенда убираются, иначе клиент увидит два разных лимита
+var rateLimitHeaders = []string{
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-RateLimit-Reset",
+	"Retry-After",
+}
+
+// setRateLimitHeaders пишет состояние лимита клиента. RateLimit-* - по
+// черновику IETF, Reset в них - секунды до восстановления лимита.
+// X-RateLimit-* - устаревший вариант, Reset в нём - unix-время.
+// Retry-After ставится только отклонённому запросу
+func setRateLimitHeaders(h http.Header, res *models.ConsumeResult, now time.Time) {
+	limit := strconv.Itoa(res.Capacity)
+	remaining := strconv.Itoa(res.Tokens)
+	reset := seconds(res.Reset)
+
+	h.Set("RateLimit-Limit", limit)
+	h.Set("RateLimit-Remaining", remaining)
+	h.Set("RateLimit-Reset", strconv.FormatInt(reset, 10))
+	h.Set("X-RateLimit-Limit", limit)
+	h.Set("X-RateLimit-Remaining", remaining)
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(now.Unix()+reset, 10))
+	if !res.Allowed && res.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.FormatInt(max(seconds(res.RetryAfter), 1), 10))
+	}
+}
+
+// seconds округляет вверх: клиент, пришедший ровно через столько секунд,
+// не должен снова получить 429
+func seconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// stripRateLimitHeaders - ModifyResponse прокси
+func stripRateLimitHeaders(resp *http.Response) error {
+	for _, name := range rateLimitHeaders {
+		resp.Header.Del(name)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gopher-equalizer/internal/models"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	t.Run("Allowed", func(t *testing.T) {
+		h := http.Header{}
+		setRateLimitHeaders(h, &models.ConsumeResult{
+			Allowed:  true,
+			Tokens:   7,
+			Capacity: 10,
+			Reset:    2500 * time.Millisecond,
+		}, now)
+
+		require.Equal(t, "10", h.Get("RateLimit-Limit"))
+		require.Equal(t, "7", h.Get("RateLimit-Remaining"))
+		require.Equal(t, "3", h.Get("RateLimit-Reset"), "секунды округляются вверх")
+		require.Equal(t, "10", h.Get("X-RateLimit-Limit"))
+		require.Equal(t, "7", h.Get("X-RateLimit-Remaining"))
+		require.Equal(t, "1700000003", h.Get("X-RateLimit-Reset"))
+		require.Empty(t, h.Get("Retry-After"))
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		h := http.Header{}
+		setRateLimitHeaders(h, &models.ConsumeResult{
+			Capacity:   10,
+			Reset:      time.Minute,
+			RetryAfter: 200 * time.Millisecond,
+		}, now)
+
+		require.Equal(t, "0", h.Get("RateLimit-Remaining"))
+		require.Equal(t, "60", h.Get("RateLimit-Reset"))
+		require.Equal(t, "1", h.Get("Retry-After"))
+	})
+
+	t.Run("BackendHeadersStripped", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("RateLimit-Remaining", "100")
+		resp.Header.Set("Retry-After", "5")
+		resp.Header.Set("Content-Type", "text/plain")
+
+		require.NoError(t, stripRateLimitHeaders(resp))
+		require.Empty(t, resp.Header.Get("RateLimit-Remaining"))
+		require.Empty(t, resp.Header.Get("Retry-After"))
+		require.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	})
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopher-equalizer/config"
+	"gopher-equalizer/internal/interfaces"
+)
+
+// backendTransport выбирает http.Transport по бэкенду запроса: у https-бэкенда
+// может быть свой CA или отключённая проверка сертификата. Бэкенды без
+// настроек TLS делят один общий транспорт. Транспорт бэкенда с TLS живёт,
+// пока бэкенд в пуле с теми же настройками
+type backendTransport struct {
+	registry   interfaces.IBackendRegistry
+	base       *http.Transport
+	mu         sync.Mutex
+	transports map[string]tlsTransport
+}
+
+// tlsTransport - копия base со своим TLS и настройки, из которых она собрана
+type tlsTransport struct {
+	tr  *http.Transport
+	cfg config.TLSConfig
+}
+
+func newBackendTransport(cfg *config.Config, registry interfaces.IBackendRegistry) *backendTransport {
+	bt := &backendTransport{
+		registry: registry,
+		base: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   time.Duration(cfg.Proxy.Timeout),
+				KeepAlive: time.Duration(cfg.Proxy.KeepAlive),
+			}).DialContext,
+			IdleConnTimeout:     time.Duration(cfg.Proxy.IdleConnTimeout),
+			MaxIdleConns:        cfg.Proxy.MaxIdleConns,
+			MaxIdleConnsPerHost: cfg.Proxy.MaxIdleConnsPerHost,
+			TLSHandshakeTimeout: time.Duration(cfg.Proxy.TLSHandshakeTimeout),
+		},
+		transports: make(map[string]tlsTransport),
+	}
+	registry.Subscribe(bt.sync)
+	return bt
+}
+
+func (bt *backendTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backend, _ := req.Context().Value(backendKey).(string)
+	tr, err := bt.transport(backend)
+	if err != nil {
+		return nil, err
+	}
+	return tr.RoundTrip(req)
+}
+
+func (bt *backendTransport) transport(backend string) (*http.Transport, error) {
+	b, ok := bt.registry.Lookup(backend)
+	if !ok || b.TLS == nil {
+		return bt.base, nil
+	}
+
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if t, ok := bt.transports[backend]; ok && t.cfg == *b.TLS {
+		return t.tr, nil
+	}
+	tlsCfg, err := b.TLS.Build()
+	if err != nil {
+		return nil, fmt.Errorf("backend %s tls config: %w", backend, err)
+	}
+	tr := bt.base.Clone()
+	tr.TLSClientConfig = tlsCfg
+	if old, ok := bt.transports[backend]; ok {
+		old.tr.CloseIdleConnections()
+	}
+	bt.transports[backend] = tlsTransport{tr: tr, cfg: *b.TLS}
+	return tr, nil
+}
+
+// sync - подписчик реестра: закрывает соединения и выкидывает транспорты
+// бэкендов, которые удалили из пула или добавили заново с другим TLS
+func (bt *backendTransport) sync() {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	for backend, t := range bt.transports {
+		b, ok := bt.registry.Lookup(backend)
+		if ok && b.TLS != nil && *b.TLS == t.cfg {
+			continue
+		}
+		t.tr.CloseIdleConnections()
+		delete(bt.transports, backend)
+	}
+}
@@ -0,0 +1,84 @@
+package strategies
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const defaultVirtualNodes = 160
+
+// ConsistentHash - кольцо с виртуальными узлами. Один и тот же ключ
+// (ip клиента, заголовок, cookie...) всегда попадает на один бэкенд,
+// а при изменении пула переезжает только ~1/N ключей
+type ConsistentHash struct {
+	virtualNodes int
+	servers      []string
+	ring         []uint32
+	owners       map[uint32]string
+	index        int
+	mu           sync.RWMutex
+}
+
+// NewConsistentHash создаёт кольцо, virtualNodes <= 0 - значение по умолчанию
+func NewConsistentHash(servers []string, virtualNodes int) *ConsistentHash {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+	ch := &ConsistentHash{virtualNodes: virtualNodes}
+	ch.ResetBackends(servers)
+	return ch
+}
+
+// NextFor возвращает бэкенд, которому принадлежит ключ
+func (ch *ConsistentHash) NextFor(key string) (string, error) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	if len(ch.ring) == 0 {
+		return "", fmt.Errorf("no backends available")
+	}
+
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(ch.ring), func(i int) bool { return ch.ring[i] >= h })
+	if i == len(ch.ring) {
+		i = 0
+	}
+	return ch.owners[ch.ring[i]], nil
+}
+
+// Next без ключа привязки нет, поэтому просто идём по кругу
+func (ch *ConsistentHash) Next() (string, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if len(ch.servers) == 0 {
+		return "", fmt.Errorf("no backends available")
+	}
+	server := ch.servers[ch.index%len(ch.servers)]
+	ch.index = (ch.index + 1) % len(ch.servers)
+	return server, nil
+}
+
+func (ch *ConsistentHash) ResetBackends(backs []string) {
+	ring := make([]uint32, 0, len(backs)*ch.virtualNodes)
+	owners := make(map[uint32]string, len(backs)*ch.virtualNodes)
+	for _, s := range backs {
+		for i := 0; i < ch.virtualNodes; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + s))
+			// при коллизии узел остаётся за первым сервером
+			if _, ok := owners[h]; ok {
+				continue
+			}
+			owners[h] = s
+			ring = append(ring, h)
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i] < ring[j] })
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.servers = backs
+	ch.ring = ring
+	ch.owners = owners
+}
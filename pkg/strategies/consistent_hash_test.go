@@ -0,0 +1,90 @@
+package strategies
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsistentHash(t *testing.T) {
+	servers := []string{"a", "b", "c", "d"}
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = "client-" + strconv.Itoa(i)
+	}
+
+	t.Run("SameKeySameBackend", func(t *testing.T) {
+		ch := NewConsistentHash(servers, 0)
+		for _, k := range keys[:100] {
+			first, err := ch.NextFor(k)
+			require.NoError(t, err)
+			for i := 0; i < 3; i++ {
+				again, _ := ch.NextFor(k)
+				require.Equal(t, first, again)
+			}
+		}
+	})
+
+	t.Run("EvenDistribution", func(t *testing.T) {
+		ch := NewConsistentHash(servers, 0)
+		got := map[string]int{}
+		for _, k := range keys {
+			s, _ := ch.NextFor(k)
+			got[s]++
+		}
+		// с виртуальными узлами каждый бэкенд получает примерно 1/N ключей
+		for _, s := range servers {
+			require.InDelta(t, len(keys)/len(servers), got[s], float64(len(keys))*0.1, "backend %s", s)
+		}
+	})
+
+	t.Run("RemoveBackendMovesOnlyItsKeys", func(t *testing.T) {
+		ch := NewConsistentHash(servers, 0)
+		before := map[string]string{}
+		for _, k := range keys {
+			before[k], _ = ch.NextFor(k)
+		}
+
+		ch.ResetBackends([]string{"a", "b", "d"})
+		moved := 0
+		for _, k := range keys {
+			after, _ := ch.NextFor(k)
+			if before[k] != "c" {
+				require.Equal(t, before[k], after, "key %s moved from a live backend", k)
+				continue
+			}
+			require.NotEqual(t, "c", after)
+			moved++
+		}
+		require.InDelta(t, len(keys)/len(servers), moved, float64(len(keys))*0.1)
+	})
+
+	t.Run("AddBackendMovesAboutOneNth", func(t *testing.T) {
+		ch := NewConsistentHash(servers, 0)
+		before := map[string]string{}
+		for _, k := range keys {
+			before[k], _ = ch.NextFor(k)
+		}
+
+		ch.ResetBackends(append([]string{"e"}, servers...))
+		moved := 0
+		for _, k := range keys {
+			after, _ := ch.NextFor(k)
+			if after != before[k] {
+				// ключи переезжают только на новый бэкенд
+				require.Equal(t, "e", after)
+				moved++
+			}
+		}
+		require.InDelta(t, len(keys)/5, moved, float64(len(keys))*0.1)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		ch := NewConsistentHash(nil, 0)
+		_, err := ch.NextFor("x")
+		require.Error(t, err)
+		_, err = ch.Next()
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,66 @@
+package strategies
+
+import (
+	"fmt"
+	"sync"
+)
+
+// LeastConnections выбирает бэкенд с наименьшим числом запросов "в полёте".
+// Счётчики ведутся через OnRequestStart/OnRequestDone, которые вызывает прокси.
+// При равенстве бэкенды перебираются по кругу, чтобы нагрузка не липла к первому
+type LeastConnections struct {
+	servers  []string
+	inFlight map[string]int
+	index    int
+	mu       sync.Mutex
+}
+
+func NewLeastConnections(servers []string) *LeastConnections {
+	return &LeastConnections{
+		servers:  servers,
+		inFlight: make(map[string]int, len(servers)),
+	}
+}
+
+func (lc *LeastConnections) Next() (string, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if len(lc.servers) == 0 {
+		return "", fmt.Errorf("no backends available")
+	}
+
+	n := len(lc.servers)
+	best := lc.servers[lc.index%n]
+	for i := 1; i < n; i++ {
+		s := lc.servers[(lc.index+i)%n]
+		if lc.inFlight[s] < lc.inFlight[best] {
+			best = s
+		}
+	}
+	lc.index = (lc.index + 1) % n
+	return best, nil
+}
+
+func (lc *LeastConnections) ResetBackends(backs []string) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.servers = backs
+}
+
+func (lc *LeastConnections) OnRequestStart(backend string) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.inFlight[backend]++
+}
+
+func (lc *LeastConnections) OnRequestDone(backend string, err error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	// счётчик не удаляем при ResetBackends: запросы к выбывшему
+	// бэкенду всё равно должны корректно завершиться
+	if lc.inFlight[backend] > 1 {
+		lc.inFlight[backend]--
+	} else {
+		delete(lc.inFlight, backend)
+	}
+}
@@ -0,0 +1,60 @@
+package strategies
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeastConnections(t *testing.T) {
+	t.Run("PicksLeastLoaded", func(t *testing.T) {
+		lc := NewLeastConnections([]string{"a", "b", "c"})
+
+		lc.OnRequestStart("a")
+		lc.OnRequestStart("a")
+		lc.OnRequestStart("b")
+
+		s, err := lc.Next()
+		require.NoError(t, err)
+		require.Equal(t, "c", s)
+
+		// c занят сильнее всех, теперь минимум у b
+		lc.OnRequestStart("c")
+		lc.OnRequestStart("c")
+		s, _ = lc.Next()
+		require.Equal(t, "b", s)
+
+		// завершение запросов (в том числе с ошибкой) уменьшает счётчик
+		lc.OnRequestDone("a", nil)
+		lc.OnRequestDone("a", errors.New("connection reset"))
+		s, _ = lc.Next()
+		require.Equal(t, "a", s)
+	})
+
+	t.Run("RoundRobinOnTie", func(t *testing.T) {
+		lc := NewLeastConnections([]string{"a", "b", "c"})
+
+		var seq []string
+		for i := 0; i < 6; i++ {
+			s, err := lc.Next()
+			require.NoError(t, err)
+			seq = append(seq, s)
+		}
+		require.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, seq)
+	})
+
+	t.Run("ResetBackends", func(t *testing.T) {
+		lc := NewLeastConnections([]string{"a", "b"})
+		lc.OnRequestStart("b")
+
+		lc.ResetBackends([]string{"b"})
+		s, err := lc.Next()
+		require.NoError(t, err)
+		require.Equal(t, "b", s)
+
+		lc.ResetBackends(nil)
+		_, err = lc.Next()
+		require.Error(t, err)
+	})
+}
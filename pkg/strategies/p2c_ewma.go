@@ -0,0 +1,169 @@
+package strategies
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEWMADecay   = 10 * time.Second
+	defaultEWMAPenalty = time.Second
+)
+
+type ewmaStat struct {
+	cost     float64 // peak-EWMA задержки в наносекундах
+	stamp    time.Time
+	inFlight int
+}
+
+// P2CEWMA - power of two choices: берёт два случайных бэкенда и отправляет
+// запрос на тот, у кого меньше score = peakEWMA(latency) * (inFlight + 1).
+// Peak-EWMA сразу подскакивает на медленный ответ и плавно остывает,
+// поэтому тормозящий бэкенд быстро перестаёт получать трафик
+type P2CEWMA struct {
+	servers []string
+	stats   map[string]*ewmaStat
+	decay   time.Duration
+	penalty time.Duration
+	rnd     *rand.Rand
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// NewP2CEWMA создаёт стратегию. decay - постоянная времени затухания EWMA,
+// penalty - задержка, которая засчитывается бэкенду при ошибке транспорта.
+// rnd можно передать для детерминированных тестов, nil - случайный seed
+func NewP2CEWMA(servers []string, decay, penalty time.Duration, rnd *rand.Rand) *P2CEWMA {
+	if decay <= 0 {
+		decay = defaultEWMADecay
+	}
+	if penalty <= 0 {
+		penalty = defaultEWMAPenalty
+	}
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return &P2CEWMA{
+		servers: servers,
+		stats:   make(map[string]*ewmaStat, len(servers)),
+		decay:   decay,
+		penalty: penalty,
+		rnd:     rnd,
+		now:     time.Now,
+	}
+}
+
+func (p *P2CEWMA) Next() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.servers)
+	if n == 0 {
+		return "", fmt.Errorf("no backends available")
+	}
+	if n == 1 {
+		return p.servers[0], nil
+	}
+
+	i := p.rnd.Intn(n)
+	j := p.rnd.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+
+	a, b := p.servers[i], p.servers[j]
+	now := p.now()
+	if p.score(b, now) < p.score(a, now) {
+		return b, nil
+	}
+	return a, nil
+}
+
+// ResetBackends забывает статистику выбывших бэкендов, чтобы удалённый из
+// пула адрес не копился в stats, а добавленный заново не получил старую
+// задержку. Бэкенд с незавершёнными запросами забывается, когда закончится
+// последний из них
+func (p *P2CEWMA) ResetBackends(backs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.servers = backs
+	for backend, s := range p.stats {
+		if s.inFlight == 0 && !slices.Contains(backs, backend) {
+			delete(p.stats, backend)
+		}
+	}
+}
+
+func (p *P2CEWMA) OnRequestStart(backend string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stat(backend).inFlight++
+}
+
+func (p *P2CEWMA) OnRequestDone(backend string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stat(backend)
+	if s.inFlight > 0 {
+		s.inFlight--
+	}
+	if s.inFlight == 0 && !slices.Contains(p.servers, backend) {
+		delete(p.stats, backend)
+		return
+	}
+	if err != nil {
+		p.observe(s, p.penalty)
+	}
+}
+
+// ObserveLatency учитывает время ответа бэкенда
+func (p *P2CEWMA) ObserveLatency(backend string, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.observe(p.stat(backend), latency)
+}
+
+func (p *P2CEWMA) observe(s *ewmaStat, latency time.Duration) {
+	now := p.now()
+	rtt := float64(latency)
+	if rtt > s.cost || s.stamp.IsZero() {
+		s.cost = rtt
+	} else {
+		w := p.weight(s, now)
+		s.cost = s.cost*w + rtt*(1-w)
+	}
+	s.stamp = now
+}
+
+// У бэкенда без наблюдений задержка минимальна, так что новый или давно
+// простаивающий бэкенд сразу получает трафик и набирает статистику.
+// Нижняя граница в 1нс нужна, чтобы при нулевой задержке решали inFlight
+func (p *P2CEWMA) score(backend string, now time.Time) float64 {
+	s, ok := p.stats[backend]
+	if !ok {
+		return 1
+	}
+	cost := math.Max(s.cost*p.weight(s, now), 1)
+	return cost * float64(s.inFlight+1)
+}
+
+func (p *P2CEWMA) weight(s *ewmaStat, now time.Time) float64 {
+	elapsed := now.Sub(s.stamp)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Exp(-float64(elapsed) / float64(p.decay))
+}
+
+func (p *P2CEWMA) stat(backend string) *ewmaStat {
+	s, ok := p.stats[backend]
+	if !ok {
+		s = &ewmaStat{}
+		p.stats[backend] = s
+	}
+	return s
+}
@@ -0,0 +1,112 @@
+package strategies
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestP2CEWMA(t *testing.T) {
+	t.Run("PrefersFasterBackend", func(t *testing.T) {
+		p := NewP2CEWMA([]string{"fast", "slow"}, 0, 0, rand.New(rand.NewSource(1)))
+		p.ObserveLatency("fast", 10*time.Millisecond)
+		p.ObserveLatency("slow", 500*time.Millisecond)
+
+		for i := 0; i < 100; i++ {
+			s, err := p.Next()
+			require.NoError(t, err)
+			require.Equal(t, "fast", s)
+		}
+	})
+
+	t.Run("InFlightMultipliesScore", func(t *testing.T) {
+		p := NewP2CEWMA([]string{"a", "b"}, 0, 0, rand.New(rand.NewSource(1)))
+		p.ObserveLatency("a", 10*time.Millisecond)
+		p.ObserveLatency("b", 30*time.Millisecond)
+
+		// 10ms * 5 > 30ms * 1
+		for i := 0; i < 4; i++ {
+			p.OnRequestStart("a")
+		}
+		s, _ := p.Next()
+		require.Equal(t, "b", s)
+
+		for i := 0; i < 4; i++ {
+			p.OnRequestDone("a", nil)
+		}
+		s, _ = p.Next()
+		require.Equal(t, "a", s)
+	})
+
+	t.Run("PeakAndDecay", func(t *testing.T) {
+		now := time.Unix(0, 0)
+		p := NewP2CEWMA([]string{"a"}, 10*time.Second, 0, nil)
+		p.now = func() time.Time { return now }
+
+		p.ObserveLatency("a", 10*time.Millisecond)
+		// медленный ответ сразу поднимает оценку до пика
+		p.ObserveLatency("a", 200*time.Millisecond)
+		require.Equal(t, float64(200*time.Millisecond), p.stats["a"].cost)
+
+		// быстрый ответ через одну постоянную времени тянет её вниз на 1-1/e
+		now = now.Add(10 * time.Second)
+		p.ObserveLatency("a", 10*time.Millisecond)
+		require.InDelta(t, 80*float64(time.Millisecond), p.stats["a"].cost, float64(time.Millisecond))
+	})
+
+	t.Run("ErrorPenalty", func(t *testing.T) {
+		p := NewP2CEWMA([]string{"a", "b"}, 0, time.Second, rand.New(rand.NewSource(1)))
+		p.ObserveLatency("a", 10*time.Millisecond)
+		p.ObserveLatency("b", 50*time.Millisecond)
+
+		p.OnRequestStart("a")
+		p.OnRequestDone("a", errors.New("connection refused"))
+		require.Equal(t, 0, p.stats["a"].inFlight)
+
+		s, _ := p.Next()
+		require.Equal(t, "b", s)
+	})
+
+	t.Run("SampleDistinctBackends", func(t *testing.T) {
+		p := NewP2CEWMA([]string{"a", "b", "c"}, 0, 0, rand.New(rand.NewSource(42)))
+		p.ObserveLatency("a", 100*time.Millisecond)
+		p.ObserveLatency("b", 100*time.Millisecond)
+		p.ObserveLatency("c", time.Second)
+
+		// c выигрывает только если выбран в паре сам с собой,
+		// поэтому при выборе двух разных бэкендов он не появится
+		for i := 0; i < 100; i++ {
+			s, _ := p.Next()
+			require.NotEqual(t, "c", s)
+		}
+	})
+
+	t.Run("ForgetsRemovedBackends", func(t *testing.T) {
+		p := NewP2CEWMA([]string{"a", "b", "c"}, 0, 0, nil)
+		p.ObserveLatency("a", 10*time.Millisecond)
+		p.ObserveLatency("b", 500*time.Millisecond)
+		p.ObserveLatency("c", 20*time.Millisecond)
+		p.OnRequestStart("c")
+
+		p.ResetBackends([]string{"a"})
+		require.Contains(t, p.stats, "a")
+		require.NotContains(t, p.stats, "b")
+		// статистика c живёт, пока не завершится начатый запрос
+		require.Equal(t, 1, p.stats["c"].inFlight)
+		p.OnRequestDone("c", errors.New("connection reset"))
+		require.NotContains(t, p.stats, "c")
+
+		// вернувшийся b начинает с чистой статистики
+		p.ResetBackends([]string{"a", "b"})
+		require.NotContains(t, p.stats, "b")
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		p := NewP2CEWMA(nil, 0, 0, nil)
+		_, err := p.Next()
+		require.Error(t, err)
+	})
+}
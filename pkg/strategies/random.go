@@ -0,0 +1,115 @@
+package strategies
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Random выбирает бэкенд равновероятно.
+// Источник случайности можно передать снаружи, чтобы тесты были детерминированными
+type Random struct {
+	servers []string
+	rnd     *rand.Rand
+	mu      sync.Mutex
+}
+
+// NewRandom создаёт стратегию, rnd == nil - источник со случайным seed
+func NewRandom(servers []string, rnd *rand.Rand) *Random {
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return &Random{servers: servers, rnd: rnd}
+}
+
+func (r *Random) Next() (string, error) {
+	// *rand.Rand не потокобезопасен, поэтому обычный мьютекс, а не RW
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.servers) == 0 {
+		return "", fmt.Errorf("no backends available")
+	}
+	return r.servers[r.rnd.Intn(len(r.servers))], nil
+}
+
+func (r *Random) ResetBackends(backs []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.servers = backs
+}
+
+// WeightedRandom выбирает бэкенд с вероятностью weight / sum(weights)
+type WeightedRandom struct {
+	servers []string
+	// cumulative[i] - сумма весов servers[0..i]
+	cumulative []int
+	weights    map[string]int
+	rnd        *rand.Rand
+	mu         sync.Mutex
+}
+
+// NewWeightedRandom создаёт стратегию. Сервер без веса считается с весом 1,
+// rnd == nil - источник со случайным seed
+func NewWeightedRandom(servers []string, weights map[string]int, rnd *rand.Rand) *WeightedRandom {
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if weights == nil {
+		weights = make(map[string]int)
+	}
+	wr := &WeightedRandom{weights: weights, rnd: rnd}
+	wr.ResetBackends(servers)
+	return wr
+}
+
+func (wr *WeightedRandom) Next() (string, error) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if len(wr.servers) == 0 {
+		return "", fmt.Errorf("no backends available")
+	}
+
+	x := wr.rnd.Intn(wr.cumulative[len(wr.cumulative)-1])
+	// бинарный поиск первого cumulative[i] > x
+	lo, hi := 0, len(wr.cumulative)-1
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if wr.cumulative[mid] > x {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return wr.servers[lo], nil
+}
+
+func (wr *WeightedRandom) ResetBackends(backs []string) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.servers = backs
+	wr.rebuild()
+}
+
+// SetWeight меняет вес сервера, в том числе ещё не попавшего в пул
+func (wr *WeightedRandom) SetWeight(server string, weight int) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.weights[server] = weight
+	wr.rebuild()
+}
+
+// rebuild пересчитывает cumulative, wr.mu уже захвачен
+func (wr *WeightedRandom) rebuild() {
+	cumulative := make([]int, 0, len(wr.servers))
+	total := 0
+	for _, s := range wr.servers {
+		w := wr.weights[s]
+		if w <= 0 {
+			w = 1
+		}
+		total += w
+		cumulative = append(cumulative, total)
+	}
+	wr.cumulative = cumulative
+}
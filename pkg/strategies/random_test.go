@@ -0,0 +1,104 @@
+package strategies
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRandom(t *testing.T) {
+	t.Run("Deterministic", func(t *testing.T) {
+		servers := []string{"a", "b", "c"}
+		r1 := NewRandom(servers, rand.New(rand.NewSource(7)))
+		r2 := NewRandom(servers, rand.New(rand.NewSource(7)))
+
+		for i := 0; i < 50; i++ {
+			s1, err := r1.Next()
+			require.NoError(t, err)
+			s2, _ := r2.Next()
+			require.Equal(t, s1, s2)
+		}
+	})
+
+	t.Run("Uniform", func(t *testing.T) {
+		r := NewRandom([]string{"a", "b", "c", "d"}, rand.New(rand.NewSource(1)))
+
+		n := 40000
+		got := map[string]int{}
+		for i := 0; i < n; i++ {
+			s, _ := r.Next()
+			got[s]++
+		}
+		for _, s := range []string{"a", "b", "c", "d"} {
+			require.InDelta(t, n/4, got[s], float64(n)*0.02, "backend %s", s)
+		}
+	})
+
+	t.Run("ResetBackends", func(t *testing.T) {
+		r := NewRandom([]string{"a", "b"}, nil)
+		r.ResetBackends([]string{"b"})
+		s, err := r.Next()
+		require.NoError(t, err)
+		require.Equal(t, "b", s)
+
+		r.ResetBackends(nil)
+		_, err = r.Next()
+		require.Error(t, err)
+	})
+}
+
+func TestWeightedRandom(t *testing.T) {
+	t.Run("DistributionMatchesWeights", func(t *testing.T) {
+		weights := map[string]int{"a": 6, "b": 3, "c": 1}
+		wr := NewWeightedRandom([]string{"a", "b", "c"}, weights, rand.New(rand.NewSource(1)))
+
+		n := 100000
+		got := map[string]int{}
+		for i := 0; i < n; i++ {
+			s, err := wr.Next()
+			require.NoError(t, err)
+			got[s]++
+		}
+		for s, w := range weights {
+			require.InDelta(t, n/10*w, got[s], float64(n)*0.01, "backend %s", s)
+		}
+	})
+
+	t.Run("Deterministic", func(t *testing.T) {
+		weights := map[string]int{"a": 2, "b": 5}
+		w1 := NewWeightedRandom([]string{"a", "b"}, weights, rand.New(rand.NewSource(3)))
+		w2 := NewWeightedRandom([]string{"a", "b"}, weights, rand.New(rand.NewSource(3)))
+		for i := 0; i < 50; i++ {
+			s1, _ := w1.Next()
+			s2, _ := w2.Next()
+			require.Equal(t, s1, s2)
+		}
+	})
+
+	t.Run("ResetBackendsKeepsWeights", func(t *testing.T) {
+		wr := NewWeightedRandom([]string{"a", "b", "c"}, map[string]int{"a": 1, "b": 1, "c": 1000}, rand.New(rand.NewSource(1)))
+		wr.ResetBackends([]string{"a", "b"})
+
+		for i := 0; i < 100; i++ {
+			s, _ := wr.Next()
+			require.NotEqual(t, "c", s)
+		}
+
+		wr.ResetBackends(nil)
+		_, err := wr.Next()
+		require.Error(t, err)
+	})
+
+	t.Run("SetWeight", func(t *testing.T) {
+		wr := NewWeightedRandom([]string{"a", "b"}, nil, rand.New(rand.NewSource(1)))
+		wr.SetWeight("b", 1000)
+
+		got := map[string]int{}
+		for i := 0; i < 1000; i++ {
+			s, _ := wr.Next()
+			got[s]++
+		}
+		require.Greater(t, got["b"], 980)
+	})
+}
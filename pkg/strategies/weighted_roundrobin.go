@@ -0,0 +1,93 @@
+package strategies
+
+import (
+	"fmt"
+	"sync"
+)
+
+type weightedPeer struct {
+	server        string
+	weight        int
+	currentWeight int
+}
+
+// WeightedRoundRobin - плавный (smooth) взвешенный round robin, как в nginx.
+// Бэкенд с весом 5 получает 5 запросов из каждых sum(weights),
+// но они перемешаны с остальными, а не идут пачкой подряд
+type WeightedRoundRobin struct {
+	peers   []*weightedPeer
+	weights map[string]int
+	mu      sync.Mutex
+}
+
+// NewWeightedRoundRobin принимает список серверов и их веса.
+// Сервер без веса (или с весом <= 0) считается с весом 1
+func NewWeightedRoundRobin(servers []string, weights map[string]int) *WeightedRoundRobin {
+	if weights == nil {
+		weights = make(map[string]int)
+	}
+	wrr := &WeightedRoundRobin{weights: weights}
+	wrr.ResetBackends(servers)
+	return wrr
+}
+
+func (wrr *WeightedRoundRobin) Next() (string, error) {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+	if len(wrr.peers) == 0 {
+		return "", fmt.Errorf("no backends available")
+	}
+
+	total := 0
+	var best *weightedPeer
+	for _, p := range wrr.peers {
+		p.currentWeight += p.weight
+		total += p.weight
+		if best == nil || p.currentWeight > best.currentWeight {
+			best = p
+		}
+	}
+	best.currentWeight -= total
+	return best.server, nil
+}
+
+// ResetBackends пересобирает пул, сохраняя текущие веса тех серверов,
+// которые в нём остались, чтобы чередование не сбивалось после health-check
+func (wrr *WeightedRoundRobin) ResetBackends(backs []string) {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+
+	current := make(map[string]int, len(wrr.peers))
+	for _, p := range wrr.peers {
+		current[p.server] = p.currentWeight
+	}
+
+	peers := make([]*weightedPeer, 0, len(backs))
+	for _, s := range backs {
+		w := wrr.weights[s]
+		if w <= 0 {
+			w = 1
+		}
+		peers = append(peers, &weightedPeer{
+			server:        s,
+			weight:        w,
+			currentWeight: current[s],
+		})
+	}
+	wrr.peers = peers
+}
+
+// SetWeight меняет вес сервера, в том числе ещё не попавшего в пул
+func (wrr *WeightedRoundRobin) SetWeight(server string, weight int) {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+	if weight <= 0 {
+		weight = 1
+	}
+	wrr.weights[server] = weight
+	for _, p := range wrr.peers {
+		if p.server == server {
+			p.weight = weight
+		}
+	}
+}
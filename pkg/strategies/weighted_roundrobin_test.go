@@ -0,0 +1,80 @@
+package strategies
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeightedRoundRobin(t *testing.T) {
+	t.Run("DistributionMatchesWeights", func(t *testing.T) {
+		weights := map[string]int{"a": 5, "b": 1, "c": 2}
+		wrr := NewWeightedRoundRobin([]string{"a", "b", "c"}, weights)
+
+		// 100 полных циклов по sum(weights) = 8
+		n := 800
+		got := map[string]int{}
+		for i := 0; i < n; i++ {
+			s, err := wrr.Next()
+			require.NoError(t, err)
+			got[s]++
+		}
+
+		for s, w := range weights {
+			require.Equal(t, n/8*w, got[s], "backend %s", s)
+		}
+	})
+
+	t.Run("SmoothInterleaving", func(t *testing.T) {
+		wrr := NewWeightedRoundRobin([]string{"a", "b", "c"}, map[string]int{"a": 5, "b": 1, "c": 1})
+
+		var seq []string
+		for i := 0; i < 7; i++ {
+			s, err := wrr.Next()
+			require.NoError(t, err)
+			seq = append(seq, s)
+		}
+		// классическая последовательность nginx для весов {5, 1, 1}
+		require.Equal(t, []string{"a", "a", "b", "a", "c", "a", "a"}, seq)
+	})
+
+	t.Run("DefaultWeight", func(t *testing.T) {
+		wrr := NewWeightedRoundRobin([]string{"a", "b"}, nil)
+
+		got := map[string]int{}
+		for i := 0; i < 10; i++ {
+			s, _ := wrr.Next()
+			got[s]++
+		}
+		require.Equal(t, map[string]int{"a": 5, "b": 5}, got)
+	})
+
+	t.Run("ResetBackends", func(t *testing.T) {
+		wrr := NewWeightedRoundRobin([]string{"a", "b"}, map[string]int{"a": 3, "b": 1})
+
+		wrr.ResetBackends([]string{"b"})
+		for i := 0; i < 3; i++ {
+			s, err := wrr.Next()
+			require.NoError(t, err)
+			require.Equal(t, "b", s)
+		}
+
+		wrr.ResetBackends(nil)
+		_, err := wrr.Next()
+		require.Error(t, err)
+	})
+
+	t.Run("SetWeight", func(t *testing.T) {
+		wrr := NewWeightedRoundRobin([]string{"a"}, nil)
+		// вес бэкенда, добавленного на лету, задаётся до того, как он попал в пул
+		wrr.SetWeight("b", 3)
+		wrr.ResetBackends([]string{"a", "b"})
+
+		got := map[string]int{}
+		for i := 0; i < 8; i++ {
+			s, _ := wrr.Next()
+			got[s]++
+		}
+		require.Equal(t, map[string]int{"a": 2, "b": 6}, got)
+	})
+}